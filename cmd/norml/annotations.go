@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/kanwren/norml/pkg/normalizer"
+)
+
+// fileError is an error normalizing a file.
+type fileError struct {
+	Filename string
+	Err      error
+}
+
+func (e *fileError) Error() string {
+	return fmt.Sprintf("failed to normalize file %s: %v", e.Filename, e.Err)
+}
+
+func (e *fileError) Unwrap() error {
+	return e.Err
+}
+
+var (
+	// syntaxErrorLine matches the line number in the decoder's errors, which
+	// counts from the start of the document if it was decoded on its own.
+	syntaxErrorLine = regexp.MustCompile(`yaml: line (\d+):`)
+	// documentStartLine matches the line a document decoded on its own starts
+	// on.
+	documentStartLine = regexp.MustCompile(`document starting at line (\d+)`)
+)
+
+// writeGitHubWarning writes a warning as a GitHub Actions workflow command.
+func writeGitHubWarning(w io.Writer, warning normalizer.Warning) error {
+	return writeGitHubAnnotation(w, "warning", warning.File, warning.Line, warning.Message)
+}
+
+// writeGitHubError writes an error as a GitHub Actions workflow command, with
+// the file and line it refers to if they can be found.
+func writeGitHubError(w io.Writer, err error) error {
+	var warning normalizer.Warning
+	if errors.As(err, &warning) {
+		return writeGitHubAnnotation(w, "error", warning.File, warning.Line, warning.Message)
+	}
+
+	file := ""
+	var fileErr *fileError
+	if errors.As(err, &fileErr) {
+		file = fileErr.Filename
+	}
+
+	line := 0
+	msg := err.Error()
+	if m := syntaxErrorLine.FindStringSubmatch(msg); m != nil {
+		line, _ = strconv.Atoi(m[1])
+		if m := documentStartLine.FindStringSubmatch(msg); m != nil {
+			start, _ := strconv.Atoi(m[1])
+			line += start - 1
+		}
+	}
+
+	return writeGitHubAnnotation(w, "error", file, line, msg)
+}
+
+func writeGitHubAnnotation(w io.Writer, command, file string, line int, msg string) error {
+	var props []string
+	if file != "" {
+		props = append(props, "file="+escapeGitHubProperty(file))
+	}
+	if line > 0 {
+		props = append(props, "line="+strconv.Itoa(line))
+	}
+
+	var sb strings.Builder
+	sb.WriteString("::" + command)
+	if len(props) > 0 {
+		sb.WriteString(" " + strings.Join(props, ","))
+	}
+	sb.WriteString("::" + escapeGitHubData(msg) + "\n")
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+var (
+	gitHubDataEscaper     = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A")
+	gitHubPropertyEscaper = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C")
+)
+
+func escapeGitHubData(s string) string {
+	return gitHubDataEscaper.Replace(s)
+}
+
+func escapeGitHubProperty(s string) string {
+	return gitHubPropertyEscaper.Replace(s)
+}
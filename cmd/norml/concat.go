@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+
+	"github.com/kanwren/norml/pkg/normalizer"
+)
+
+// stdinName is the file name that stands for stdin with -concat.
+const stdinName = "-"
+
+// concatTo writes the normalized files to w as a single stream, in order, with
+// stdin read in place of stdinName. Unlike normalizeTo, files with only empty
+// documents, or none at all, are skipped, and there is a separator only
+// between the files that are written. Empty documents in files that also have
+// content are kept.
+func concatTo(ctx context.Context, logger *log.Logger, w io.Writer, stdin io.Reader, files []string, numWorkers int, opts normalizer.Options) error {
+	wrote := false
+	write := func(content, json []byte) error {
+		if onlyEmptyDocuments(content) {
+			return nil
+		}
+
+		// With ExplicitStart, each file already starts with a separator
+		if wrote && opts.Format == normalizer.FormatYAML && !opts.ExplicitStart {
+			if _, err := w.Write([]byte("---\n")); err != nil {
+				return fmt.Errorf("failed to write document delimiter: %w", err)
+			}
+		}
+		if _, err := w.Write(content); err != nil {
+			return fmt.Errorf("failed to write to stdout: %w", err)
+		}
+		if opts.JSONWriter != nil {
+			if _, err := opts.JSONWriter.Write(json); err != nil {
+				return fmt.Errorf("failed to write JSON output: %w", err)
+			}
+		}
+		wrote = true
+		return nil
+	}
+
+	// Normalize the files between each occurrence of stdin in parallel
+	for len(files) > 0 {
+		n := slices.Index(files, stdinName)
+		if n < 0 {
+			n = len(files)
+		}
+		if n > 0 {
+			err := normalizeFiles(ctx, logger, files[:n], min(numWorkers, n), opts, func(result fileResult) error {
+				return write(result.content, result.json)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if n == len(files) {
+			break
+		}
+
+		logger.Println("normalizing stdin")
+		var buf, jsonBuf bytes.Buffer
+		stdinOpts := opts
+		if opts.JSONWriter != nil {
+			stdinOpts.JSONWriter = &jsonBuf
+		}
+		if err := normalizer.Normalize(stdin, &buf, stdinOpts); err != nil {
+			return err
+		}
+		if err := write(buf.Bytes(), jsonBuf.Bytes()); err != nil {
+			return err
+		}
+		files = files[n+1:]
+	}
+	return nil
+}
+
+// onlyEmptyDocuments reports whether normalized YAML has nothing but blank
+// lines and document markers.
+func onlyEmptyDocuments(content []byte) bool {
+	for line := range bytes.Lines(content) {
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 && !bytes.Equal(line, []byte("---")) && !bytes.Equal(line, []byte("...")) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// configExcludedFlags are flags that control how options are loaded rather
+// than being options themselves, so they cannot be set in a config file.
+var configExcludedFlags = map[string]bool{
+	"config":      true,
+	"config-dump": true,
+	"version":     true,
+}
+
+// flagAliases maps the names of flags that are aliases for other flags to
+// the name of the flag they are an alias for.
+var flagAliases = map[string]string{
+	"comments":  "c",
+	"recursive": "r",
+}
+
+// canonicalFlagName returns the name of the flag that name is an alias for, or
+// name itself if it isn't an alias.
+func canonicalFlagName(name string) string {
+	if canonical, ok := flagAliases[name]; ok {
+		return canonical
+	}
+	return name
+}
+
+// loadConfig reads a YAML config file mapping flag names to values and
+// applies each value to flags that were not set on the command line, under
+// either their own name or an alias. List values may be given as YAML
+// sequences.
+func loadConfig(flags *flag.FlagSet, filename string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var doc yaml.Node
+	if err := yaml.NewDecoder(bytes.NewReader(data)).Decode(&doc); err != nil {
+		if err == io.EOF {
+			return nil
+		}
+		return fmt.Errorf("failed to decode config file %s: %w", filename, err)
+	}
+
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("config file %s must contain a mapping", filename)
+	}
+
+	setOnCommandLine := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		setOnCommandLine[canonicalFlagName(f.Name)] = true
+	})
+
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+
+		if flags.Lookup(key.Value) == nil || configExcludedFlags[key.Value] {
+			return fmt.Errorf("unknown option in config file %s: %s", filename, key.Value)
+		}
+		if setOnCommandLine[canonicalFlagName(key.Value)] {
+			continue
+		}
+
+		var values []*yaml.Node
+		switch value.Kind {
+		case yaml.ScalarNode:
+			if value.Tag == "!!null" {
+				continue
+			}
+			values = []*yaml.Node{value}
+		case yaml.SequenceNode:
+			values = value.Content
+		default:
+			return fmt.Errorf("invalid value for %s in config file %s", key.Value, filename)
+		}
+
+		for _, v := range values {
+			if err := flags.Set(key.Value, v.Value); err != nil {
+				return fmt.Errorf("invalid value for %s in config file %s: %w", key.Value, filename, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// dumpConfig writes the effective value of every option to w as YAML, in the
+// same format read by loadConfig. Aliases are written under the name of the
+// flag they are an alias for.
+func dumpConfig(w io.Writer, flags *flag.FlagSet) error {
+	config := make(map[string]any)
+	flags.VisitAll(func(f *flag.Flag) {
+		if configExcludedFlags[f.Name] || canonicalFlagName(f.Name) != f.Name {
+			return
+		}
+		if getter, ok := f.Value.(flag.Getter); ok {
+			config[f.Name] = getter.Get()
+		} else {
+			config[f.Name] = f.Value.String()
+		}
+	})
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(config); err != nil {
+		return fmt.Errorf("failed to encode config: %w", err)
+	}
+	return enc.Close()
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// filesFrom reads the list of files in the file named by name, or stdin if
+// name is "-". With nul set, names are separated by NUL characters.
+func filesFrom(name string, nul bool, stdin io.Reader) ([]string, error) {
+	read := readFileList
+	if nul {
+		read = readFileList0
+	}
+
+	if name == "-" {
+		return read(stdin)
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file list: %w", err)
+	}
+	defer f.Close()
+	return read(f)
+}
+
+// readFileList reads a list of file names, one per line, as written by find or
+// git ls-files. Surrounding whitespace is trimmed, and blank lines and lines
+// starting with "#" are ignored.
+func readFileList(r io.Reader) ([]string, error) {
+	var files []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		name := strings.TrimSpace(scanner.Text())
+		if name == "" || strings.HasPrefix(name, "#") {
+			continue
+		}
+		files = append(files, name)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read file list: %w", err)
+	}
+	return files, nil
+}
+
+// readFileList0 reads a list of file names separated by NUL characters, as
+// written by find -print0. Since names may contain any other character, they
+// are used as is, and only empty names are ignored.
+func readFileList0(r io.Reader) ([]string, error) {
+	var files []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		if i := bytes.IndexByte(data, 0); i >= 0 {
+			return i + 1, data[:i], nil
+		}
+		if atEOF && len(data) > 0 {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	})
+	for scanner.Scan() {
+		if name := scanner.Text(); name != "" {
+			files = append(files, name)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read file list: %w", err)
+	}
+	return files, nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// changedFiles returns the files that differ between ref and the working
+// tree, relative to the current directory. Only files matching one of the
+// given paths are included, or only YAML files if there are none. Deleted
+// files are not included.
+func changedFiles(ctx context.Context, ref string, paths []string) ([]string, error) {
+	if len(paths) == 0 {
+		paths = []string{"*.yaml", "*.yml"}
+	}
+
+	args := append([]string{"diff", "--name-only", "-z", "--relative", "--diff-filter=d", ref, "--"}, paths...)
+	cmd := exec.CommandContext(ctx, "git", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("failed to list files changed since %s: %w: %s", ref, err, msg)
+		}
+		return nil, fmt.Errorf("failed to list files changed since %s: %w", ref, err)
+	}
+
+	var files []string
+	for name := range strings.SplitSeq(string(out), "\x00") {
+		if name != "" {
+			files = append(files, name)
+		}
+	}
+	return files, nil
+}
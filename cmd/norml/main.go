@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"errors"
@@ -9,8 +10,15 @@ import (
 	"io"
 	"log"
 	"os"
+	"os/signal"
+	"regexp"
 	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
 
+	"go.yaml.in/yaml/v3"
 	"golang.org/x/sync/errgroup"
 
 	"github.com/kanwren/norml"
@@ -24,35 +32,449 @@ type normalizeCmd struct {
 	Verbose          bool
 	Version          bool
 	PreserveComments bool
+	VerifyEqual      bool
+	OnlyKinds        []string
+	AlignValues      bool
+	Preview          bool
+	EmbeddedPaths    []string
+	EmbeddedStrict   bool
+	TypeStats        bool
+	Config           string
+	ConfigDump       bool
+	Atomic           bool
+	TrimScalars      bool
+	JobsPerFile      int
+	StrictAnchors    bool
+	ForceBlockSeq    bool
+	KeyQuote         string
+	FixOnlyChanged   bool
+	MixedKeyOrder    string
+	DocSeparator     string
+	DocSeparatorRe   string
+	KeepUnchanged    bool
+	DotenvPaths      []string
+	MaxLineLength    int
+	Strict           bool
+	Format           string
+	StrictText       bool
+	LogFile          string
+	SortLast         []string
+	WarnVersions     bool
+	NoFinalNewline   bool
+	Output           string
+	ReportQuotes     bool
+	PreserveValues   []string
+	Kubectl          bool
+	Explain          bool
+	RequireLF        bool
+	AnchorsFirst     bool
+	JSONOut          string
+	ValidateChanged  string
+	Check            bool
+	Diff             bool
+	PreserveOrder    []string
+	IntKeyStyle      string
+	Indent           int
+	WarnCase         bool
+	ExpectSums       string
+	SinceStdin       bool
+	K8s              bool
+	SortDesc         bool
+	WarnChains       int
+	FlowSets         bool
+	ExpandMerge      bool
+	List             bool
+	StrictAliases    bool
+	EscapeUnicode    bool
+	StripDocTags     bool
+	ScopeKeys        []string
+	Annotations      string
+	SortOutput       bool
+	StrictDups       bool
+	FromJSON         bool
+	StrictTrailing   bool
+	Zip              string
+	Recursive        bool
+	UnsortedTags     []string
+	Extensions       []string
+	DumpTree         bool
+	OrderedMaps      []string
+	Backup           bool
+	BackupSuffix     string
+	CleanInvisible   bool
+	GroupByPrefix    bool
+	Stdout           bool
+	NoEmptyOutput    bool
+	HoistHeader      bool
+	FilesFrom        string
+	FilesFromNul     bool
+	VerifyMerge      bool
+	DropEmpty        bool
+	Serve            bool
+	ExplicitStart    bool
+	ExplicitEnd      bool
+	Concat           bool
+	Selector         []string
+	FlowWidth        int
+
+	// sums are the entries read from ExpectSums
+	sums []expectedSum
+}
+
+// kubernetesKeyOrder is the conventional order of the top-level keys of a
+// Kubernetes object.
+var kubernetesKeyOrder = []string{"apiVersion", "kind", "metadata", "spec", "status"}
+
+func (c *normalizeCmd) options() (normalizer.Options, error) {
+	var keyQuoteStyle yaml.Style
+	switch c.KeyQuote {
+	case "double":
+		keyQuoteStyle = yaml.DoubleQuotedStyle
+	case "single":
+		keyQuoteStyle = yaml.SingleQuotedStyle
+	}
+
+	var mixedKeyOrder normalizer.MixedKeyOrder
+	switch c.MixedKeyOrder {
+	case "numbers-first":
+		mixedKeyOrder = normalizer.MixedKeysNumbersFirst
+	case "strings-first":
+		mixedKeyOrder = normalizer.MixedKeysStringsFirst
+	}
+
+	if c.Indent < 2 || c.Indent > 9 {
+		return normalizer.Options{}, fmt.Errorf("invalid -indent %d: must be between 2 and 9", c.Indent)
+	}
+
+	var keyOrder []string
+	if c.K8s {
+		keyOrder = kubernetesKeyOrder
+	}
+
+	var intKeyStyle normalizer.IntKeyStyle
+	if c.IntKeyStyle == "quoted" {
+		intKeyStyle = normalizer.IntKeysQuoted
+	}
+
+	var backupSuffix string
+	if c.Backup {
+		if c.BackupSuffix == "" {
+			return normalizer.Options{}, errors.New("-backup-suffix must not be empty")
+		}
+		backupSuffix = c.BackupSuffix
+	}
+
+	var docSeparator *regexp.Regexp
+	if c.DocSeparator != "" && c.DocSeparatorRe != "" {
+		return normalizer.Options{}, errors.New("-doc-separator and -doc-separator-regex cannot be used together")
+	}
+	if c.DocSeparator != "" {
+		docSeparator = regexp.MustCompile("^" + regexp.QuoteMeta(c.DocSeparator) + "$")
+	}
+	if c.DocSeparatorRe != "" {
+		var err error
+		docSeparator, err = regexp.Compile("^(?:" + c.DocSeparatorRe + ")$")
+		if err != nil {
+			return normalizer.Options{}, fmt.Errorf("invalid -doc-separator-regex: %w", err)
+		}
+	}
+
+	var selector map[string]string
+	for _, label := range c.Selector {
+		key, value, ok := strings.Cut(label, "=")
+		if !ok || key == "" {
+			return normalizer.Options{}, fmt.Errorf("invalid -selector label %q: must be key=value", label)
+		}
+		if selector == nil {
+			selector = make(map[string]string)
+		}
+		selector[key] = value
+	}
+
+	var format normalizer.Format
+	if c.Format == "jsonl" {
+		format = normalizer.FormatJSONLines
+	}
+
+	return normalizer.Options{
+		PreserveComments:         c.PreserveComments,
+		VerifyEqual:              c.VerifyEqual,
+		OnlyKinds:                c.OnlyKinds,
+		AlignValues:              c.AlignValues,
+		EmbeddedPaths:            c.EmbeddedPaths,
+		EmbeddedStrict:           c.EmbeddedStrict,
+		Atomic:                   c.Atomic,
+		TrimScalars:              c.TrimScalars,
+		DocumentWorkers:          c.JobsPerFile,
+		StrictAnchors:            c.StrictAnchors,
+		ForceBlockSequences:      c.ForceBlockSeq || c.Kubectl,
+		KeyQuoteStyle:            keyQuoteStyle,
+		SkipUnchanged:            c.FixOnlyChanged,
+		MixedKeyOrder:            mixedKeyOrder,
+		DocumentSeparator:        docSeparator,
+		PreserveUnchanged:        c.KeepUnchanged,
+		DotenvPaths:              c.DotenvPaths,
+		MaxLineLength:            c.MaxLineLength,
+		Strict:                   c.Strict,
+		Format:                   format,
+		StrictText:               c.StrictText,
+		LastKeys:                 c.SortLast,
+		WarnVersionFloats:        c.WarnVersions,
+		ReportQuoteInconsistency: c.ReportQuotes,
+		PreserveValueKeys:        c.PreserveValues,
+		CompactSequenceIndent:    c.Kubectl,
+		RequireLF:                c.RequireLF,
+		AnchorsFirst:             c.AnchorsFirst,
+		PreserveOrderPaths:       c.PreserveOrder,
+		IntKeyStyle:              intKeyStyle,
+		KeyOrder:                 keyOrder,
+		SortDescending:           c.SortDesc,
+		WarnSingleKeyChains:      c.WarnChains,
+		FlowSets:                 c.FlowSets,
+		ExpandMerges:             c.ExpandMerge,
+		StrictAliases:            c.StrictAliases,
+		EscapeUnicode:            c.EscapeUnicode,
+		StripDocumentTags:        c.StripDocTags,
+		ScopeKeys:                c.ScopeKeys,
+		StrictDuplicates:         c.StrictDups,
+		FromJSON:                 c.FromJSON,
+		StrictTrailingContent:    c.StrictTrailing,
+		UnsortedTags:             c.UnsortedTags,
+		OrderedMapPaths:          c.OrderedMaps,
+		BackupSuffix:             backupSuffix,
+		CleanInvisible:           c.CleanInvisible,
+		GroupKeysByPrefix:        c.GroupByPrefix,
+		RejectEmptyOutput:        c.NoEmptyOutput,
+		HoistHeader:              c.HoistHeader,
+		VerifyMerges:             c.VerifyMerge,
+		DropEmptyDocuments:       c.DropEmpty,
+		ExplicitStart:            c.ExplicitStart,
+		ExplicitEnd:              c.ExplicitEnd,
+		LabelSelector:            selector,
+		FlowWidth:                c.FlowWidth,
+		Indent:                   c.Indent,
+		WarnCaseCollisions:       c.WarnCase,
+	}, nil
+}
+
+func (c *normalizeCmd) normalize(ctx context.Context, logger *log.Logger, stdin io.Reader, stdout, stderr io.Writer, opts normalizer.Options) error {
+	if c.SinceStdin {
+		return normalizeInPlace(ctx, logger, c.Files, c.Workers, opts, nil)
+	}
+	if c.Serve {
+		return serve(ctx, logger, stdin, stdout, opts)
+	}
+	if c.Zip != "" {
+		write := func(w io.Writer) error {
+			return normalizeZip(ctx, logger, c.Zip, w, opts)
+		}
+		// Writing over the archive being read would truncate it first
+		if c.Atomic || sameFile(c.Zip, c.Output) {
+			return normalizer.WriteFileAtomic(c.Output, write)
+		}
+		return writeOutputFile(c.Output, write)
+	}
+	if c.ExpectSums != "" {
+		return verifySums(ctx, logger, stderr, c.sums, c.Workers, opts)
+	}
+	if c.Check || c.ValidateChanged != "" {
+		return checkFiles(ctx, logger, stderr, c.Files, c.Workers, opts)
+	}
+	if c.List {
+		return listFiles(ctx, logger, stdout, c.Files, c.Workers, c.InPlace, opts)
+	}
+	if c.Diff {
+		return diffFiles(ctx, logger, stdout, c.Files, c.Workers, c.InPlace, opts)
+	}
+	if len(c.Files) > 0 && c.InPlace && c.Preview {
+		if c.NoFinalNewline {
+			stdout = &trimFinalNewlineWriter{w: stdout}
+		}
+		return previewInPlace(ctx, logger, stdout, c.Files, c.Workers, opts)
+	}
+	if len(c.Files) > 0 && c.InPlace {
+		return normalizeInPlace(ctx, logger, c.Files, c.Workers, opts, nil)
+	}
+
+	if c.JSONOut != "" {
+		write := func(w io.Writer) error {
+			opts.JSONWriter = w
+			return c.normalizeToOutput(ctx, logger, stdin, stdout, opts)
+		}
+		if c.Atomic {
+			return normalizer.WriteFileAtomic(c.JSONOut, write)
+		}
+		return writeOutputFile(c.JSONOut, write)
+	}
+
+	return c.normalizeToOutput(ctx, logger, stdin, stdout, opts)
+}
+
+// normalizeToOutput writes the normalized input to the output file, or to
+// stdout if there is none.
+func (c *normalizeCmd) normalizeToOutput(ctx context.Context, logger *log.Logger, stdin io.Reader, stdout io.Writer, opts normalizer.Options) error {
+	if c.Output != "" {
+		write := func(w io.Writer) error {
+			return c.normalizeToWriter(ctx, logger, stdin, w, opts)
+		}
+		if c.Atomic {
+			return normalizer.WriteFileAtomic(c.Output, write)
+		}
+		return writeOutputFile(c.Output, write)
+	}
+
+	return c.normalizeToWriter(ctx, logger, stdin, stdout, opts)
+}
+
+// normalizeToWriter writes the normalized input files, or stdin if there are
+// none, to w.
+func (c *normalizeCmd) normalizeToWriter(ctx context.Context, logger *log.Logger, stdin io.Reader, w io.Writer, opts normalizer.Options) error {
+	if c.NoFinalNewline {
+		w = &trimFinalNewlineWriter{w: w}
+	}
+
+	if len(c.Files) == 0 {
+		logger.Println("No files specified, reading from stdin")
+		return normalizer.Normalize(stdin, w, opts)
+	}
+	files := c.Files
+	if c.SortOutput {
+		// Results are written in the order of the files, so sorting them
+		// makes the output independent of the order they were given in
+		files = slices.Sorted(slices.Values(files))
+	}
+	write := func(w io.Writer) error {
+		if c.Concat {
+			return concatTo(ctx, logger, w, stdin, files, c.Workers, opts)
+		}
+		return normalizeTo(ctx, logger, w, files, c.Workers, opts)
+	}
+	if c.Atomic {
+		var buf bytes.Buffer
+		if err := write(&buf); err != nil {
+			return err
+		}
+		_, err := w.Write(buf.Bytes())
+		return err
+	}
+	return write(w)
+}
+
+// writeOutputFile creates or truncates filename and calls write with it.
+func writeOutputFile(filename string, write func(w io.Writer) error) (finalErr error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	defer func() {
+		if err := f.Close(); finalErr == nil && err != nil {
+			finalErr = fmt.Errorf("failed to close output file: %w", err)
+		}
+	}()
+
+	bw := bufio.NewWriter(f)
+	if err := write(bw); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// listFlag is a flag accepting a comma-separated list of values. It may be
+// repeated to append more values.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Get() any {
+	return []string(*l)
+}
+
+func (l *listFlag) Set(value string) error {
+	for item := range strings.SplitSeq(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+// choiceFlag is a string flag that only accepts one of a fixed set of values.
+type choiceFlag struct {
+	value   *string
+	choices []string
+}
+
+func (c choiceFlag) String() string {
+	if c.value == nil {
+		return ""
+	}
+	return *c.value
+}
+
+func (c choiceFlag) Get() any {
+	return *c.value
 }
 
-func normalizeInPlace(ctx context.Context, logger *log.Logger, files []string, numWorkers int, preserveComments bool) error {
+func (c choiceFlag) Set(value string) error {
+	if !slices.Contains(c.choices, value) {
+		return fmt.Errorf("must be one of: %s", strings.Join(c.choices, ", "))
+	}
+	*c.value = value
+	return nil
+}
+
+// normalizeInPlace normalizes files in-place, then logs how many were
+// changed, were already normalized, or failed. If wasChanged is non-nil,
+// wasChanged[i] is set if files[i] was changed.
+func normalizeInPlace(ctx context.Context, logger *log.Logger, files []string, numWorkers int, opts normalizer.Options, wasChanged []bool) error {
 	g, egCtx := errgroup.WithContext(ctx)
 
-	filesChan := make(chan string, len(files))
+	filesChan := make(chan fileInfo, len(files))
+
+	var mu sync.Mutex
+	var changed, unchanged, failed int
 
 	for range numWorkers {
 		g.Go(func() error {
-			for filename := range filesChan {
+			for info := range filesChan {
 				if egCtx.Err() != nil {
 					return egCtx.Err()
 				}
 
+				filename := info.filename
 				logger.Printf("normalizing file: %s", filename)
-				if err := normalizer.NormalizeFile(filename, preserveComments); err != nil {
-					return fmt.Errorf("failed to normalize file %s: %w", filename, err)
+				fileChanged, err := normalizer.NormalizeFileChanged(filename, opts)
+				mu.Lock()
+				switch {
+				case err != nil:
+					failed++
+				case fileChanged:
+					changed++
+				default:
+					unchanged++
+				}
+				if wasChanged != nil {
+					wasChanged[info.index] = err == nil && fileChanged
+				}
+				mu.Unlock()
+				if err != nil {
+					return &fileError{Filename: filename, Err: err}
 				}
 			}
 			return nil
 		})
 	}
 
-	for _, file := range files {
-		filesChan <- file
+	for i, file := range files {
+		filesChan <- fileInfo{filename: file, index: i}
 	}
 	close(filesChan)
 
-	return g.Wait()
+	err := g.Wait()
+	logger.Printf("%d files changed, %d unchanged, %d failed", changed, unchanged, failed)
+	return err
 }
 
 type fileInfo struct {
@@ -63,10 +485,162 @@ type fileInfo struct {
 type fileResult struct {
 	filename string
 	content  []byte
-	index    int
+	// json is the content as lines of JSON, if opts.JSONWriter is set
+	json  []byte
+	index int
+}
+
+func normalizeTo(ctx context.Context, logger *log.Logger, w io.Writer, files []string, numWorkers int, opts normalizer.Options) error {
+	return normalizeFiles(ctx, logger, files, numWorkers, opts, func(result fileResult) error {
+		// With ExplicitStart, each file already starts with a separator
+		if result.index > 0 && opts.Format == normalizer.FormatYAML && !opts.ExplicitStart {
+			if _, err := w.Write([]byte("---\n")); err != nil {
+				return fmt.Errorf("failed to write document delimiter: %w", err)
+			}
+		}
+
+		if _, err := w.Write(result.content); err != nil {
+			return fmt.Errorf("failed to write to stdout: %w", err)
+		}
+		if opts.JSONWriter != nil {
+			if _, err := opts.JSONWriter.Write(result.json); err != nil {
+				return fmt.Errorf("failed to write JSON output: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// checkFiles normalizes files without modifying them and writes the name of
+// each file that is not already normalized to w. It fails with exit code 1 if
+// there are any such files.
+func checkFiles(ctx context.Context, logger *log.Logger, w io.Writer, files []string, numWorkers int, opts normalizer.Options) error {
+	var unformatted int
+	err := normalizeFiles(ctx, logger, files, numWorkers, opts, func(result fileResult) error {
+		original, err := os.ReadFile(result.filename)
+		if err != nil {
+			return fmt.Errorf("failed to read file %s: %w", result.filename, err)
+		}
+		if bytes.Equal(original, result.content) {
+			return nil
+		}
+
+		unformatted++
+		if _, err := fmt.Fprintln(w, result.filename); err != nil {
+			return fmt.Errorf("failed to write file name: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if unformatted > 0 {
+		return &errWithExitCode{
+			Code: 1,
+			Err:  fmt.Errorf("%d of %d files are not normalized", unformatted, len(files)),
+		}
+	}
+	return nil
+}
+
+// diffFiles writes a unified diff between each file and its normalized form
+// to w. Files that are already normalized produce no output. If inPlace is
+// set, files that are not normalized are also rewritten.
+func diffFiles(ctx context.Context, logger *log.Logger, w io.Writer, files []string, numWorkers int, inPlace bool, opts normalizer.Options) error {
+	return normalizeFiles(ctx, logger, files, numWorkers, opts, func(result fileResult) error {
+		original, err := os.ReadFile(result.filename)
+		if err != nil {
+			return fmt.Errorf("failed to read file %s: %w", result.filename, err)
+		}
+
+		diff := normalizer.UnifiedDiff(result.filename, result.filename, original, result.content)
+		if diff == "" {
+			return nil
+		}
+		if _, err := io.WriteString(w, diff); err != nil {
+			return fmt.Errorf("failed to write diff: %w", err)
+		}
+
+		if inPlace {
+			return writeResult(result, opts.BackupSuffix)
+		}
+		return nil
+	})
 }
 
-func normalizeTo(ctx context.Context, logger *log.Logger, w io.Writer, files []string, numWorkers int, preserveComments bool) error {
+// listFiles writes the name of each file that is not already normalized to w.
+// If inPlace is set, those files are also rewritten as by normalizeInPlace;
+// files that are already normalized are never written.
+func listFiles(ctx context.Context, logger *log.Logger, w io.Writer, files []string, numWorkers int, inPlace bool, opts normalizer.Options) error {
+	if inPlace {
+		opts.SkipUnchanged = true
+		changed := make([]bool, len(files))
+		err := normalizeInPlace(ctx, logger, files, numWorkers, opts, changed)
+		// The files changed before any failure are listed too
+		for i, filename := range files {
+			if !changed[i] {
+				continue
+			}
+			if _, err := fmt.Fprintln(w, filename); err != nil {
+				return fmt.Errorf("failed to write file name: %w", err)
+			}
+		}
+		return err
+	}
+
+	return normalizeFiles(ctx, logger, files, numWorkers, opts, func(result fileResult) error {
+		original, err := os.ReadFile(result.filename)
+		if err != nil {
+			return fmt.Errorf("failed to read file %s: %w", result.filename, err)
+		}
+		if bytes.Equal(original, result.content) {
+			return nil
+		}
+
+		if _, err := fmt.Fprintln(w, result.filename); err != nil {
+			return fmt.Errorf("failed to write file name: %w", err)
+		}
+		return nil
+	})
+}
+
+// writeResult replaces a file with its normalized content, first copying the
+// original to its name with backupSuffix added, if set.
+func writeResult(result fileResult, backupSuffix string) error {
+	if backupSuffix != "" {
+		if err := normalizer.BackupFile(result.filename, backupSuffix); err != nil {
+			return fmt.Errorf("failed to write file %s: %w", result.filename, err)
+		}
+	}
+	err := normalizer.WriteFileAtomic(result.filename, func(w io.Writer) error {
+		_, err := w.Write(result.content)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write file %s: %w", result.filename, err)
+	}
+	return nil
+}
+
+// previewInPlace writes what normalizing each file in-place would produce to
+// w, with a header naming each file, without modifying any files.
+func previewInPlace(ctx context.Context, logger *log.Logger, w io.Writer, files []string, numWorkers int, opts normalizer.Options) error {
+	return normalizeFiles(ctx, logger, files, numWorkers, opts, func(result fileResult) error {
+		if _, err := fmt.Fprintf(w, "# === %s ===\n", result.filename); err != nil {
+			return fmt.Errorf("failed to write preview header: %w", err)
+		}
+
+		if _, err := w.Write(result.content); err != nil {
+			return fmt.Errorf("failed to write to stdout: %w", err)
+		}
+		return nil
+	})
+}
+
+// normalizeFiles normalizes files in parallel and calls emit with each
+// result, in the same order as files.
+func normalizeFiles(ctx context.Context, logger *log.Logger, files []string, numWorkers int, opts normalizer.Options, emit func(fileResult) error) error {
 	filesChan := make(chan fileInfo, len(files))
 	resultsChan := make(chan fileResult, len(files))
 
@@ -89,20 +663,31 @@ func normalizeTo(ctx context.Context, logger *log.Logger, w io.Writer, files []s
 				}
 
 				buf := new(bytes.Buffer)
-				err = normalizer.Normalize(file, buf, preserveComments)
+				fileOpts := opts.WithFile(filename)
+				var jsonBuf *bytes.Buffer
+				if opts.JSONWriter != nil {
+					// Buffer JSON per file so that it is written in order
+					jsonBuf = new(bytes.Buffer)
+					fileOpts.JSONWriter = jsonBuf
+				}
+				err = normalizer.Normalize(file, buf, fileOpts)
 				closeErr := file.Close()
 				if err != nil {
-					return fmt.Errorf("failed to normalize file %s: %w", filename, err)
+					return &fileError{Filename: filename, Err: err}
 				}
 				if closeErr != nil {
 					return fmt.Errorf("failed to close output file %s: %w", filename, closeErr)
 				}
 
-				resultsChan <- fileResult{
+				result := fileResult{
 					filename: filename,
 					index:    index,
 					content:  buf.Bytes(),
 				}
+				if jsonBuf != nil {
+					result.json = jsonBuf.Bytes()
+				}
+				resultsChan <- result
 			}
 			return nil
 		})
@@ -111,25 +696,19 @@ func normalizeTo(ctx context.Context, logger *log.Logger, w io.Writer, files []s
 	reader, readerCtx := errgroup.WithContext(ctx)
 	reader.Go(func() error {
 		nextIndex := 0
-		results := make(map[int][]byte)
+		results := make(map[int]fileResult)
 
 		for result := range resultsChan {
 			if readerCtx.Err() != nil {
 				return readerCtx.Err()
 			}
 
-			results[result.index] = result.content
+			results[result.index] = result
 
 			if result.index == nextIndex {
-				for doc, exists := results[nextIndex]; exists; doc, exists = results[nextIndex] {
-					if nextIndex > 0 {
-						if _, err := w.Write([]byte("---\n")); err != nil {
-							return fmt.Errorf("failed to write document delimiter: %w", err)
-						}
-					}
-
-					if _, err := w.Write(doc); err != nil {
-						return fmt.Errorf("failed to write to stdout: %w", err)
+				for next, exists := results[nextIndex]; exists; next, exists = results[nextIndex] {
+					if err := emit(next); err != nil {
+						return err
 					}
 
 					delete(results, nextIndex)
@@ -154,6 +733,50 @@ func normalizeTo(ctx context.Context, logger *log.Logger, w io.Writer, files []s
 	return reader.Wait()
 }
 
+// lockedWriter is a writer that holds mu while writing to w, so that it can
+// be shared between goroutines and with other writers that hold mu.
+type lockedWriter struct {
+	mu *sync.Mutex
+	w  io.Writer
+}
+
+func (l lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
+
+// trimFinalNewlineWriter writes everything written to it to w, except for a
+// newline at the very end. Each trailing newline is held back until more
+// output follows it.
+type trimFinalNewlineWriter struct {
+	w       io.Writer
+	pending bool
+}
+
+func (t *trimFinalNewlineWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	if t.pending {
+		if _, err := t.w.Write([]byte{'\n'}); err != nil {
+			return 0, err
+		}
+		t.pending = false
+	}
+
+	out := p
+	if out[len(out)-1] == '\n' {
+		out = out[:len(out)-1]
+		t.pending = true
+	}
+	if _, err := t.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
 type errWithExitCode struct {
 	Code int
 	Err  error
@@ -175,7 +798,7 @@ func run(
 	stderr io.Writer,
 	args []string,
 ) (err error) {
-	cmd := &normalizeCmd{}
+	cmd := &normalizeCmd{Format: "yaml", IntKeyStyle: "bare"}
 
 	flags := flag.NewFlagSet("norml", flag.ContinueOnError)
 	flags.SetOutput(stderr)
@@ -184,9 +807,95 @@ func run(
 
 	flags.BoolVar(&cmd.InPlace, "i", false, "Edit files in-place")
 	flags.IntVar(&cmd.Workers, "j", numCPU, "Number of parallel workers (default: number of CPUs)")
+	flags.IntVar(&cmd.JobsPerFile, "jobs-per-file", 1, "Number of documents within each file to normalize in parallel")
 	flags.BoolVar(&cmd.Verbose, "v", false, "Verbose output")
+	flags.StringVar(&cmd.LogFile, "log-file", "", "Also write the log of files processed to this file")
 	flags.BoolVar(&cmd.Version, "version", false, "Print version and exit")
-	flags.BoolVar(&cmd.PreserveComments, "c", false, "Preserve comments")
+	flags.BoolVar(&cmd.PreserveComments, "c", false, "Preserve comments (default false: comments are stripped)")
+	flags.BoolVar(&cmd.PreserveComments, "comments", false, "Alias for -c")
+	flags.BoolVar(&cmd.HoistHeader, "hoist-header", false, "Keep the comment block at the top of each file, if followed by a blank line or ---, above all documents, even without -c")
+	flags.BoolVar(&cmd.Recursive, "r", false, "Normalize the YAML files in directories given as arguments, recursively")
+	flags.BoolVar(&cmd.Recursive, "recursive", false, "Alias for -r")
+	flags.Var((*listFlag)(&cmd.Extensions), "ext", "Comma-separated list of extensions of the files to normalize in directories with -r or in the diff with -since-stdin (default yaml,yml)")
+	flags.BoolVar(&cmd.VerifyEqual, "verify-equal", false, "Verify that normalization does not change the decoded documents")
+	flags.BoolVar(&cmd.VerifyMerge, "verify-merge", false, "Verify that normalization does not change the merged value of mappings with merge keys (<<)")
+	flags.Var((*listFlag)(&cmd.OnlyKinds), "only-kinds", "Comma-separated list of kinds to normalize; other documents are copied unchanged")
+	flags.Var((*listFlag)(&cmd.Selector), "selector", "Comma-separated list of key=value labels; only documents whose metadata.labels match all of them are normalized, and others are copied unchanged")
+	flags.Var((*listFlag)(&cmd.ScopeKeys), "scope-keys", "Comma-separated list of keys; only documents containing one of them are normalized, and others are copied unchanged")
+	flags.BoolVar(&cmd.AlignValues, "align-values", false, "Align mapping values to the same column")
+	flags.BoolVar(&cmd.FixOnlyChanged, "fix-only-unformatted", false, "With -i, only rewrite files that are not already normalized")
+	flags.BoolVar(&cmd.Backup, "backup", false, "With -i, copy each file to a backup before rewriting it")
+	flags.StringVar(&cmd.BackupSuffix, "backup-suffix", ".bak", "Suffix added to file names to name their backups with -backup")
+	flags.BoolVar(&cmd.NoEmptyOutput, "no-empty-output", false, "Fail instead of writing output with no content, such as for a file with only comments")
+	flags.BoolVar(&cmd.Preview, "preview", false, "With -i, print what would be written to each file instead of writing it")
+	flags.Var((*listFlag)(&cmd.EmbeddedPaths), "normalize-embedded", "Comma-separated list of dotted paths (e.g. data.*) of string values containing YAML to normalize")
+	flags.Var((*listFlag)(&cmd.DotenvPaths), "normalize-dotenv", "Comma-separated list of dotted paths of string values containing dotenv lines to sort and deduplicate")
+	flags.BoolVar(&cmd.EmbeddedStrict, "embedded-strict", false, "Fail on values under -normalize-embedded paths that are not YAML")
+	flags.BoolVar(&cmd.DumpTree, "dump-tree", false, "Print the decoded node tree of each document to stderr, for debugging")
+	flags.BoolVar(&cmd.Explain, "explain", false, "After the output, list the changes made while normalizing a single file")
+	flags.BoolVar(&cmd.TypeStats, "type-stats", false, "Print a summary of the types of nodes in all documents to stderr")
+	flags.BoolVar(&cmd.StrictAliases, "strict-aliases", false, "Fail on aliases to anchors defined in earlier documents")
+	flags.BoolVar(&cmd.StrictAnchors, "strict-anchors", false, "Fail if an anchor name is defined more than once in a document")
+	flags.IntVar(&cmd.Indent, "indent", 2, "Number of spaces to indent nested collections by, from 2 to 9")
+	flags.BoolVar(&cmd.FlowSets, "flow-sets", false, "Write !!set mappings in flow style on a single line")
+	flags.IntVar(&cmd.FlowWidth, "flow-width", 0, "With -flow-sets, fold sets onto several lines of at most this many characters (0 to disable)")
+	flags.BoolVar(&cmd.ForceBlockSeq, "force-block-seq", false, "Always emit sequences with one item per line")
+	flags.BoolVar(&cmd.K8s, "k8s", false, "Place apiVersion, kind, metadata, spec, and status first in each document, in that order")
+	flags.BoolVar(&cmd.Kubectl, "kubectl", false, "Format sequences like kubectl: always block style, not indented under their key")
+	flags.Var(choiceFlag{&cmd.KeyQuote, []string{"double", "single"}}, "key-quote", "Quote style for keys that need quoting: double or single")
+	flags.Var(choiceFlag{&cmd.IntKeyStyle, []string{"bare", "quoted"}}, "int-key-style", "How to write integer keys: bare, or quoted as strings")
+	flags.BoolVar(&cmd.ExpandMerge, "expand-merge", false, "Replace merge keys (<<) with the keys they merge in, removing unused anchors")
+	flags.BoolVar(&cmd.AnchorsFirst, "anchors-first", false, "Place merge keys, then keys defining anchors, before other keys in mappings")
+	flags.BoolVar(&cmd.GroupByPrefix, "group-by-prefix", false, "Sort keys by the part before their first dot, then by the rest, keeping keys such as log.level and log.format together")
+	flags.BoolVar(&cmd.SortDesc, "sort-desc", false, "Sort mapping keys in descending order")
+	flags.Var((*listFlag)(&cmd.PreserveOrder), "preserve-order", "Comma-separated list of dotted paths of subtrees whose mapping keys keep their original order")
+	flags.Var((*listFlag)(&cmd.OrderedMaps), "ordered-maps", "Comma-separated list of dotted paths of mappings whose keys keep their original order, while mappings within them are still sorted")
+	flags.Var((*listFlag)(&cmd.UnsortedTags), "unsorted-tags", "Comma-separated list of tags, such as !ordered, of mappings whose keys keep their original order")
+	flags.Var((*listFlag)(&cmd.SortLast), "sort-last", "Comma-separated list of keys to always place last in mappings, in the order given")
+	flags.Var(choiceFlag{&cmd.MixedKeyOrder, []string{"numbers-first", "strings-first"}}, "mixed-key-order", "Order of numeric and string keys in the same map: numbers-first or strings-first")
+	flags.StringVar(&cmd.DocSeparator, "doc-separator", "", "Also split input into documents at lines equal to this separator")
+	flags.StringVar(&cmd.DocSeparatorRe, "doc-separator-regex", "", "Also split input into documents at lines matching this regular expression")
+	flags.BoolVar(&cmd.ExplicitStart, "explicit-start", false, "Start every document with ---, including the first")
+	flags.BoolVar(&cmd.ExplicitEnd, "explicit-end", false, "End every document with ...")
+	flags.BoolVar(&cmd.DropEmpty, "drop-empty", false, "Remove empty and null documents, along with their separators")
+	flags.BoolVar(&cmd.KeepUnchanged, "keep-unchanged", false, "Copy documents whose keys are already sorted through byte-for-byte")
+	flags.IntVar(&cmd.MaxLineLength, "max-line-length", 0, "Warn about output lines longer than this many characters (0 to disable)")
+	flags.BoolVar(&cmd.WarnVersions, "warn-version-floats", false, "Warn about unquoted version-like numbers such as 1.10 that are read as floats")
+	flags.BoolVar(&cmd.WarnCase, "warn-case-collisions", false, "Warn about keys in the same mapping that differ only by case")
+	flags.IntVar(&cmd.WarnChains, "warn-deep-single-chains", 0, "Warn about chains of more than this many nested single-key mappings (0 to disable)")
+	flags.BoolVar(&cmd.ReportQuotes, "report-quote-inconsistency", false, "Warn about documents that quote string values inconsistently")
+	flags.BoolVar(&cmd.Strict, "strict", false, "Treat warnings as errors")
+	flags.BoolVar(&cmd.StrictDups, "strict-duplicates", false, "Fail on mappings with the same key more than once; merge keys (<<) may be repeated")
+	flags.Var(choiceFlag{&cmd.Format, []string{"yaml", "jsonl"}}, "format", "Output format: yaml, or jsonl for one JSON document per line")
+	flags.BoolVar(&cmd.StrictTrailing, "strict-trailing-content", false, "Fail on documents with content after their value, or top-level plain scalars spanning several lines")
+	flags.BoolVar(&cmd.FromJSON, "from-json", false, "Read input as JSON and write it as normalized YAML")
+	flags.BoolVar(&cmd.StrictText, "strict-text", false, "Fail on input that is not valid UTF-8 or contains control characters")
+	flags.BoolVar(&cmd.NoFinalNewline, "no-final-newline", false, "Omit the newline at the very end of the output")
+	flags.Var((*listFlag)(&cmd.PreserveValues), "preserve-values", "Comma-separated list of keys whose values keep their original quoting and block style")
+	flags.BoolVar(&cmd.RequireLF, "require-lf", false, "Fail on input with carriage returns, such as Windows line endings")
+	flags.BoolVar(&cmd.EscapeUnicode, "escape-unicode", false, "Write emoji and other characters outside the Basic Multilingual Plane as escape sequences")
+	flags.BoolVar(&cmd.StripDocTags, "strip-document-tags", false, "Remove custom tags such as !Config from the top-level node of each document")
+	flags.Var(choiceFlag{&cmd.Annotations, []string{"github"}}, "format-annotations", "Also report warnings and errors as annotations for a CI system: github")
+	flags.BoolVar(&cmd.CleanInvisible, "clean-invisible", false, "Remove zero-width spaces and replace non-breaking spaces in keys and string values, warning about each")
+	flags.BoolVar(&cmd.TrimScalars, "trim-scalars", false, "Trim surrounding whitespace from string values")
+	flags.StringVar(&cmd.Output, "o", "", "Write output to this file instead of stdout")
+	flags.BoolVar(&cmd.Stdout, "stdout", false, "Always write the normalized files to stdout; cannot be used with -i, -o, -check, -diff, -list, -validate-only-changed, -expect-sums, -since-stdin, -zip, or -serve")
+	flags.BoolVar(&cmd.Concat, "concat", false, "Write the normalized files as a single stream, skipping files with no documents; - reads stdin in its place")
+	flags.BoolVar(&cmd.SortOutput, "sort-output", false, "Write the normalized files in order of file name rather than the order given")
+	flags.StringVar(&cmd.Zip, "zip", "", "Normalize the YAML files in this zip archive, writing a copy of the archive to the file given by -o")
+	flags.StringVar(&cmd.JSONOut, "json-out", "", "Also write each normalized document as a line of JSON to this file")
+	flags.BoolVar(&cmd.Atomic, "atomic", false, "Only write output if all documents are normalized successfully")
+	flags.StringVar(&cmd.FilesFrom, "files-from", "", "Also normalize the files listed in this file, one per line, or - to read the list from stdin")
+	flags.BoolVar(&cmd.FilesFromNul, "0", false, "With -files-from, read file names separated by NUL characters, as written by find -print0")
+	flags.BoolVar(&cmd.SinceStdin, "since-stdin", false, "Read a unified diff from stdin and normalize the YAML files it changes in-place, or those with an extension given by -ext")
+	flags.StringVar(&cmd.ExpectSums, "expect-sums", "", "Verify that the normalized content of each file listed in this sha256sum-style file matches its checksum")
+	flags.BoolVar(&cmd.List, "list", false, "List files that are not normalized to stdout; with -i, rewrite only those files")
+	flags.BoolVar(&cmd.Diff, "diff", false, "Print a unified diff of the changes normalizing each file would make; with -i, also apply them")
+	flags.BoolVar(&cmd.Check, "check", false, "List files that are not normalized to stderr and exit with status 1 if there are any, without modifying them")
+	flags.StringVar(&cmd.ValidateChanged, "validate-only-changed", "", "Check that YAML files changed since this git ref (or the given paths) are valid and normalized, without modifying them")
+	flags.BoolVar(&cmd.Serve, "serve", false, "Run as a server, reading JSON requests with a path or content from stdin and writing a JSON response for each to stdout")
+	flags.StringVar(&cmd.Config, "config", "", "Read options from a YAML file mapping option names to values; flags take precedence")
+	flags.BoolVar(&cmd.ConfigDump, "config-dump", false, "Print the effective options as YAML and exit")
 
 	if err := flags.Parse(args); err != nil {
 		if errors.Is(err, flag.ErrHelp) {
@@ -199,12 +908,195 @@ func run(
 	}
 	cmd.Files = flags.Args()
 
+	if cmd.Config != "" {
+		if err := loadConfig(flags, cmd.Config); err != nil {
+			return &errWithExitCode{
+				Code: 2,
+				Err:  err,
+			}
+		}
+	}
+
+	if cmd.ValidateChanged == "" {
+		// With -validate-only-changed, arguments are pathspecs for git
+		files, err := expandGlobs(cmd.Files)
+		if err != nil {
+			return err
+		}
+		cmd.Files = files
+	}
+
 	if cmd.Workers <= 0 {
 		cmd.Workers = runtime.NumCPU()
 	}
-	if !cmd.Verbose {
-		logger.SetOutput(io.Discard)
+	if cmd.ConfigDump {
+		return dumpConfig(stdout, flags)
+	}
+	var logOutputs []io.Writer
+	if cmd.Verbose {
+		logOutputs = append(logOutputs, logger.Writer())
+	}
+	if cmd.LogFile != "" {
+		var logFile *os.File
+		logFile, err = os.Create(cmd.LogFile)
+		if err != nil {
+			return fmt.Errorf("failed to create log file: %w", err)
+		}
+		defer func() {
+			if closeErr := logFile.Close(); err == nil && closeErr != nil {
+				err = fmt.Errorf("failed to close log file: %w", closeErr)
+			}
+		}()
+		logOutputs = append(logOutputs, logFile)
+	}
+	logger.SetOutput(io.MultiWriter(logOutputs...))
+	if cmd.Serve && (len(cmd.Files) > 0 || cmd.InPlace || cmd.Check || cmd.Diff || cmd.List || cmd.ValidateChanged != "" || cmd.ExpectSums != "" || cmd.SinceStdin || cmd.Zip != "" || cmd.FilesFrom != "" || cmd.Output != "" || cmd.JSONOut != "" || cmd.Explain) {
+		return &errWithExitCode{
+			Code: 2,
+			Err:  errors.New("-serve cannot be used with file arguments, -i, -check, -diff, -list, -validate-only-changed, -expect-sums, -since-stdin, -zip, -files-from, -o, -json-out, or -explain"),
+		}
+	}
+	if cmd.Concat && (cmd.InPlace || cmd.Check || cmd.Diff || cmd.List || cmd.ValidateChanged != "" || cmd.ExpectSums != "" || cmd.SinceStdin || cmd.Zip != "" || cmd.Serve) {
+		return &errWithExitCode{
+			Code: 2,
+			Err:  errors.New("-concat cannot be used with -i, -check, -diff, -list, -validate-only-changed, -expect-sums, -since-stdin, -zip, or -serve"),
+		}
+	}
+	if cmd.Concat && cmd.FilesFrom == "-" && slices.Contains(cmd.Files, stdinName) {
+		return &errWithExitCode{
+			Code: 2,
+			Err:  errors.New("-concat cannot read both the file list and a file from stdin"),
+		}
+	}
+	if cmd.FilesFrom != "" && (cmd.Zip != "" || cmd.SinceStdin || cmd.ExpectSums != "" || cmd.ValidateChanged != "") {
+		return &errWithExitCode{
+			Code: 2,
+			Err:  errors.New("-files-from cannot be used with -zip, -since-stdin, -expect-sums, or -validate-only-changed"),
+		}
 	}
+	if cmd.FilesFromNul && cmd.FilesFrom == "" {
+		return &errWithExitCode{
+			Code: 2,
+			Err:  errors.New("-0 can only be used with -files-from"),
+		}
+	}
+	if cmd.FilesFrom != "" {
+		files, err := filesFrom(cmd.FilesFrom, cmd.FilesFromNul, stdin)
+		if err != nil {
+			return err
+		}
+		logger.Printf("%d files listed in %s", len(files), cmd.FilesFrom)
+		cmd.Files = append(cmd.Files, files...)
+	}
+	if (cmd.Check || cmd.ValidateChanged != "") && (cmd.InPlace || cmd.Output != "" || cmd.JSONOut != "") {
+		return &errWithExitCode{
+			Code: 2,
+			Err:  errors.New("-check and -validate-only-changed cannot be used with -i, -o, or -json-out"),
+		}
+	}
+	if cmd.Stdout && (cmd.InPlace || cmd.Output != "" || cmd.Check || cmd.Diff || cmd.List || cmd.ValidateChanged != "" || cmd.ExpectSums != "" || cmd.SinceStdin || cmd.Zip != "" || cmd.Serve) {
+		return &errWithExitCode{
+			Code: 2,
+			Err:  errors.New("-stdout cannot be used with -i, -o, -check, -diff, -list, -validate-only-changed, -expect-sums, -since-stdin, -zip, or -serve"),
+		}
+	}
+	if cmd.Diff && (cmd.Check || cmd.ValidateChanged != "" || cmd.Preview || cmd.Output != "" || cmd.JSONOut != "") {
+		return &errWithExitCode{
+			Code: 2,
+			Err:  errors.New("-diff cannot be used with -check, -validate-only-changed, -preview, -o, or -json-out"),
+		}
+	}
+	if cmd.List && (cmd.Diff || cmd.Check || cmd.Preview || cmd.ValidateChanged != "" || cmd.ExpectSums != "" || cmd.SinceStdin || cmd.Output != "" || cmd.JSONOut != "") {
+		return &errWithExitCode{
+			Code: 2,
+			Err:  errors.New("-list cannot be used with -diff, -check, -preview, -validate-only-changed, -expect-sums, -since-stdin, -o, or -json-out"),
+		}
+	}
+	if cmd.List && len(cmd.Files) == 0 && cmd.FilesFrom == "" {
+		return &errWithExitCode{
+			Code: 2,
+			Err:  errors.New("-list requires at least one file"),
+		}
+	}
+	if cmd.Diff && len(cmd.Files) == 0 && cmd.FilesFrom == "" {
+		return &errWithExitCode{
+			Code: 2,
+			Err:  errors.New("-diff requires at least one file"),
+		}
+	}
+	if cmd.Check && cmd.ValidateChanged == "" && len(cmd.Files) == 0 && cmd.FilesFrom == "" {
+		return &errWithExitCode{
+			Code: 2,
+			Err:  errors.New("-check requires at least one file"),
+		}
+	}
+	if cmd.Zip != "" {
+		if len(cmd.Files) > 0 || cmd.InPlace || cmd.Check || cmd.Diff || cmd.List || cmd.ValidateChanged != "" || cmd.ExpectSums != "" || cmd.SinceStdin || cmd.JSONOut != "" {
+			return &errWithExitCode{
+				Code: 2,
+				Err:  errors.New("-zip cannot be used with file arguments, -i, -check, -diff, -list, -validate-only-changed, -expect-sums, -since-stdin, or -json-out"),
+			}
+		}
+		if cmd.Output == "" {
+			return &errWithExitCode{
+				Code: 2,
+				Err:  errors.New("-zip requires -o"),
+			}
+		}
+	}
+	if cmd.SinceStdin {
+		if len(cmd.Files) > 0 || cmd.Check || cmd.Diff || cmd.Preview || cmd.ValidateChanged != "" || cmd.ExpectSums != "" || cmd.Output != "" || cmd.JSONOut != "" {
+			return &errWithExitCode{
+				Code: 2,
+				Err:  errors.New("-since-stdin cannot be used with file arguments, -check, -diff, -preview, -validate-only-changed, -expect-sums, -o, or -json-out"),
+			}
+		}
+		files, err := patchFiles(stdin)
+		if err != nil {
+			return err
+		}
+		// Only normalize the YAML files in the diff, as with
+		// -validate-only-changed
+		exts := cmd.Extensions
+		if len(exts) == 0 {
+			exts = defaultExtensions
+		}
+		files = slices.DeleteFunc(files, func(name string) bool {
+			return !hasExtension(name, exts)
+		})
+		logger.Printf("%d files changed in diff", len(files))
+		cmd.Files = files
+		cmd.InPlace = true
+	}
+	if cmd.ExpectSums != "" {
+		if len(cmd.Files) > 0 || cmd.InPlace || cmd.Check || cmd.Diff || cmd.ValidateChanged != "" || cmd.Output != "" || cmd.JSONOut != "" {
+			return &errWithExitCode{
+				Code: 2,
+				Err:  errors.New("-expect-sums cannot be used with file arguments, -i, -check, -diff, -validate-only-changed, -o, or -json-out"),
+			}
+		}
+		sums, err := readSums(cmd.ExpectSums)
+		if err != nil {
+			return err
+		}
+		cmd.sums = sums
+		for _, entry := range sums {
+			cmd.Files = append(cmd.Files, entry.filename)
+		}
+	}
+	if cmd.ValidateChanged != "" {
+		files, err := changedFiles(ctx, cmd.ValidateChanged, cmd.Files)
+		if err != nil {
+			return err
+		}
+		logger.Printf("%d files changed since %s", len(files), cmd.ValidateChanged)
+		cmd.Files = files
+	}
+	files, err := expandDirs(cmd.Files, cmd.Recursive, cmd.Extensions)
+	if err != nil {
+		return err
+	}
+	cmd.Files = files
 	if len(cmd.Files) < cmd.Workers {
 		cmd.Workers = len(cmd.Files)
 	}
@@ -214,23 +1106,114 @@ func run(
 		return nil
 	}
 
-	if len(cmd.Files) == 0 {
-		logger.Println("No files specified, reading from stdin")
-		return normalizer.Normalize(stdin, stdout, cmd.PreserveComments)
+	if cmd.Backup && (!cmd.InPlace || cmd.Preview) {
+		return &errWithExitCode{
+			Code: 2,
+			Err:  errors.New("-backup can only be used with -i"),
+		}
 	}
-	if cmd.InPlace {
-		return normalizeInPlace(ctx, logger, cmd.Files, cmd.Workers, cmd.PreserveComments)
-	} else {
-		return normalizeTo(ctx, logger, stdout, cmd.Files, cmd.Workers, cmd.PreserveComments)
+	if cmd.Preview && !cmd.InPlace {
+		return &errWithExitCode{
+			Code: 2,
+			Err:  errors.New("-preview can only be used with -i"),
+		}
 	}
+	if (cmd.Output != "" || cmd.JSONOut != "") && cmd.InPlace {
+		return &errWithExitCode{
+			Code: 2,
+			Err:  errors.New("-o and -json-out cannot be used with -i"),
+		}
+	}
+	if cmd.Explain && (cmd.InPlace || cmd.Output != "" || len(cmd.Files) > 1) {
+		return &errWithExitCode{
+			Code: 2,
+			Err:  errors.New("-explain can only be used with a single input written to stdout"),
+		}
+	}
+	if cmd.Explain && (cmd.NoFinalNewline || cmd.Format == "jsonl") {
+		// The report is written as YAML comments after the output
+		return &errWithExitCode{
+			Code: 2,
+			Err:  errors.New("-explain cannot be used with -no-final-newline or -format jsonl"),
+		}
+	}
+	if cmd.NoFinalNewline && cmd.InPlace && !cmd.Preview {
+		return &errWithExitCode{
+			Code: 2,
+			Err:  errors.New("-no-final-newline cannot be used with -i"),
+		}
+	}
+	if cmd.FilesFrom != "" && len(cmd.Files) == 0 {
+		// Don't fall back to normalizing stdin
+		logger.Println("No files to normalize")
+		return nil
+	}
+
+	opts, err := cmd.options()
+	if err != nil {
+		return &errWithExitCode{
+			Code: 2,
+			Err:  err,
+		}
+	}
+	if cmd.TypeStats {
+		opts.TypeStats = new(normalizer.TypeStats)
+	}
+	if cmd.Explain {
+		opts.Explain = new(normalizer.Explanation)
+	}
+	var warnMu sync.Mutex
+	if cmd.DumpTree {
+		opts.TreeWriter = lockedWriter{mu: &warnMu, w: stderr}
+	}
+	opts.Warn = func(w normalizer.Warning) {
+		warnMu.Lock()
+		defer warnMu.Unlock()
+		if cmd.Annotations == "github" {
+			_ = writeGitHubWarning(stderr, w)
+			return
+		}
+		_, _ = fmt.Fprintf(stderr, "warning: %v\n", w)
+	}
+
+	if err := cmd.normalize(ctx, logger, stdin, stdout, stderr, opts); err != nil {
+		if cmd.Annotations == "github" && !errors.Is(err, context.Canceled) {
+			_ = writeGitHubError(stderr, err)
+		}
+		return err
+	}
+
+	if opts.Explain != nil {
+		if err := opts.Explain.WriteReport(stdout); err != nil {
+			return fmt.Errorf("failed to write explanation: %w", err)
+		}
+	}
+
+	if opts.TypeStats != nil {
+		if err := opts.TypeStats.Counts().WriteSummary(stderr); err != nil {
+			return fmt.Errorf("failed to write type statistics: %w", err)
+		}
+	}
+
+	return nil
 }
 
 func main() {
-	ctx := context.Background()
+	// On the first interrupt, stop handing out new files and let workers
+	// finish the file they're on; a second interrupt kills the process.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
 
 	logger := log.New(os.Stderr, "", log.LstdFlags)
 
 	if err := run(ctx, logger, os.Stdin, os.Stdout, os.Stderr, os.Args[1:]); err != nil {
+		if ctx.Err() != nil && errors.Is(err, context.Canceled) {
+			fmt.Fprintln(os.Stderr, "interrupted")
+			os.Exit(130)
+		}
 		fmt.Fprintf(os.Stderr, "error: %v\n", err)
 		if status, ok := err.(*errWithExitCode); ok {
 			os.Exit(status.Code)
@@ -1,19 +1,27 @@
 package main
 
 import (
+	"archive/zip"
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
 
+	"go.yaml.in/yaml/v3"
+
 	"github.com/kanwren/norml"
+	"github.com/kanwren/norml/pkg/normalizer"
 )
 
 // discardLogger returns a logger that discards all output
@@ -589,7 +597,7 @@ func TestNormalizeTo_EmptyResultsChannel(t *testing.T) {
 	logger := discardLogger()
 
 	var output bytes.Buffer
-	if err := normalizeTo(t.Context(), logger, &output, []string{filename}, 1, true); err != nil {
+	if err := normalizeTo(t.Context(), logger, &output, []string{filename}, 1, normalizer.Options{PreserveComments: true}); err != nil {
 		t.Errorf("expected no error, got: %v", err)
 	}
 
@@ -621,7 +629,7 @@ key2: value2
 
 	logger := discardLogger()
 
-	if err := normalizeInPlace(t.Context(), logger, []string{filename}, 1, true); err != nil {
+	if err := normalizeInPlace(t.Context(), logger, []string{filename}, 1, normalizer.Options{PreserveComments: true}, nil); err != nil {
 		t.Errorf("expected no error, got: %v", err)
 	}
 
@@ -666,7 +674,7 @@ key4: value4
 
 	logger := discardLogger()
 
-	if err := normalizeInPlace(t.Context(), logger, []string{file1, file2}, 2, true); err != nil {
+	if err := normalizeInPlace(t.Context(), logger, []string{file1, file2}, 2, normalizer.Options{PreserveComments: true}, nil); err != nil {
 		t.Errorf("expected no error, got: %v", err)
 	}
 
@@ -686,3 +694,2271 @@ key4: value4
 		t.Errorf("expected file 2 content %q, but got %q", expected2, string(content2))
 	}
 }
+
+func TestRun_VerifyEqual(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	filename := filepath.Join(tmpDir, "test.yaml")
+
+	input := `b: "2"
+a: 1
+`
+	expected := `a: 1
+b: "2"
+`
+
+	if err := os.WriteFile(filename, []byte(input), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+
+	stdin := strings.NewReader("")
+	var stdout bytes.Buffer
+
+	if err := run(t.Context(), discardLogger(), stdin, &stdout, io.Discard, []string{"-verify-equal", filename}); err != nil {
+		t.Errorf("expected no error, got: %v", err)
+	}
+
+	if result := stdout.String(); result != expected {
+		t.Errorf("expected output %q, but got %q", expected, result)
+	}
+}
+
+func TestRun_OnlyKinds(t *testing.T) {
+	t.Parallel()
+
+	input := `metadata: {name: web}
+kind: Deployment
+---
+metadata: {name: widgets}
+kind: CustomResourceDefinition
+`
+	expected := `kind: Deployment
+metadata:
+  name: web
+---
+metadata: {name: widgets}
+kind: CustomResourceDefinition
+`
+
+	stdin := strings.NewReader(input)
+	var stdout bytes.Buffer
+
+	if err := run(t.Context(), discardLogger(), stdin, &stdout, io.Discard, []string{"-only-kinds", "Deployment,Service"}); err != nil {
+		t.Errorf("expected no error, got: %v", err)
+	}
+
+	if result := stdout.String(); result != expected {
+		t.Errorf("expected output %q, but got %q", expected, result)
+	}
+}
+
+// cancelOnWrite cancels a context once it has been written to n times
+type cancelOnWrite struct {
+	n      int
+	cancel context.CancelFunc
+}
+
+func (w *cancelOnWrite) Write(p []byte) (int, error) {
+	w.n--
+	if w.n == 0 {
+		w.cancel()
+	}
+	return len(p), nil
+}
+
+func TestNormalizeInPlace_CancelMidBatch(t *testing.T) {
+	t.Parallel()
+
+	const fileCount = 5
+	input := "key2: value2\nkey1: value1\n"
+	expected := "key1: value1\nkey2: value2\n"
+
+	tmpDir := t.TempDir()
+	var files []string
+	for i := range fileCount {
+		filename := filepath.Join(tmpDir, fmt.Sprintf("test%d.yaml", i))
+		if err := os.WriteFile(filename, []byte(input), 0644); err != nil {
+			t.Fatalf("failed to write test file %d: %v", i, err)
+		}
+		files = append(files, filename)
+	}
+
+	// Cancel while the second file is being processed, as if interrupted
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	logger := log.New(&cancelOnWrite{n: 2, cancel: cancel}, "", 0)
+
+	err := normalizeInPlace(ctx, logger, files, 1, normalizer.Options{}, nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled error, got: %v", err)
+	}
+
+	for i, filename := range files {
+		content, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatalf("failed to read file %d: %v", i, err)
+		}
+
+		// The file in progress when cancelled is finished; the rest are
+		// left untouched
+		want := input
+		if i < 2 {
+			want = expected
+		}
+		if string(content) != want {
+			t.Errorf("file %d content = %q, want %q", i, string(content), want)
+		}
+	}
+}
+
+func TestRun_CancelDuringAtomicWrite(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	var files []string
+	for i := range 3 {
+		filename := filepath.Join(tmpDir, fmt.Sprintf("test%d.yaml", i))
+		if err := os.WriteFile(filename, []byte("b: 2\na: 1\n"), 0644); err != nil {
+			t.Fatalf("failed to write test file %d: %v", i, err)
+		}
+		files = append(files, filename)
+	}
+	outDir := t.TempDir()
+	output := filepath.Join(outDir, "out.yaml")
+	original := "old: content\n"
+	if err := os.WriteFile(output, []byte(original), 0644); err != nil {
+		t.Fatalf("failed to write output file: %v", err)
+	}
+
+	// Cancel once the first file has been written to the output, as if
+	// interrupted
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	logger := log.New(&cancelOnWrite{n: 2, cancel: cancel}, "", 0)
+
+	args := append([]string{"-v", "-j", "1", "-atomic", "-o", output}, files...)
+	err := run(ctx, logger, strings.NewReader(""), io.Discard, io.Discard, args)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled error, got: %v", err)
+	}
+
+	content, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatalf("failed to read output file: %v", err)
+	}
+	if string(content) != original {
+		t.Errorf("expected output file to be untouched, but got %q", string(content))
+	}
+	entries, err := os.ReadDir(outDir)
+	if err != nil {
+		t.Fatalf("failed to read output directory: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected no temporary files to be left behind, got %d entries", len(entries))
+	}
+}
+
+func TestRun_AlignValues(t *testing.T) {
+	t.Parallel()
+
+	stdin := strings.NewReader("replicas: 3\nname: web\n")
+	var stdout bytes.Buffer
+
+	if err := run(t.Context(), discardLogger(), stdin, &stdout, io.Discard, []string{"-align-values"}); err != nil {
+		t.Errorf("expected no error, got: %v", err)
+	}
+
+	expected := "name:     web\nreplicas: 3\n"
+	if result := stdout.String(); result != expected {
+		t.Errorf("expected output %q, but got %q", expected, result)
+	}
+}
+
+func TestRun_InPlacePreview(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	file1 := filepath.Join(tmpDir, "test1.yaml")
+	file2 := filepath.Join(tmpDir, "test2.yaml")
+
+	input1 := "key2: value2\nkey1: value1\n"
+	input2 := "key4: value4\nkey3: value3\n"
+
+	if err := os.WriteFile(file1, []byte(input1), 0644); err != nil {
+		t.Fatalf("failed to write test file 1: %v", err)
+	}
+	if err := os.WriteFile(file2, []byte(input2), 0644); err != nil {
+		t.Fatalf("failed to write test file 2: %v", err)
+	}
+
+	stdin := strings.NewReader("")
+	var stdout bytes.Buffer
+
+	if err := run(t.Context(), discardLogger(), stdin, &stdout, io.Discard, []string{"-i", "-preview", file1, file2}); err != nil {
+		t.Errorf("expected no error, got: %v", err)
+	}
+
+	expected := fmt.Sprintf(`# === %s ===
+key1: value1
+key2: value2
+# === %s ===
+key3: value3
+key4: value4
+`, file1, file2)
+	if result := stdout.String(); result != expected {
+		t.Errorf("expected output %q, but got %q", expected, result)
+	}
+
+	for filename, input := range map[string]string{file1: input1, file2: input2} {
+		content, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatalf("failed to read file %s: %v", filename, err)
+		}
+		if string(content) != input {
+			t.Errorf("expected %s to be unchanged, but got %q", filename, string(content))
+		}
+	}
+}
+
+func TestRun_PreviewRequiresInPlace(t *testing.T) {
+	t.Parallel()
+
+	stdin := strings.NewReader("")
+	var stdout bytes.Buffer
+
+	err := run(t.Context(), discardLogger(), stdin, &stdout, io.Discard, []string{"-preview", "test.yaml"})
+
+	var exitErr *errWithExitCode
+	if !errors.As(err, &exitErr) || exitErr.Code != 2 {
+		t.Errorf("expected errWithExitCode with code 2, got %T: %v", err, err)
+	}
+}
+
+func TestRun_TypeStats(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	file1 := filepath.Join(tmpDir, "test1.yaml")
+	file2 := filepath.Join(tmpDir, "test2.yaml")
+
+	if err := os.WriteFile(file1, []byte("a: 1\nb: [true, null]\n"), 0644); err != nil {
+		t.Fatalf("failed to write test file 1: %v", err)
+	}
+	if err := os.WriteFile(file2, []byte("c: 1.5\n"), 0644); err != nil {
+		t.Fatalf("failed to write test file 2: %v", err)
+	}
+
+	stdin := strings.NewReader("")
+	var stdout, stderr bytes.Buffer
+
+	if err := run(t.Context(), discardLogger(), stdin, &stdout, &stderr, []string{"-type-stats", file1, file2}); err != nil {
+		t.Errorf("expected no error, got: %v", err)
+	}
+
+	expected := `scalars:
+  str: 3
+  int: 1
+  float: 1
+  bool: 1
+  null: 1
+  other: 0
+mappings: 2
+sequences: 1
+`
+	if result := stderr.String(); result != expected {
+		t.Errorf("expected type statistics %q, but got %q", expected, result)
+	}
+}
+
+func TestRun_ConfigDump(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	configFile := filepath.Join(tmpDir, "norml.yaml")
+
+	config := `j: 2
+align-values: true
+only-kinds: [Deployment, Service]
+`
+	if err := os.WriteFile(configFile, []byte(config), 0644); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+
+	stdin := strings.NewReader("")
+	var stdout bytes.Buffer
+
+	args := []string{"-j", "3", "-config", configFile, "-only-kinds", "Pod", "-config-dump"}
+	if err := run(t.Context(), discardLogger(), stdin, &stdout, io.Discard, args); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	var dumped map[string]any
+	if err := yaml.Unmarshal(stdout.Bytes(), &dumped); err != nil {
+		t.Fatalf("config dump is not valid YAML: %v", err)
+	}
+
+	if dumped["j"] != 3 {
+		t.Errorf("expected flag to override config file value for j, got: %v", dumped["j"])
+	}
+	if dumped["align-values"] != true {
+		t.Errorf("expected config file value for align-values, got: %v", dumped["align-values"])
+	}
+	if !reflect.DeepEqual(dumped["only-kinds"], []any{"Pod"}) {
+		t.Errorf("expected flag to replace config file list for only-kinds, got: %v", dumped["only-kinds"])
+	}
+	if dumped["preview"] != false {
+		t.Errorf("expected default value for preview, got: %v", dumped["preview"])
+	}
+}
+
+func TestRun_ConfigAliases(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	configFile := filepath.Join(tmpDir, "norml.yaml")
+
+	config := `c: true
+recursive: true
+`
+	if err := os.WriteFile(configFile, []byte(config), 0644); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+
+	stdin := strings.NewReader("")
+	var stdout bytes.Buffer
+
+	args := []string{"-comments=false", "-r=false", "-config", configFile, "-config-dump"}
+	if err := run(t.Context(), discardLogger(), stdin, &stdout, io.Discard, args); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	var dumped map[string]any
+	if err := yaml.Unmarshal(stdout.Bytes(), &dumped); err != nil {
+		t.Fatalf("config dump is not valid YAML: %v", err)
+	}
+
+	if dumped["c"] != false {
+		t.Errorf("expected -comments to override config file value for c, got: %v", dumped["c"])
+	}
+	if dumped["r"] != false {
+		t.Errorf("expected -r to override config file value for recursive, got: %v", dumped["r"])
+	}
+	for _, alias := range []string{"comments", "recursive"} {
+		if _, ok := dumped[alias]; ok {
+			t.Errorf("expected alias %s not to be dumped", alias)
+		}
+	}
+
+	stdin = strings.NewReader("a: 1 # comment\n")
+	stdout.Reset()
+	args = []string{"-comments=false", "-config", configFile}
+	if err := run(t.Context(), discardLogger(), stdin, &stdout, io.Discard, args); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if expected := "a: 1\n"; stdout.String() != expected {
+		t.Errorf("expected output %q, but got %q", expected, stdout.String())
+	}
+}
+
+func TestRun_ConfigUnknownOption(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	configFile := filepath.Join(tmpDir, "norml.yaml")
+
+	if err := os.WriteFile(configFile, []byte("no-such-option: true\n"), 0644); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+
+	stdin := strings.NewReader("")
+	var stdout bytes.Buffer
+
+	err := run(t.Context(), discardLogger(), stdin, &stdout, io.Discard, []string{"-config", configFile})
+
+	var exitErr *errWithExitCode
+	if !errors.As(err, &exitErr) || exitErr.Code != 2 {
+		t.Errorf("expected errWithExitCode with code 2, got %T: %v", err, err)
+	}
+}
+
+func TestRun_AtomicMultipleFiles(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	file1 := filepath.Join(tmpDir, "test1.yaml")
+	file2 := filepath.Join(tmpDir, "test2.yaml")
+
+	if err := os.WriteFile(file1, []byte("key: value\n"), 0644); err != nil {
+		t.Fatalf("failed to write test file 1: %v", err)
+	}
+	if err := os.WriteFile(file2, []byte("key: value\n  invalid: indentation\n"), 0644); err != nil {
+		t.Fatalf("failed to write test file 2: %v", err)
+	}
+
+	stdin := strings.NewReader("")
+	var stdout bytes.Buffer
+
+	if err := run(t.Context(), discardLogger(), stdin, &stdout, io.Discard, []string{"-atomic", "-j", "1", file1, file2}); err == nil {
+		t.Error("expected error for invalid YAML, but got none")
+	}
+
+	if stdout.Len() != 0 {
+		t.Errorf("expected no output, but got %q", stdout.String())
+	}
+}
+
+func TestRun_KeyQuote(t *testing.T) {
+	t.Parallel()
+
+	stdin := strings.NewReader("'weird: key': 1\n")
+	var stdout bytes.Buffer
+
+	if err := run(t.Context(), discardLogger(), stdin, &stdout, io.Discard, []string{"-key-quote", "double"}); err != nil {
+		t.Errorf("expected no error, got: %v", err)
+	}
+
+	expected := "\"weird: key\": 1\n"
+	if result := stdout.String(); result != expected {
+		t.Errorf("expected output %q, but got %q", expected, result)
+	}
+
+	err := run(t.Context(), discardLogger(), strings.NewReader(""), io.Discard, io.Discard, []string{"-key-quote", "backtick"})
+	var exitErr *errWithExitCode
+	if !errors.As(err, &exitErr) || exitErr.Code != 2 {
+		t.Errorf("expected errWithExitCode with code 2 for invalid choice, got %T: %v", err, err)
+	}
+}
+
+func TestRun_FixOnlyUnformatted(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	clean := filepath.Join(tmpDir, "clean.yaml")
+	messy := filepath.Join(tmpDir, "messy.yaml")
+
+	if err := os.WriteFile(clean, []byte("a: 1\nb: 2\n"), 0644); err != nil {
+		t.Fatalf("failed to write clean file: %v", err)
+	}
+	if err := os.WriteFile(messy, []byte("b: 2\na: 1\n"), 0644); err != nil {
+		t.Fatalf("failed to write messy file: %v", err)
+	}
+
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+	for _, filename := range []string{clean, messy} {
+		if err := os.Chtimes(filename, past, past); err != nil {
+			t.Fatalf("failed to set file times: %v", err)
+		}
+	}
+
+	stdin := strings.NewReader("")
+	var stdout bytes.Buffer
+
+	if err := run(t.Context(), discardLogger(), stdin, &stdout, io.Discard, []string{"-i", "-fix-only-unformatted", clean, messy}); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	cleanInfo, err := os.Stat(clean)
+	if err != nil {
+		t.Fatalf("failed to stat clean file: %v", err)
+	}
+	if !cleanInfo.ModTime().Equal(past) {
+		t.Errorf("expected clean file to not be written, but its mtime changed to %v", cleanInfo.ModTime())
+	}
+
+	messyInfo, err := os.Stat(messy)
+	if err != nil {
+		t.Fatalf("failed to stat messy file: %v", err)
+	}
+	if messyInfo.ModTime().Equal(past) {
+		t.Error("expected messy file to be written, but its mtime is unchanged")
+	}
+
+	content, err := os.ReadFile(messy)
+	if err != nil {
+		t.Fatalf("failed to read messy file: %v", err)
+	}
+	if string(content) != "a: 1\nb: 2\n" {
+		t.Errorf("expected messy file to be normalized, got %q", string(content))
+	}
+}
+
+func TestRun_DocSeparator(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name string
+		args []string
+	}{
+		{
+			name: "literal",
+			args: []string{"-doc-separator", "==="},
+		},
+		{
+			name: "regex",
+			args: []string{"-doc-separator-regex", "=+"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			stdin := strings.NewReader("b: 2\na: 1\n===\nc: 3\n")
+			var stdout bytes.Buffer
+
+			if err := run(t.Context(), discardLogger(), stdin, &stdout, io.Discard, tc.args); err != nil {
+				t.Errorf("expected no error, got: %v", err)
+			}
+
+			expected := "a: 1\nb: 2\n---\nc: 3\n"
+			if result := stdout.String(); result != expected {
+				t.Errorf("expected output %q, but got %q", expected, result)
+			}
+		})
+	}
+}
+
+func TestRun_KeepUnchanged(t *testing.T) {
+	t.Parallel()
+
+	stdin := strings.NewReader("b: 1\na: 2\n---\na: [1, 2]\nb: 'x'\n")
+	var stdout bytes.Buffer
+
+	if err := run(t.Context(), discardLogger(), stdin, &stdout, io.Discard, []string{"-keep-unchanged"}); err != nil {
+		t.Errorf("expected no error, got: %v", err)
+	}
+
+	expected := "a: 2\nb: 1\n---\na: [1, 2]\nb: 'x'\n"
+	if result := stdout.String(); result != expected {
+		t.Errorf("expected output %q, but got %q", expected, result)
+	}
+}
+
+func TestRun_MaxLineLength(t *testing.T) {
+	t.Parallel()
+
+	input := "key: " + strings.Repeat("x", 100) + "\n"
+
+	var stdout, stderr bytes.Buffer
+	if err := run(t.Context(), discardLogger(), strings.NewReader(input), &stdout, &stderr, []string{"-max-line-length", "80"}); err != nil {
+		t.Errorf("expected no error, got: %v", err)
+	}
+	if result := stdout.String(); result != input {
+		t.Errorf("expected output %q, but got %q", input, result)
+	}
+	expectedWarning := "warning: line 1: line is 105 characters long, exceeding the maximum of 80\n"
+	if result := stderr.String(); result != expectedWarning {
+		t.Errorf("expected warning %q, but got %q", expectedWarning, result)
+	}
+
+	stdout.Reset()
+	err := run(t.Context(), discardLogger(), strings.NewReader(input), &stdout, io.Discard, []string{"-max-line-length", "80", "-strict"})
+	if err == nil || !strings.Contains(err.Error(), "105 characters long") {
+		t.Errorf("expected line length error, got: %v", err)
+	}
+	if stdout.Len() != 0 {
+		t.Errorf("expected no output, got %q", stdout.String())
+	}
+}
+
+func TestRun_FormatJSONLines(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	file1 := filepath.Join(tmpDir, "a.yaml")
+	file2 := filepath.Join(tmpDir, "b.yaml")
+	if err := os.WriteFile(file1, []byte("b: 1\na: 2\n---\nc: [x]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file2, []byte("d: null\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout bytes.Buffer
+	if err := run(t.Context(), discardLogger(), strings.NewReader(""), &stdout, io.Discard, []string{"-format", "jsonl", file1, file2}); err != nil {
+		t.Errorf("expected no error, got: %v", err)
+	}
+
+	expected := "{\"a\":2,\"b\":1}\n{\"c\":[\"x\"]}\n{\"d\":null}\n"
+	if result := stdout.String(); result != expected {
+		t.Errorf("expected output %q, but got %q", expected, result)
+	}
+}
+
+func TestRun_StrictText(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "binary.yaml")
+	if err := os.WriteFile(testFile, []byte("a: 1\nb: \x00\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err := run(t.Context(), discardLogger(), strings.NewReader(""), io.Discard, io.Discard, []string{"-strict-text", testFile})
+	if err == nil || !strings.Contains(err.Error(), testFile) || !strings.Contains(err.Error(), "control character U+0000 at byte offset 8") {
+		t.Errorf("expected control character error naming the file, got: %v", err)
+	}
+}
+
+func TestRun_LogFile(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	file1 := filepath.Join(tmpDir, "a.yaml")
+	file2 := filepath.Join(tmpDir, "b.yaml")
+	for _, file := range []string{file1, file2} {
+		if err := os.WriteFile(file, []byte("b: 1\na: 2\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	logFile := filepath.Join(tmpDir, "norml.log")
+
+	var stderr bytes.Buffer
+	logger := log.New(&stderr, "", 0)
+	if err := run(t.Context(), logger, strings.NewReader(""), io.Discard, io.Discard, []string{"-i", "-log-file", logFile, file1, file2}); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	if stderr.Len() != 0 {
+		t.Errorf("expected nothing logged to stderr without -v, got %q", stderr.String())
+	}
+
+	data, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatalf("failed to read log file: %v", err)
+	}
+	for _, file := range []string{file1, file2} {
+		if expected := "normalizing file: " + file + "\n"; !strings.Contains(string(data), expected) {
+			t.Errorf("expected log file to contain %q, got %q", expected, data)
+		}
+	}
+}
+
+func TestRun_NoFinalNewline(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	file1 := filepath.Join(tmpDir, "a.yaml")
+	file2 := filepath.Join(tmpDir, "b.yaml")
+	if err := os.WriteFile(file1, []byte("b: 1\na: 2\n---\nc: |\n  text\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file2, []byte("d: 4\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		args     []string
+		expected string
+	}{
+		{
+			name:     "stdin",
+			args:     []string{"-no-final-newline"},
+			expected: "a: 2\nb: 1",
+		},
+		{
+			name:     "multiple files",
+			args:     []string{"-no-final-newline", file1, file2},
+			expected: "a: 2\nb: 1\n---\nc: |\n  text\n---\nd: 4",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var stdout bytes.Buffer
+			if err := run(t.Context(), discardLogger(), strings.NewReader("b: 1\na: 2\n"), &stdout, io.Discard, tt.args); err != nil {
+				t.Errorf("expected no error, got: %v", err)
+			}
+
+			if result := stdout.String(); result != tt.expected {
+				t.Errorf("expected output %q, but got %q", tt.expected, result)
+			}
+		})
+	}
+
+	err := run(t.Context(), discardLogger(), strings.NewReader(""), io.Discard, io.Discard, []string{"-no-final-newline", "-i", file1})
+	var exitErr *errWithExitCode
+	if !errors.As(err, &exitErr) || exitErr.Code != 2 {
+		t.Errorf("expected errWithExitCode with code 2 with -i, got %T: %v", err, err)
+	}
+}
+
+func TestRun_OutputFile(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	target := filepath.Join(tmpDir, "out.yaml")
+	original := "existing: content\n"
+	if err := os.WriteFile(target, []byte(original), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// The second document fails to parse after the first has been normalized
+	stdin := strings.NewReader("b: 1\na: 2\n---\nc: [unclosed\n")
+	err := run(t.Context(), discardLogger(), stdin, io.Discard, io.Discard, []string{"-o", target, "-atomic"})
+	if err == nil {
+		t.Fatal("expected error for malformed input")
+	}
+
+	data, err := os.ReadFile(target)
+	if err != nil {
+		t.Fatalf("failed to read target: %v", err)
+	}
+	if string(data) != original {
+		t.Errorf("expected target to be untouched, but got %q", data)
+	}
+	entries, err := os.ReadDir(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected temporary file to be removed, found %d files", len(entries))
+	}
+
+	stdin = strings.NewReader("b: 1\na: 2\n")
+	if err := run(t.Context(), discardLogger(), stdin, io.Discard, io.Discard, []string{"-o", target, "-atomic"}); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	data, err = os.ReadFile(target)
+	if err != nil {
+		t.Fatalf("failed to read target: %v", err)
+	}
+	if expected := "a: 2\nb: 1\n"; string(data) != expected {
+		t.Errorf("expected output %q, but got %q", expected, data)
+	}
+	info, err := os.Stat(target)
+	if err != nil {
+		t.Fatalf("failed to stat target: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected target to keep mode 0600, got %v", info.Mode().Perm())
+	}
+}
+
+func TestRun_Kubectl(t *testing.T) {
+	t.Parallel()
+
+	stdin := strings.NewReader("command: [sh]\nargs:\n  - -c\n")
+	var stdout bytes.Buffer
+
+	if err := run(t.Context(), discardLogger(), stdin, &stdout, io.Discard, []string{"-kubectl"}); err != nil {
+		t.Errorf("expected no error, got: %v", err)
+	}
+
+	expected := "args:\n- -c\ncommand:\n- sh\n"
+	if result := stdout.String(); result != expected {
+		t.Errorf("expected output %q, but got %q", expected, result)
+	}
+}
+
+func TestRun_Explain(t *testing.T) {
+	t.Parallel()
+
+	stdin := strings.NewReader("b: 'x'\na: 1\n")
+	var stdout bytes.Buffer
+
+	if err := run(t.Context(), discardLogger(), stdin, &stdout, io.Discard, []string{"-explain"}); err != nil {
+		t.Errorf("expected no error, got: %v", err)
+	}
+
+	expected := "a: 1\nb: x\n# 2 changes:\n#   line 1, b: reset single-quoted style\n#   line 1, .: sorted keys\n"
+	if result := stdout.String(); result != expected {
+		t.Errorf("expected output %q, but got %q", expected, result)
+	}
+
+	err := run(t.Context(), discardLogger(), strings.NewReader(""), io.Discard, io.Discard, []string{"-explain", "a.yaml", "b.yaml"})
+	var exitErr *errWithExitCode
+	if !errors.As(err, &exitErr) || exitErr.Code != 2 {
+		t.Errorf("expected errWithExitCode with code 2 for multiple files, got %T: %v", err, err)
+	}
+
+	for _, args := range [][]string{
+		{"-explain", "-no-final-newline"},
+		{"-explain", "-format", "jsonl"},
+	} {
+		var stdout bytes.Buffer
+		err := run(t.Context(), discardLogger(), strings.NewReader("b: '1'\n"), &stdout, io.Discard, args)
+		if !errors.As(err, &exitErr) || exitErr.Code != 2 {
+			t.Errorf("expected errWithExitCode with code 2 for %q, got %T: %v", args, err, err)
+		}
+		if stdout.Len() != 0 {
+			t.Errorf("expected no output for %q, but got %q", args, stdout.String())
+		}
+	}
+}
+
+func TestRun_JSONOut(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	file1 := filepath.Join(tmpDir, "a.yaml")
+	file2 := filepath.Join(tmpDir, "b.yaml")
+	if err := os.WriteFile(file1, []byte("name: web\nports: [80, 443]\n---\nkind: Service\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file2, []byte("replicas: 2\nenabled: true\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	yamlOut := filepath.Join(tmpDir, "out.yaml")
+	jsonOut := filepath.Join(tmpDir, "out.json")
+
+	args := []string{"-j", "2", "-o", yamlOut, "-json-out", jsonOut, file1, file2}
+	if err := run(t.Context(), discardLogger(), strings.NewReader(""), io.Discard, io.Discard, args); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	yamlData, err := os.ReadFile(yamlOut)
+	if err != nil {
+		t.Fatalf("failed to read YAML output: %v", err)
+	}
+	jsonData, err := os.ReadFile(jsonOut)
+	if err != nil {
+		t.Fatalf("failed to read JSON output: %v", err)
+	}
+
+	var yamlDocs []any
+	dec := yaml.NewDecoder(bytes.NewReader(yamlData))
+	for {
+		var doc any
+		if err := dec.Decode(&doc); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("failed to decode YAML output: %v", err)
+		}
+		yamlDocs = append(yamlDocs, doc)
+	}
+
+	var jsonDocs []any
+	for line := range strings.Lines(string(jsonData)) {
+		var doc any
+		if err := yaml.Unmarshal([]byte(line), &doc); err != nil {
+			t.Fatalf("failed to decode JSON line %q: %v", line, err)
+		}
+		jsonDocs = append(jsonDocs, doc)
+	}
+
+	if len(yamlDocs) != 3 {
+		t.Errorf("expected 3 YAML documents, got %d", len(yamlDocs))
+	}
+	if !reflect.DeepEqual(yamlDocs, jsonDocs) {
+		t.Errorf("YAML documents %v differ from JSON documents %v", yamlDocs, jsonDocs)
+	}
+}
+
+func TestRun_ValidateOnlyChanged(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not available")
+	}
+
+	tmpDir := t.TempDir()
+	t.Chdir(tmpDir)
+
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %s failed: %v\n%s", strings.Join(args, " "), err, out)
+		}
+	}
+
+	// Both files start out unnormalized, but only one is changed
+	for _, filename := range []string{"changed.yaml", "unchanged.yaml"} {
+		if err := os.WriteFile(filename, []byte("b: 2\na: 1\n"), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", filename, err)
+		}
+	}
+	git("init", "-q")
+	git("add", ".")
+	git("commit", "-q", "-m", "initial")
+
+	if err := os.WriteFile("changed.yaml", []byte("c: 3\nb: 2\na: 1\n"), 0644); err != nil {
+		t.Fatalf("failed to modify file: %v", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	err := run(t.Context(), discardLogger(), strings.NewReader(""), &stdout, &stderr, []string{"-validate-only-changed", "HEAD"})
+	var exitErr *errWithExitCode
+	if !errors.As(err, &exitErr) || exitErr.Code != 1 {
+		t.Fatalf("expected an error with exit code 1, got: %v", err)
+	}
+	if got := stderr.String(); got != "changed.yaml\n" {
+		t.Errorf("expected only the changed file to be reported, but got %q", got)
+	}
+
+	content, err := os.ReadFile("changed.yaml")
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+	if string(content) != "c: 3\nb: 2\na: 1\n" {
+		t.Errorf("expected file to be unmodified, but got %q", string(content))
+	}
+
+	// Once the changed file is normalized, the check passes
+	if err := os.WriteFile("changed.yaml", []byte("a: 1\nb: 2\nc: 3\n"), 0644); err != nil {
+		t.Fatalf("failed to modify file: %v", err)
+	}
+	stderr.Reset()
+	if err := run(t.Context(), discardLogger(), strings.NewReader(""), &stdout, &stderr, []string{"-validate-only-changed", "HEAD"}); err != nil {
+		t.Errorf("expected no error, got: %v", err)
+	}
+	if stderr.Len() != 0 {
+		t.Errorf("expected no files to be reported, but got %q", stderr.String())
+	}
+}
+
+func TestRun_Check(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	files := map[string]string{
+		"a.yaml": "b: 2\na: 1\n",
+		"b.yaml": "a: 1\nb: 2\n",
+		"c.yaml": "z: {y: 1, x: 2}\n",
+		"d.yaml": "x: 1\n",
+	}
+	var args []string
+	for _, name := range []string{"a.yaml", "b.yaml", "c.yaml", "d.yaml"} {
+		filename := filepath.Join(tmpDir, name)
+		if err := os.WriteFile(filename, []byte(files[name]), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+		args = append(args, filename)
+	}
+
+	var stdout, stderr bytes.Buffer
+	err := run(t.Context(), discardLogger(), strings.NewReader(""), &stdout, &stderr, append([]string{"-check", "-j", "2"}, args...))
+	var exitErr *errWithExitCode
+	if !errors.As(err, &exitErr) || exitErr.Code != 1 {
+		t.Fatalf("expected an error with exit code 1, got: %v", err)
+	}
+
+	expected := filepath.Join(tmpDir, "a.yaml") + "\n" + filepath.Join(tmpDir, "c.yaml") + "\n"
+	if got := stderr.String(); got != expected {
+		t.Errorf("expected stderr %q, but got %q", expected, got)
+	}
+	if stdout.Len() != 0 {
+		t.Errorf("expected no output, but got %q", stdout.String())
+	}
+
+	for name, original := range files {
+		content, err := os.ReadFile(filepath.Join(tmpDir, name))
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", name, err)
+		}
+		if string(content) != original {
+			t.Errorf("expected %s to be unmodified, but got %q", name, string(content))
+		}
+	}
+
+	stderr.Reset()
+	if err := run(t.Context(), discardLogger(), strings.NewReader(""), &stdout, &stderr, []string{"-check", args[1], args[3]}); err != nil {
+		t.Errorf("expected no error for normalized files, got: %v", err)
+	}
+	if stderr.Len() != 0 {
+		t.Errorf("expected no files to be reported, but got %q", stderr.String())
+	}
+}
+
+func TestRun_Diff(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	clean := filepath.Join(tmpDir, "clean.yaml")
+	messy := filepath.Join(tmpDir, "messy.yaml")
+
+	if err := os.WriteFile(clean, []byte("a: 1\nb: 2\n"), 0644); err != nil {
+		t.Fatalf("failed to write clean file: %v", err)
+	}
+	if err := os.WriteFile(messy, []byte("b: 2\na: 1\n"), 0644); err != nil {
+		t.Fatalf("failed to write messy file: %v", err)
+	}
+
+	expected := "--- " + messy + "\n+++ " + messy + "\n@@ -1,2 +1,2 @@\n-b: 2\n a: 1\n+b: 2\n"
+
+	t.Run("clean file", func(t *testing.T) {
+		var stdout bytes.Buffer
+		if err := run(t.Context(), discardLogger(), strings.NewReader(""), &stdout, io.Discard, []string{"-diff", clean}); err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if stdout.Len() != 0 {
+			t.Errorf("expected no diff, but got %q", stdout.String())
+		}
+	})
+
+	var stdout bytes.Buffer
+	if err := run(t.Context(), discardLogger(), strings.NewReader(""), &stdout, io.Discard, []string{"-diff", clean, messy}); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if got := stdout.String(); got != expected {
+		t.Errorf("expected output %q, but got %q", expected, got)
+	}
+	content, err := os.ReadFile(messy)
+	if err != nil {
+		t.Fatalf("failed to read messy file: %v", err)
+	}
+	if string(content) != "b: 2\na: 1\n" {
+		t.Errorf("expected messy file to be unmodified, but got %q", string(content))
+	}
+
+	stdout.Reset()
+	if err := run(t.Context(), discardLogger(), strings.NewReader(""), &stdout, io.Discard, []string{"-diff", "-i", clean, messy}); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if got := stdout.String(); got != expected {
+		t.Errorf("expected output %q, but got %q", expected, got)
+	}
+	content, err = os.ReadFile(messy)
+	if err != nil {
+		t.Fatalf("failed to read messy file: %v", err)
+	}
+	if string(content) != "a: 1\nb: 2\n" {
+		t.Errorf("expected messy file to be normalized, but got %q", string(content))
+	}
+}
+
+func TestRun_Comments(t *testing.T) {
+	t.Parallel()
+
+	input := `c: 3
+# head
+b: 2 # line
+a: 1
+`
+
+	testCases := []struct {
+		name     string
+		args     []string
+		expected string
+	}{
+		{
+			name:     "default",
+			args:     nil,
+			expected: "a: 1\nb: 2\nc: 3\n",
+		},
+		{
+			name:     "disabled",
+			args:     []string{"-c=false"},
+			expected: "a: 1\nb: 2\nc: 3\n",
+		},
+		{
+			name:     "enabled",
+			args:     []string{"-c"},
+			expected: "a: 1\n# head\nb: 2 # line\nc: 3\n",
+		},
+		{
+			name:     "long form",
+			args:     []string{"--comments"},
+			expected: "a: 1\n# head\nb: 2 # line\nc: 3\n",
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var stdout bytes.Buffer
+			if err := run(t.Context(), discardLogger(), strings.NewReader(input), &stdout, io.Discard, tt.args); err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+
+			if got := stdout.String(); got != tt.expected {
+				t.Errorf("expected output %q, but got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestRun_Indent(t *testing.T) {
+	t.Parallel()
+
+	var stdout bytes.Buffer
+	if err := run(t.Context(), discardLogger(), strings.NewReader("a:\n  b: [1]\n"), &stdout, io.Discard, []string{"-indent", "4"}); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	expected := "a:\n    b:\n        - 1\n"
+	if got := stdout.String(); got != expected {
+		t.Errorf("expected output %q, but got %q", expected, got)
+	}
+
+	err := run(t.Context(), discardLogger(), strings.NewReader("a: 1\n"), io.Discard, io.Discard, []string{"-indent", "0"})
+	var exitErr *errWithExitCode
+	if !errors.As(err, &exitErr) || exitErr.Code != 2 {
+		t.Errorf("expected an error with exit code 2, got: %v", err)
+	}
+}
+
+func TestRun_ExpectSums(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	good := filepath.Join(tmpDir, "good.yaml")
+	bad := filepath.Join(tmpDir, "bad.yaml")
+
+	// Checksums are of the normalized content, so the files themselves
+	// don't need to be normalized
+	if err := os.WriteFile(good, []byte("b: 2\na: 1\n"), 0644); err != nil {
+		t.Fatalf("failed to write good file: %v", err)
+	}
+	if err := os.WriteFile(bad, []byte("a: 1\n"), 0644); err != nil {
+		t.Fatalf("failed to write bad file: %v", err)
+	}
+
+	goodSum := sha256.Sum256([]byte("a: 1\nb: 2\n"))
+	badSum := sha256.Sum256([]byte("a: 2\n"))
+	sums := fmt.Sprintf("%x  %s\n%x  %s\n", goodSum, good, badSum, bad)
+	sumsFile := filepath.Join(tmpDir, "sums.txt")
+	if err := os.WriteFile(sumsFile, []byte(sums), 0644); err != nil {
+		t.Fatalf("failed to write checksum file: %v", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	err := run(t.Context(), discardLogger(), strings.NewReader(""), &stdout, &stderr, []string{"-expect-sums", sumsFile})
+	var exitErr *errWithExitCode
+	if !errors.As(err, &exitErr) || exitErr.Code != 1 {
+		t.Fatalf("expected an error with exit code 1, got: %v", err)
+	}
+	if expected := bad + ": checksum mismatch\n"; stderr.String() != expected {
+		t.Errorf("expected stderr %q, but got %q", expected, stderr.String())
+	}
+
+	// With only the correct entry, verification passes
+	if err := os.WriteFile(sumsFile, []byte(fmt.Sprintf("%x  %s\n", goodSum, good)), 0644); err != nil {
+		t.Fatalf("failed to write checksum file: %v", err)
+	}
+	stderr.Reset()
+	if err := run(t.Context(), discardLogger(), strings.NewReader(""), &stdout, &stderr, []string{"-expect-sums", sumsFile}); err != nil {
+		t.Errorf("expected no error, got: %v", err)
+	}
+	if stdout.Len() != 0 || stderr.Len() != 0 {
+		t.Errorf("expected no output, but got stdout %q and stderr %q", stdout.String(), stderr.String())
+	}
+}
+
+func TestRun_SinceStdin(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	modified := filepath.Join(tmpDir, "modified.yaml")
+	added := filepath.Join(tmpDir, "added.yaml")
+	untouched := filepath.Join(tmpDir, "untouched.yaml")
+	for _, filename := range []string{modified, added, untouched} {
+		if err := os.WriteFile(filename, []byte("b: 2\na: 1\n"), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", filename, err)
+		}
+	}
+
+	// The added lines look like file headers, but are part of a hunk
+	diff := fmt.Sprintf(`--- %[1]s	2024-01-01 00:00:00
++++ %[1]s	2024-01-02 00:00:00
+@@ -1 +1,3 @@
+-a: 1
++b: 2
++++ a: 1
++--- c: 3
+--- /dev/null
++++ %[2]s
+@@ -0,0 +1,2 @@
++b: 2
++a: 1
+--- %[3]s
++++ /dev/null
+@@ -1 +0,0 @@
+-a: 1
+`, modified, added, filepath.Join(tmpDir, "deleted.yaml"))
+
+	var stdout bytes.Buffer
+	if err := run(t.Context(), discardLogger(), strings.NewReader(diff), &stdout, io.Discard, []string{"-since-stdin"}); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	expected := map[string]string{
+		modified:  "a: 1\nb: 2\n",
+		added:     "a: 1\nb: 2\n",
+		untouched: "b: 2\na: 1\n",
+	}
+	for filename, want := range expected {
+		content, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", filename, err)
+		}
+		if string(content) != want {
+			t.Errorf("expected %s to contain %q, but got %q", filepath.Base(filename), want, string(content))
+		}
+	}
+}
+
+func TestRun_SinceStdinNonYAML(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	notes := filepath.Join(tmpDir, "NOTES.txt")
+	config := filepath.Join(tmpDir, "config.yaml")
+	original := "b: 2\na: 1\n"
+	for _, filename := range []string{notes, config} {
+		if err := os.WriteFile(filename, []byte(original), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", filename, err)
+		}
+	}
+
+	diff := fmt.Sprintf(`--- %[1]s
++++ %[1]s
+@@ -1 +1,2 @@
++b: 2
+ a: 1
+--- %[2]s
++++ %[2]s
+@@ -1 +1,2 @@
++b: 2
+ a: 1
+`, notes, config)
+
+	var stdout bytes.Buffer
+	if err := run(t.Context(), discardLogger(), strings.NewReader(diff), &stdout, io.Discard, []string{"-since-stdin"}); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	expected := map[string]string{
+		notes:  original,
+		config: "a: 1\nb: 2\n",
+	}
+	for filename, want := range expected {
+		content, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", filename, err)
+		}
+		if string(content) != want {
+			t.Errorf("expected %s to contain %q, but got %q", filepath.Base(filename), want, string(content))
+		}
+	}
+}
+
+func TestPatchFiles_Git(t *testing.T) {
+	t.Parallel()
+
+	diff := `diff --git a/one.yaml b/one.yaml
+index 0000000..1111111 100644
+--- a/one.yaml
++++ b/one.yaml
+@@ -1,2 +1,2 @@
+ a: 1
+-b: 2
++b: 3
+diff --git a/gone.yaml b/gone.yaml
+deleted file mode 100644
+--- a/gone.yaml
++++ /dev/null
+@@ -1 +0,0 @@
+-a: 1
+diff --git a/dir/two.yaml b/dir/two.yaml
+new file mode 100644
+--- /dev/null
++++ b/dir/two.yaml
+@@ -0,0 +1 @@
++a: 1
+`
+
+	files, err := patchFiles(strings.NewReader(diff))
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if expected := []string{"one.yaml", "dir/two.yaml"}; !reflect.DeepEqual(files, expected) {
+		t.Errorf("expected files %q, but got %q", expected, files)
+	}
+}
+
+func TestRun_K8s(t *testing.T) {
+	t.Parallel()
+
+	input := "spec: {}\nkind: Service\nmetadata:\n  name: web\napiVersion: v1\n"
+	expected := "apiVersion: v1\nkind: Service\nmetadata:\n  name: web\nspec: {}\n"
+
+	var stdout bytes.Buffer
+	if err := run(t.Context(), discardLogger(), strings.NewReader(input), &stdout, io.Discard, []string{"-k8s"}); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if got := stdout.String(); got != expected {
+		t.Errorf("expected output %q, but got %q", expected, got)
+	}
+}
+
+func TestRun_InPlaceList(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	clean := filepath.Join(tmpDir, "clean.yaml")
+	messy := filepath.Join(tmpDir, "messy.yaml")
+
+	if err := os.WriteFile(clean, []byte("a: 1\nb: 2\n"), 0644); err != nil {
+		t.Fatalf("failed to write clean file: %v", err)
+	}
+	if err := os.WriteFile(messy, []byte("b: 2\na: 1\n"), 0644); err != nil {
+		t.Fatalf("failed to write messy file: %v", err)
+	}
+
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(clean, past, past); err != nil {
+		t.Fatalf("failed to set file times: %v", err)
+	}
+
+	var stdout bytes.Buffer
+	if err := run(t.Context(), discardLogger(), strings.NewReader(""), &stdout, io.Discard, []string{"-i", "-list", "-backup", clean, messy}); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if expected := messy + "\n"; stdout.String() != expected {
+		t.Errorf("expected output %q, but got %q", expected, stdout.String())
+	}
+
+	// Only the rewritten file is backed up
+	if backup, err := os.ReadFile(messy + ".bak"); err != nil {
+		t.Errorf("expected a backup of the messy file: %v", err)
+	} else if string(backup) != "b: 2\na: 1\n" {
+		t.Errorf("expected backup to contain the original content, got %q", string(backup))
+	}
+	if _, err := os.Stat(clean + ".bak"); !os.IsNotExist(err) {
+		t.Errorf("expected no backup of the clean file, got: %v", err)
+	}
+
+	cleanInfo, err := os.Stat(clean)
+	if err != nil {
+		t.Fatalf("failed to stat clean file: %v", err)
+	}
+	if !cleanInfo.ModTime().Equal(past) {
+		t.Errorf("expected clean file to not be written, but its mtime changed to %v", cleanInfo.ModTime())
+	}
+
+	content, err := os.ReadFile(messy)
+	if err != nil {
+		t.Fatalf("failed to read messy file: %v", err)
+	}
+	if string(content) != "a: 1\nb: 2\n" {
+		t.Errorf("expected messy file to be normalized, got %q", string(content))
+	}
+
+	// Once everything is normalized, nothing is listed
+	stdout.Reset()
+	if err := run(t.Context(), discardLogger(), strings.NewReader(""), &stdout, io.Discard, []string{"-i", "-list", clean, messy}); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if stdout.Len() != 0 {
+		t.Errorf("expected no output, but got %q", stdout.String())
+	}
+}
+
+func TestRun_ScopeKeys(t *testing.T) {
+	t.Parallel()
+
+	input := `spec: {replicas: 1}
+metadata: {name: web}
+---
+zeta: {b: 2, a: 1}
+alpha: 1
+`
+	expected := `metadata:
+  name: web
+spec:
+  replicas: 1
+---
+zeta: {b: 2, a: 1}
+alpha: 1
+`
+
+	stdin := strings.NewReader(input)
+	var stdout bytes.Buffer
+
+	if err := run(t.Context(), discardLogger(), stdin, &stdout, io.Discard, []string{"-scope-keys", "replicas"}); err != nil {
+		t.Errorf("expected no error, got: %v", err)
+	}
+
+	if result := stdout.String(); result != expected {
+		t.Errorf("expected output %q, but got %q", expected, result)
+	}
+}
+
+func TestRun_GitHubAnnotations(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "broken.yaml")
+	if err := os.WriteFile(file, []byte("a: 1\nb: 2\nc: d: e\n"), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	var stderr bytes.Buffer
+	err := run(t.Context(), discardLogger(), strings.NewReader(""), io.Discard, &stderr, []string{"-format-annotations", "github", file})
+	if err == nil {
+		t.Fatalf("expected an error, got none")
+	}
+
+	prefix := "::error file=" + escapeGitHubProperty(file) + ",line=3::"
+	if result := stderr.String(); !strings.HasPrefix(result, prefix) || strings.Count(result, "\n") != 1 {
+		t.Errorf("expected a single annotation starting with %q, but got %q", prefix, result)
+	}
+}
+
+func TestRun_SortOutput(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"b.yaml": "name: b\n",
+		"c.yaml": "name: c\n",
+		"a.yaml": "name: a\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+
+	expected := "name: a\n---\nname: b\n---\nname: c\n"
+	for _, order := range [][]string{{"c.yaml", "a.yaml", "b.yaml"}, {"b.yaml", "c.yaml", "a.yaml"}} {
+		args := []string{"-sort-output"}
+		for _, name := range order {
+			args = append(args, filepath.Join(dir, name))
+		}
+
+		var stdout bytes.Buffer
+		if err := run(t.Context(), discardLogger(), strings.NewReader(""), &stdout, io.Discard, args); err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if result := stdout.String(); result != expected {
+			t.Errorf("expected output %q for files %q, but got %q", expected, order, result)
+		}
+	}
+}
+
+func TestRun_FromJSON(t *testing.T) {
+	t.Parallel()
+
+	input := `{"spec": {"replicas": 3, "image": "web"}, "kind": "Deployment"}`
+	expected := `kind: Deployment
+spec:
+  image: web
+  replicas: 3
+`
+
+	stdin := strings.NewReader(input)
+	var stdout bytes.Buffer
+
+	if err := run(t.Context(), discardLogger(), stdin, &stdout, io.Discard, []string{"-from-json"}); err != nil {
+		t.Errorf("expected no error, got: %v", err)
+	}
+
+	if result := stdout.String(); result != expected {
+		t.Errorf("expected output %q, but got %q", expected, result)
+	}
+}
+
+func TestRun_Zip(t *testing.T) {
+	t.Parallel()
+
+	modified := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	entries := []struct {
+		name    string
+		content string
+		method  uint16
+	}{
+		{name: "config/app.yaml", content: "name: web\nimage: {tag: v1, repo: web}\n", method: zip.Deflate},
+		{name: "README.txt", content: "z: 1\na: 2\n", method: zip.Store},
+		{name: "values.yml", content: "b: 1\na: 2\n", method: zip.Deflate},
+	}
+
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	for _, entry := range entries {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: entry.name, Method: entry.method, Modified: modified, Comment: "entry " + entry.name})
+		if err != nil {
+			t.Fatalf("failed to create zip entry: %v", err)
+		}
+		if _, err := io.WriteString(w, entry.content); err != nil {
+			t.Fatalf("failed to write zip entry: %v", err)
+		}
+	}
+	if err := zw.SetComment("bundle"); err != nil {
+		t.Fatalf("failed to set zip comment: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("failed to close zip: %v", err)
+	}
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "bundle.zip")
+	dst := filepath.Join(dir, "out.zip")
+	if err := os.WriteFile(src, archive.Bytes(), 0o644); err != nil {
+		t.Fatalf("failed to write zip: %v", err)
+	}
+
+	if err := run(t.Context(), discardLogger(), strings.NewReader(""), io.Discard, io.Discard, []string{"-zip", src, "-o", dst}); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	r, err := zip.OpenReader(dst)
+	if err != nil {
+		t.Fatalf("failed to open output zip: %v", err)
+	}
+	defer r.Close()
+
+	if r.Comment != "bundle" {
+		t.Errorf("expected archive comment %q, but got %q", "bundle", r.Comment)
+	}
+
+	expected := map[string]string{
+		"config/app.yaml": "image:\n  repo: web\n  tag: v1\nname: web\n",
+		"README.txt":      "z: 1\na: 2\n",
+		"values.yml":      "a: 2\nb: 1\n",
+	}
+	if len(r.File) != len(entries) {
+		t.Fatalf("expected %d entries, but got %d", len(entries), len(r.File))
+	}
+	for i, f := range r.File {
+		entry := entries[i]
+		if f.Name != entry.name {
+			t.Errorf("expected entry %d to be %q, but got %q", i, entry.name, f.Name)
+		}
+		if f.Method != entry.method || !f.Modified.Equal(modified) || f.Comment != "entry "+entry.name {
+			t.Errorf("expected metadata of entry %q to be preserved, got method %d, modified %v, comment %q", f.Name, f.Method, f.Modified, f.Comment)
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("failed to open entry %q: %v", f.Name, err)
+		}
+		content, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("failed to read entry %q: %v", f.Name, err)
+		}
+		if string(content) != expected[f.Name] {
+			t.Errorf("expected entry %q to contain %q, but got %q", f.Name, expected[f.Name], string(content))
+		}
+	}
+}
+
+func TestRun_ZipSameOutput(t *testing.T) {
+	t.Parallel()
+
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	w, err := zw.Create("values.yaml")
+	if err != nil {
+		t.Fatalf("failed to create zip entry: %v", err)
+	}
+	if _, err := io.WriteString(w, "b: 1\na: 2\n"); err != nil {
+		t.Fatalf("failed to write zip entry: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("failed to close zip: %v", err)
+	}
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "bundle.zip")
+	if err := os.WriteFile(src, archive.Bytes(), 0o644); err != nil {
+		t.Fatalf("failed to write zip: %v", err)
+	}
+
+	// The archive is read in full before it is replaced
+	if err := run(t.Context(), discardLogger(), strings.NewReader(""), io.Discard, io.Discard, []string{"-zip", src, "-o", filepath.Join(dir, ".", "bundle.zip")}); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	r, err := zip.OpenReader(src)
+	if err != nil {
+		t.Fatalf("failed to open output zip: %v", err)
+	}
+	defer r.Close()
+	if len(r.File) != 1 {
+		t.Fatalf("expected 1 entry, but got %d", len(r.File))
+	}
+	rc, err := r.File[0].Open()
+	if err != nil {
+		t.Fatalf("failed to open entry: %v", err)
+	}
+	content, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		t.Fatalf("failed to read entry: %v", err)
+	}
+	if expected := "a: 2\nb: 1\n"; string(content) != expected {
+		t.Errorf("expected entry to contain %q, but got %q", expected, string(content))
+	}
+}
+
+func TestRun_Recursive(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.yaml":             "b: 1\na: 2\n",
+		"nested/b.yml":       "y: 1\nx: 2\n",
+		"nested/deep/c.YAML": "d: 1\nc: 2\n",
+		"nested/notes.txt":   "z: 1\na: 2\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+
+	err := run(t.Context(), discardLogger(), strings.NewReader(""), io.Discard, io.Discard, []string{"-i", dir})
+	var exitErr *errWithExitCode
+	if !errors.As(err, &exitErr) || exitErr.Code != 2 {
+		t.Errorf("expected a usage error for a directory without -r, got: %v", err)
+	}
+
+	if err := run(t.Context(), discardLogger(), strings.NewReader(""), io.Discard, io.Discard, []string{"-i", "-r", dir}); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	expected := map[string]string{
+		"a.yaml":             "a: 2\nb: 1\n",
+		"nested/b.yml":       "x: 2\ny: 1\n",
+		"nested/deep/c.YAML": "c: 2\nd: 1\n",
+		"nested/notes.txt":   "z: 1\na: 2\n",
+	}
+	for name, want := range expected {
+		content, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", name, err)
+		}
+		if string(content) != want {
+			t.Errorf("expected %s to contain %q, but got %q", name, want, string(content))
+		}
+	}
+}
+
+func TestRun_Globs(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.yaml":          "b: 1\na: 2\n",
+		"b.yaml":          "d: 1\nc: 2\n",
+		"skip.txt":        "z: 1\na: 2\n",
+		"literal[1].yaml": "f: 1\ne: 2\n",
+		"sub/c.yaml":      "h: 1\ng: 2\n",
+		"sub/deep/d.yaml": "j: 1\ni: 2\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		args     []string
+		expected string
+	}{
+		{
+			name:     "star",
+			args:     []string{filepath.Join(dir, "[ab].yaml"), filepath.Join(dir, "*.txt")},
+			expected: "a: 2\nb: 1\n---\nc: 2\nd: 1\n---\na: 2\nz: 1\n",
+		},
+		{
+			// "**" is not special, so it only matches a single directory
+			name:     "doublestar",
+			args:     []string{filepath.Join(dir, "**", "*.yaml")},
+			expected: "g: 2\nh: 1\n",
+		},
+		{
+			name:     "literal file with metacharacters",
+			args:     []string{filepath.Join(dir, "literal[1].yaml")},
+			expected: "e: 2\nf: 1\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var stdout bytes.Buffer
+			if err := run(t.Context(), discardLogger(), strings.NewReader(""), &stdout, io.Discard, tt.args); err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+			if result := stdout.String(); result != tt.expected {
+				t.Errorf("expected output %q, but got %q", tt.expected, result)
+			}
+		})
+	}
+
+	pattern := filepath.Join(dir, "*.json")
+	err := run(t.Context(), discardLogger(), strings.NewReader(""), io.Discard, io.Discard, []string{pattern})
+	if err == nil || !strings.Contains(err.Error(), pattern) {
+		t.Errorf("expected an error naming the pattern %q, got: %v", pattern, err)
+	}
+}
+
+func TestRun_RecursiveExtensions(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		args     []string
+		expected map[string]string
+	}{
+		{
+			name: "yaml only",
+			args: []string{"-ext", "yaml"},
+			expected: map[string]string{
+				"a.yaml":      "a: 2\nb: 1\n",
+				"b.yml":       "b: 1\na: 2\n",
+				"c.json":      "{\"b\": 1, \"a\": 2}\n",
+				"d.YAML.tmpl": "b: 1\na: 2\n",
+			},
+		},
+		{
+			name: "multi-part extension",
+			args: []string{"-ext", ".yaml.tmpl,YML"},
+			expected: map[string]string{
+				"a.yaml":      "b: 1\na: 2\n",
+				"b.yml":       "a: 2\nb: 1\n",
+				"c.json":      "{\"b\": 1, \"a\": 2}\n",
+				"d.YAML.tmpl": "a: 2\nb: 1\n",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			files := map[string]string{
+				"a.yaml":      "b: 1\na: 2\n",
+				"b.yml":       "b: 1\na: 2\n",
+				"c.json":      "{\"b\": 1, \"a\": 2}\n",
+				"d.YAML.tmpl": "b: 1\na: 2\n",
+			}
+			for name, content := range files {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+					t.Fatalf("failed to write %s: %v", name, err)
+				}
+			}
+
+			args := append([]string{"-i", "-r"}, tt.args...)
+			if err := run(t.Context(), discardLogger(), strings.NewReader(""), io.Discard, io.Discard, append(args, dir)); err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+
+			for name, want := range tt.expected {
+				content, err := os.ReadFile(filepath.Join(dir, name))
+				if err != nil {
+					t.Fatalf("failed to read %s: %v", name, err)
+				}
+				if string(content) != want {
+					t.Errorf("expected %s to contain %q, but got %q", name, want, string(content))
+				}
+			}
+		})
+	}
+}
+
+func TestRun_DumpTree(t *testing.T) {
+	t.Parallel()
+
+	stdin := strings.NewReader("b: 1\na: [x]\n")
+	var stdout, stderr bytes.Buffer
+
+	if err := run(t.Context(), discardLogger(), stdin, &stdout, &stderr, []string{"-dump-tree"}); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	expected := "a:\n  - x\nb: 1\n"
+	if result := stdout.String(); result != expected {
+		t.Errorf("expected output %q, but got %q", expected, result)
+	}
+	for _, want := range []string{"DocumentNode", "MappingNode tag=!!map", "SequenceNode tag=!!seq style=flow", `ScalarNode tag=!!int value="1"`} {
+		if !strings.Contains(stderr.String(), want) {
+			t.Errorf("expected tree to contain %q, but got %q", want, stderr.String())
+		}
+	}
+}
+
+func TestRun_Backup(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "test.yaml")
+	original := "b: 1\na: 2\n"
+	if err := os.WriteFile(file, []byte(original), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	if err := run(t.Context(), discardLogger(), strings.NewReader(""), io.Discard, io.Discard, []string{"-i", "-backup", "-backup-suffix", ".orig", file}); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	content, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+	if string(content) != "a: 2\nb: 1\n" {
+		t.Errorf("expected file to be normalized, got %q", string(content))
+	}
+	backup, err := os.ReadFile(file + ".orig")
+	if err != nil {
+		t.Fatalf("failed to read backup: %v", err)
+	}
+	if string(backup) != original {
+		t.Errorf("expected backup %q, but got %q", original, string(backup))
+	}
+
+	err = run(t.Context(), discardLogger(), strings.NewReader(original), io.Discard, io.Discard, []string{"-backup"})
+	var exitErr *errWithExitCode
+	if !errors.As(err, &exitErr) || exitErr.Code != 2 {
+		t.Errorf("expected a usage error for -backup without -i, got: %v", err)
+	}
+}
+
+func TestRun_Stdout(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "test.yaml")
+	original := "b: 1\na: 2\n"
+	if err := os.WriteFile(file, []byte(original), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	var stdout bytes.Buffer
+	if err := run(t.Context(), discardLogger(), strings.NewReader(""), &stdout, io.Discard, []string{"-stdout", file}); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if stdout.String() != "a: 2\nb: 1\n" {
+		t.Errorf("expected output %q, but got %q", "a: 2\nb: 1\n", stdout.String())
+	}
+
+	for _, args := range [][]string{
+		{"-stdout", "-i", file},
+		{"-stdout", "-o", filepath.Join(dir, "out.yaml"), file},
+		{"-stdout", "-check", file},
+		{"-stdout", "-diff", file},
+		{"-stdout", "-list", file},
+	} {
+		stdout.Reset()
+		err := run(t.Context(), discardLogger(), strings.NewReader(""), &stdout, io.Discard, args)
+		var exitErr *errWithExitCode
+		if !errors.As(err, &exitErr) || exitErr.Code != 2 {
+			t.Errorf("expected a usage error for %q, got: %v", args, err)
+		} else if !strings.Contains(err.Error(), "-stdout cannot be used with -i") {
+			t.Errorf("expected error to mention -stdout and -i, got: %v", err)
+		}
+	}
+
+	content, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+	if string(content) != original {
+		t.Errorf("expected file to be unchanged, got %q", string(content))
+	}
+}
+
+func TestRun_NoEmptyOutput(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "test.yaml")
+	original := "# only a comment\n"
+	if err := os.WriteFile(file, []byte(original), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	if err := run(t.Context(), discardLogger(), strings.NewReader(""), io.Discard, io.Discard, []string{"-i", "-no-empty-output", file}); err == nil {
+		t.Fatal("expected an error for empty output, got none")
+	}
+
+	content, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+	if string(content) != original {
+		t.Errorf("expected file to be unchanged, got %q", string(content))
+	}
+}
+
+func TestRun_HoistHeader(t *testing.T) {
+	t.Parallel()
+
+	input := "# SPDX-License-Identifier: MIT\n---\nb: 1\na: 2\n---\nc: 3\n"
+	expected := "# SPDX-License-Identifier: MIT\n\na: 2\nb: 1\n---\nc: 3\n"
+
+	var stdout bytes.Buffer
+	if err := run(t.Context(), discardLogger(), strings.NewReader(input), &stdout, io.Discard, []string{"-hoist-header"}); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if stdout.String() != expected {
+		t.Errorf("expected output %q, but got %q", expected, stdout.String())
+	}
+}
+
+func TestRun_FilesFrom(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	var files []string
+	for _, name := range []string{"a.yaml", "b c.yml", "skipped.yaml"} {
+		file := filepath.Join(dir, name)
+		if err := os.WriteFile(file, []byte("b: 1\na: 2\n"), 0o644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+		files = append(files, file)
+	}
+	list := "# generated by find\n" + files[0] + "\n\n  " + files[1] + "  \n"
+
+	listFile := filepath.Join(dir, "files.txt")
+	if err := os.WriteFile(listFile, []byte(list), 0o644); err != nil {
+		t.Fatalf("failed to write file list: %v", err)
+	}
+	if err := run(t.Context(), discardLogger(), strings.NewReader(""), io.Discard, io.Discard, []string{"-i", "-files-from", listFile}); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	for i, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatalf("failed to read file: %v", err)
+		}
+		expected := "a: 2\nb: 1\n"
+		if i == 2 {
+			expected = "b: 1\na: 2\n"
+		}
+		if string(content) != expected {
+			t.Errorf("expected %s to contain %q, but got %q", file, expected, string(content))
+		}
+	}
+
+	var stdout bytes.Buffer
+	if err := run(t.Context(), discardLogger(), strings.NewReader(files[2]+"\n"), &stdout, io.Discard, []string{"-files-from", "-"}); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if stdout.String() != "a: 2\nb: 1\n" {
+		t.Errorf("expected output %q, but got %q", "a: 2\nb: 1\n", stdout.String())
+	}
+
+	stdout.Reset()
+	if err := run(t.Context(), discardLogger(), strings.NewReader("# nothing\n"), &stdout, io.Discard, []string{"-files-from", "-"}); err != nil {
+		t.Fatalf("expected no error for an empty list, got: %v", err)
+	}
+	if stdout.Len() != 0 {
+		t.Errorf("expected no output for an empty list, but got %q", stdout.String())
+	}
+}
+
+func TestRun_FilesFromNul(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	var list strings.Builder
+	var files []string
+	for _, name := range []string{"with space.yaml", "with\nnewline.yaml", " leading.yaml"} {
+		file := filepath.Join(dir, name)
+		if err := os.WriteFile(file, []byte("b: 1\na: 2\n"), 0o644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+		files = append(files, file)
+		list.WriteString(file + "\x00")
+	}
+
+	if err := run(t.Context(), discardLogger(), strings.NewReader(list.String()), io.Discard, io.Discard, []string{"-i", "-0", "-files-from", "-"}); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatalf("failed to read file: %v", err)
+		}
+		if string(content) != "a: 2\nb: 1\n" {
+			t.Errorf("expected %q to be normalized, but got %q", file, string(content))
+		}
+	}
+
+	err := run(t.Context(), discardLogger(), strings.NewReader(""), io.Discard, io.Discard, []string{"-0"})
+	var exitErr *errWithExitCode
+	if !errors.As(err, &exitErr) || exitErr.Code != 2 {
+		t.Errorf("expected a usage error for -0 without -files-from, got: %v", err)
+	}
+}
+
+func TestRun_VerboseSummary(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	var files []string
+	for i, content := range []string{"a: 1\nb: 2\n", "b: 2\na: 1\n", "a: 1\n", "c: 3\na: 1\n"} {
+		file := filepath.Join(dir, fmt.Sprintf("test%d.yaml", i))
+		if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+		files = append(files, file)
+	}
+
+	var logOutput bytes.Buffer
+	logger := log.New(&logOutput, "", 0)
+	args := append([]string{"-v", "-i", "-j", "2"}, files...)
+	if err := run(t.Context(), logger, strings.NewReader(""), io.Discard, io.Discard, args); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	expected := "2 files changed, 2 unchanged, 0 failed\n"
+	if !strings.HasSuffix(logOutput.String(), expected) {
+		t.Errorf("expected log to end with %q, but got %q", expected, logOutput.String())
+	}
+
+	invalid := filepath.Join(dir, "invalid.yaml")
+	if err := os.WriteFile(invalid, []byte("key: [unclosed\n"), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	logOutput.Reset()
+	if err := run(t.Context(), logger, strings.NewReader(""), io.Discard, io.Discard, []string{"-v", "-i", "-j", "1", files[0], invalid}); err == nil {
+		t.Fatal("expected an error for invalid YAML, got none")
+	}
+
+	expected = "0 files changed, 1 unchanged, 1 failed\n"
+	if !strings.HasSuffix(logOutput.String(), expected) {
+		t.Errorf("expected log to end with %q, but got %q", expected, logOutput.String())
+	}
+}
+
+func TestRun_VerifyMerge(t *testing.T) {
+	t.Parallel()
+
+	input := "base: &base\n  z: 1\n  a: 2\njob:\n  <<: *base\n  z: 3\n"
+	expected := "base: &base\n  a: 2\n  z: 1\njob:\n  <<: *base\n  z: 3\n"
+
+	var stdout bytes.Buffer
+	if err := run(t.Context(), discardLogger(), strings.NewReader(input), &stdout, io.Discard, []string{"-verify-merge"}); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if stdout.String() != expected {
+		t.Errorf("expected output %q, but got %q", expected, stdout.String())
+	}
+}
+
+func TestRun_DropEmpty(t *testing.T) {
+	t.Parallel()
+
+	input := "---\na: 1\n---\n---\nb: 2\n---\n"
+	expected := "a: 1\n---\nb: 2\n"
+
+	var stdout bytes.Buffer
+	if err := run(t.Context(), discardLogger(), strings.NewReader(input), &stdout, io.Discard, []string{"-drop-empty"}); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if stdout.String() != expected {
+		t.Errorf("expected output %q, but got %q", expected, stdout.String())
+	}
+}
+
+func TestRun_Serve(t *testing.T) {
+	t.Parallel()
+
+	file := filepath.Join(t.TempDir(), "test.yaml")
+	if err := os.WriteFile(file, []byte("z: 1\ny: 2\n"), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	path, err := json.Marshal(file)
+	if err != nil {
+		t.Fatalf("failed to encode path: %v", err)
+	}
+
+	stdin := `{"id": 1, "content": "b: 1\na: 2\n"}
+{"id": "two", "path": ` + string(path) + `}
+{"id": 3, "content": "key: [unclosed"}
+{"id": 4}
+{"content": "c: 3"}
+`
+	var stdout bytes.Buffer
+	if err := run(t.Context(), discardLogger(), strings.NewReader(stdin), &stdout, io.Discard, []string{"-serve"}); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	var responses []serveResponse
+	dec := json.NewDecoder(&stdout)
+	for dec.More() {
+		var resp serveResponse
+		if err := dec.Decode(&resp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		responses = append(responses, resp)
+	}
+	if len(responses) != 5 {
+		t.Fatalf("expected 5 responses, but got %d", len(responses))
+	}
+
+	expected := []struct {
+		id      string
+		content string
+		hasErr  bool
+	}{
+		{id: "1", content: "a: 2\nb: 1\n"},
+		{id: `"two"`, content: "y: 2\nz: 1\n"},
+		{id: "3", hasErr: true},
+		{id: "4", hasErr: true},
+		{id: "", content: "c: 3\n"},
+	}
+	for i, want := range expected {
+		got := responses[i]
+		if string(got.ID) != want.id {
+			t.Errorf("response %d: expected id %s, but got %s", i, want.id, got.ID)
+		}
+		if got.Content != want.content {
+			t.Errorf("response %d: expected content %q, but got %q", i, want.content, got.Content)
+		}
+		if (got.Error != "") != want.hasErr {
+			t.Errorf("response %d: expected error %v, but got %q", i, want.hasErr, got.Error)
+		}
+	}
+
+	content, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+	if string(content) != "z: 1\ny: 2\n" {
+		t.Errorf("expected file to be unchanged, got %q", string(content))
+	}
+}
+
+func TestRun_ServeParallelWarnings(t *testing.T) {
+	t.Parallel()
+
+	var docs []string
+	for range 50 {
+		docs = append(docs, "version: 1.10\n")
+	}
+	content, err := json.Marshal(strings.Join(docs, "---\n"))
+	if err != nil {
+		t.Fatalf("failed to encode content: %v", err)
+	}
+
+	stdin := `{"id": 1, "content": ` + string(content) + `}` + "\n"
+	var stdout bytes.Buffer
+	args := []string{"-serve", "-warn-version-floats", "-jobs-per-file", "8"}
+	if err := run(t.Context(), discardLogger(), strings.NewReader(stdin), &stdout, io.Discard, args); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	var resp serveResponse
+	if err := json.Unmarshal(stdout.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if resp.Error != "" {
+		t.Fatalf("expected no error, but got %q", resp.Error)
+	}
+	if len(resp.Warnings) != len(docs) {
+		t.Errorf("expected %d warnings, but got %d", len(docs), len(resp.Warnings))
+	}
+}
+
+func TestRun_ExplicitStart(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	var files []string
+	for i, content := range []string{"b: 1\na: 2\n", "c: 3\n---\nd: 4\n"} {
+		file := filepath.Join(dir, fmt.Sprintf("test%d.yaml", i))
+		if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+		files = append(files, file)
+	}
+
+	var stdout bytes.Buffer
+	args := append([]string{"-explicit-start"}, files...)
+	if err := run(t.Context(), discardLogger(), strings.NewReader(""), &stdout, io.Discard, args); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	expected := "---\na: 2\nb: 1\n---\nc: 3\n---\nd: 4\n"
+	if stdout.String() != expected {
+		t.Errorf("expected output %q, but got %q", expected, stdout.String())
+	}
+}
+
+func TestRun_ExplicitEnd(t *testing.T) {
+	t.Parallel()
+
+	input := "b: 1\na: 2\n---\nc: 3\n"
+	expected := "a: 2\nb: 1\n...\n---\nc: 3\n...\n"
+
+	var stdout bytes.Buffer
+	if err := run(t.Context(), discardLogger(), strings.NewReader(input), &stdout, io.Discard, []string{"-explicit-end"}); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if stdout.String() != expected {
+		t.Errorf("expected output %q, but got %q", expected, stdout.String())
+	}
+}
+
+func TestRun_Concat(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	var files []string
+	for i, content := range []string{"---\nb: 1\na: 2\n---\nc: 3\n", "", "# only a comment\n", "d: 4", "---\n---\n"} {
+		file := filepath.Join(dir, fmt.Sprintf("test%d.yaml", i))
+		if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+		files = append(files, file)
+	}
+
+	args := []string{"-concat", files[0], files[1], "-", files[4], files[2], files[3]}
+	var stdout bytes.Buffer
+	if err := run(t.Context(), discardLogger(), strings.NewReader("f: 6\ne: 5\n"), &stdout, io.Discard, args); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	expected := "a: 2\nb: 1\n---\nc: 3\n---\ne: 5\nf: 6\n---\nd: 4\n"
+	if stdout.String() != expected {
+		t.Errorf("expected output %q, but got %q", expected, stdout.String())
+	}
+
+	stdout.Reset()
+	if err := run(t.Context(), discardLogger(), strings.NewReader(""), &stdout, io.Discard, []string{"-concat", files[1], files[4], "-"}); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if stdout.Len() != 0 {
+		t.Errorf("expected no output for empty inputs, but got %q", stdout.String())
+	}
+
+	err := run(t.Context(), discardLogger(), strings.NewReader(""), io.Discard, io.Discard, []string{"-concat", "-i", files[0]})
+	var exitErr *errWithExitCode
+	if !errors.As(err, &exitErr) || exitErr.Code != 2 {
+		t.Errorf("expected a usage error for -concat with -i, got: %v", err)
+	}
+}
+
+func TestRun_Selector(t *testing.T) {
+	t.Parallel()
+
+	input := "metadata:\n  labels: {app: nginx}\n  name: web\nkind: Pod\n---\nkind: Pod\nmetadata: {name: db}\n"
+	expected := "kind: Pod\nmetadata:\n  labels:\n    app: nginx\n  name: web\n---\nkind: Pod\nmetadata: {name: db}\n"
+
+	var stdout bytes.Buffer
+	if err := run(t.Context(), discardLogger(), strings.NewReader(input), &stdout, io.Discard, []string{"-selector", "app=nginx"}); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if stdout.String() != expected {
+		t.Errorf("expected output %q, but got %q", expected, stdout.String())
+	}
+
+	err := run(t.Context(), discardLogger(), strings.NewReader(input), io.Discard, io.Discard, []string{"-selector", "app"})
+	var exitErr *errWithExitCode
+	if !errors.As(err, &exitErr) || exitErr.Code != 2 {
+		t.Errorf("expected a usage error for an invalid selector, got: %v", err)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// hunkHeader matches the header of a hunk in a unified diff, capturing the
+// number of old and new lines, which default to 1 if omitted.
+var hunkHeader = regexp.MustCompile(`^@@ -\d+(?:,(\d+))? \+\d+(?:,(\d+))? @@`)
+
+// patchFiles reads a unified diff and returns the paths of the files it
+// changes, in the order they first appear. Deleted files are not included.
+// The "b/" prefix on new file names in diffs produced by git is removed.
+func patchFiles(r io.Reader) ([]string, error) {
+	var files []string
+	isGit := false
+	oldName := ""
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			isGit = true
+		case strings.HasPrefix(line, "--- "):
+			oldName = diffFileName(line[len("--- "):])
+		case strings.HasPrefix(line, "+++ "):
+			newName := diffFileName(line[len("+++ "):])
+			if newName == "/dev/null" {
+				continue
+			}
+			if isGit || strings.HasPrefix(oldName, "a/") {
+				newName = strings.TrimPrefix(newName, "b/")
+			}
+			if !slices.Contains(files, newName) {
+				files = append(files, newName)
+			}
+		case strings.HasPrefix(line, "@@ "):
+			// Skip the hunk's lines, so that changed lines that happen to
+			// start with "--- " or "+++ " aren't read as file names
+			m := hunkHeader.FindStringSubmatch(line)
+			if m == nil {
+				return nil, fmt.Errorf("invalid hunk header: %s", line)
+			}
+			oldLines, newLines := hunkCount(m[1]), hunkCount(m[2])
+			for (oldLines > 0 || newLines > 0) && scanner.Scan() {
+				switch text := scanner.Text(); {
+				case strings.HasPrefix(text, "-"):
+					oldLines--
+				case strings.HasPrefix(text, "+"):
+					newLines--
+				case strings.HasPrefix(text, `\`):
+					// "\ No newline at end of file" doesn't count as a line
+				default:
+					oldLines--
+					newLines--
+				}
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read diff: %w", err)
+	}
+
+	return files, nil
+}
+
+// diffFileName returns the file name from a "---" or "+++" line of a diff,
+// without the timestamp that may follow it.
+func diffFileName(s string) string {
+	name, _, _ := strings.Cut(s, "\t")
+	return name
+}
+
+func hunkCount(s string) int {
+	if s == "" {
+		return 1
+	}
+	n, _ := strconv.Atoi(s)
+	return n
+}
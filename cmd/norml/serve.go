@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+
+	"github.com/kanwren/norml/pkg/normalizer"
+)
+
+// serveRequest is a request read by serve. Exactly one of Path and Content
+// must be set.
+type serveRequest struct {
+	// ID is echoed back in the response, so that clients can match
+	// responses to requests
+	ID json.RawMessage `json:"id,omitempty"`
+	// Path is the name of a file to normalize. The file is not modified.
+	Path string `json:"path,omitempty"`
+	// Content is the YAML to normalize
+	Content *string `json:"content,omitempty"`
+}
+
+// serveResponse is the response written by serve for each request.
+type serveResponse struct {
+	ID json.RawMessage `json:"id,omitempty"`
+	// Content is the normalized YAML, if there is no error
+	Content  string   `json:"content"`
+	Warnings []string `json:"warnings,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// serve reads requests from r, each a JSON object such as
+// {"id": 1, "content": "b: 1\na: 2\n"} or {"id": 2, "path": "file.yaml"}, and
+// writes a JSON object on a line of its own to w for each one, such as
+// {"id": 1, "content": "a: 2\nb: 1\n"}, in the same order. Requests that fail
+// get a response with an error rather than stopping the server, which runs
+// until r is closed.
+func serve(ctx context.Context, logger *log.Logger, r io.Reader, w io.Writer, opts normalizer.Options) error {
+	dec := json.NewDecoder(r)
+	enc := json.NewEncoder(w)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		var req serveRequest
+		if err := dec.Decode(&req); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			// The rest of the input can't be read reliably
+			return fmt.Errorf("failed to read request: %w", err)
+		}
+
+		resp := handleServeRequest(logger, req, opts)
+		if err := enc.Encode(resp); err != nil {
+			return fmt.Errorf("failed to write response: %w", err)
+		}
+	}
+}
+
+// handleServeRequest normalizes the input of a single request.
+func handleServeRequest(logger *log.Logger, req serveRequest, opts normalizer.Options) serveResponse {
+	resp := serveResponse{ID: req.ID}
+	// Documents may be normalized in parallel with -jobs-per-file
+	var warnMu sync.Mutex
+	opts.Warn = func(w normalizer.Warning) {
+		warnMu.Lock()
+		defer warnMu.Unlock()
+		resp.Warnings = append(resp.Warnings, w.Error())
+	}
+
+	var content []byte
+	switch {
+	case req.Path != "" && req.Content != nil:
+		resp.Error = "request must have either a path or content, not both"
+		return resp
+	case req.Path != "":
+		logger.Printf("normalizing file: %s", req.Path)
+		data, err := os.ReadFile(req.Path)
+		if err != nil {
+			resp.Error = fmt.Sprintf("failed to read file: %v", err)
+			return resp
+		}
+		content = data
+		opts = opts.WithFile(req.Path)
+	case req.Content != nil:
+		content = []byte(*req.Content)
+	default:
+		resp.Error = "request must have a path or content"
+		return resp
+	}
+
+	normalized, err := normalizer.NormalizeBytes(content, opts)
+	if err != nil {
+		resp.Error = err.Error()
+		return resp
+	}
+	resp.Content = string(normalized)
+	return resp
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/kanwren/norml/pkg/normalizer"
+)
+
+// expectedSum is an entry of a checksum manifest: the SHA-256 of the
+// normalized content of a file.
+type expectedSum struct {
+	sum      string
+	filename string
+}
+
+// readSums reads a checksum manifest in the format written by sha256sum, with
+// a hex-encoded SHA-256 and a path on each line. Blank lines are ignored.
+func readSums(filename string) ([]expectedSum, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open checksum file: %w", err)
+	}
+	defer f.Close()
+
+	var sums []expectedSum
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+
+		sum, path, ok := strings.Cut(text, " ")
+		// sha256sum marks files read in binary mode with "*"
+		path = strings.TrimPrefix(strings.TrimPrefix(path, " "), "*")
+		if decoded, err := hex.DecodeString(sum); !ok || err != nil || len(decoded) != sha256.Size || path == "" {
+			return nil, fmt.Errorf("%s:%d: expected a SHA-256 checksum and a path", filename, line)
+		}
+		sums = append(sums, expectedSum{sum: strings.ToLower(sum), filename: path})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read checksum file: %w", err)
+	}
+	return sums, nil
+}
+
+// verifySums normalizes each file in sums and compares the checksum of the
+// result to the expected one, writing the name of each file that doesn't
+// match to w. It fails with exit code 1 if any don't match.
+func verifySums(ctx context.Context, logger *log.Logger, w io.Writer, sums []expectedSum, numWorkers int, opts normalizer.Options) error {
+	files := make([]string, len(sums))
+	for i, entry := range sums {
+		files[i] = entry.filename
+	}
+
+	var mismatched int
+	err := normalizeFiles(ctx, logger, files, numWorkers, opts, func(result fileResult) error {
+		sum := sha256.Sum256(result.content)
+		if hex.EncodeToString(sum[:]) == sums[result.index].sum {
+			return nil
+		}
+
+		mismatched++
+		if _, err := fmt.Fprintf(w, "%s: checksum mismatch\n", result.filename); err != nil {
+			return fmt.Errorf("failed to write file name: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if mismatched > 0 {
+		return &errWithExitCode{
+			Code: 1,
+			Err:  fmt.Errorf("%d of %d files do not match their expected checksums", mismatched, len(files)),
+		}
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// defaultExtensions are the extensions of the files treated as YAML.
+var defaultExtensions = []string{"yaml", "yml"}
+
+// isYAMLName reports whether name has a YAML file extension.
+func isYAMLName(name string) bool {
+	return hasExtension(name, defaultExtensions)
+}
+
+// hasExtension reports whether name ends with one of exts, ignoring case.
+// Extensions may contain dots, as in "yaml.tmpl", and may be given with or
+// without a leading dot.
+func hasExtension(name string, exts []string) bool {
+	name = strings.ToLower(path.Base(filepath.ToSlash(name)))
+	for _, ext := range exts {
+		if strings.HasSuffix(name, "."+strings.ToLower(strings.TrimPrefix(ext, "."))) {
+			return true
+		}
+	}
+	return false
+}
+
+// expandDirs replaces each directory in files with the files under it with
+// one of exts, or YAML files if there are none, in lexical order, if
+// recursive is set. Otherwise, directories are a usage error.
+func expandDirs(files []string, recursive bool, exts []string) ([]string, error) {
+	if len(exts) == 0 {
+		exts = defaultExtensions
+	}
+
+	var expanded []string
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil || !info.IsDir() {
+			// Leave reporting missing files to normalizing them
+			expanded = append(expanded, file)
+			continue
+		}
+		if !recursive {
+			return nil, &errWithExitCode{
+				Code: 2,
+				Err:  fmt.Errorf("%s is a directory; use -r to normalize the YAML files in it", file),
+			}
+		}
+
+		err = filepath.WalkDir(file, func(name string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.Type().IsRegular() && hasExtension(name, exts) {
+				expanded = append(expanded, name)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list files in %s: %w", file, err)
+		}
+	}
+	return expanded, nil
+}
+
+// expandGlobs replaces each argument in files containing glob metacharacters
+// with the files matching it, as a shell would, for shells that don't expand
+// them. Patterns have the syntax of filepath.Match, so "**" matches like "*"
+// within a single directory rather than across directories; use -r to
+// include subdirectories. Arguments that name an existing file are kept as is.
+func expandGlobs(files []string) ([]string, error) {
+	var expanded []string
+	for _, file := range files {
+		if !strings.ContainsAny(file, "*?[") {
+			expanded = append(expanded, file)
+			continue
+		}
+		if _, err := os.Lstat(file); err == nil {
+			expanded = append(expanded, file)
+			continue
+		}
+
+		matches, err := filepath.Glob(file)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", file, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no files match pattern %q", file)
+		}
+		expanded = append(expanded, matches...)
+	}
+	return expanded, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/kanwren/norml/pkg/normalizer"
+)
+
+// normalizeZip writes a copy of the zip archive src to w with each YAML entry
+// normalized. Other entries are copied without being recompressed, and the
+// metadata of all entries is kept.
+func normalizeZip(ctx context.Context, logger *log.Logger, src string, w io.Writer, opts normalizer.Options) (finalErr error) {
+	r, err := zip.OpenReader(src)
+	if err != nil {
+		return fmt.Errorf("failed to open zip archive %s: %w", src, err)
+	}
+	defer func() {
+		if err := r.Close(); finalErr == nil && err != nil {
+			finalErr = fmt.Errorf("failed to close zip archive %s: %w", src, err)
+		}
+	}()
+
+	zw := zip.NewWriter(w)
+	if err := zw.SetComment(r.Comment); err != nil {
+		return fmt.Errorf("failed to write zip archive: %w", err)
+	}
+	for _, f := range r.File {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if f.FileInfo().IsDir() || !isYAMLName(f.Name) {
+			if err := zw.Copy(f); err != nil {
+				return fmt.Errorf("failed to copy zip entry %s: %w", f.Name, err)
+			}
+			continue
+		}
+
+		logger.Printf("normalizing zip entry: %s", f.Name)
+		if err := normalizeZipEntry(zw, f, opts); err != nil {
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("failed to write zip archive: %w", err)
+	}
+	return nil
+}
+
+// normalizeZipEntry writes the normalized content of the YAML entry f to zw,
+// with the same header apart from its size and checksum.
+func normalizeZipEntry(zw *zip.Writer, f *zip.File, opts normalizer.Options) error {
+	rc, err := f.Open()
+	if err != nil {
+		return fmt.Errorf("failed to open zip entry %s: %w", f.Name, err)
+	}
+	data, err := io.ReadAll(rc)
+	closeErr := rc.Close()
+	if err != nil {
+		return fmt.Errorf("failed to read zip entry %s: %w", f.Name, err)
+	}
+	if closeErr != nil {
+		return fmt.Errorf("failed to read zip entry %s: %w", f.Name, closeErr)
+	}
+
+	normalized, err := normalizer.NormalizeBytes(data, opts.WithFile(f.Name))
+	if err != nil {
+		return &fileError{Filename: f.Name, Err: err}
+	}
+
+	header := f.FileHeader
+	header.CRC32 = 0
+	header.CompressedSize64 = 0
+	header.UncompressedSize64 = 0
+	ew, err := zw.CreateHeader(&header)
+	if err != nil {
+		return fmt.Errorf("failed to write zip entry %s: %w", f.Name, err)
+	}
+	if _, err := ew.Write(normalized); err != nil {
+		return fmt.Errorf("failed to write zip entry %s: %w", f.Name, err)
+	}
+	return nil
+}
+
+// sameFile reports whether a and b name the same existing file.
+func sameFile(a, b string) bool {
+	aInfo, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	bInfo, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(aInfo, bInfo)
+}
@@ -0,0 +1,76 @@
+package normalizer
+
+import (
+	"bytes"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// alignValues pads the encoded YAML document in data so that, within each
+// block mapping, values that are on the same line as their key all start in
+// the same column. Extra spaces after a mapping colon are insignificant, so
+// the document's contents are unchanged.
+func alignValues(data []byte) ([]byte, error) {
+	var node yaml.Node
+	if err := yaml.Unmarshal(data, &node); err != nil {
+		return nil, err
+	}
+
+	padding := make(map[int]alignment)
+	collectPadding(&node, padding)
+	if len(padding) == 0 {
+		return data, nil
+	}
+
+	lines := strings.SplitAfter(string(data), "\n")
+	var out bytes.Buffer
+	out.Grow(len(data))
+	for i, line := range lines {
+		pad, ok := padding[i+1]
+		if !ok {
+			out.WriteString(line)
+			continue
+		}
+		runes := []rune(line)
+		column := pad.column - 1
+		out.WriteString(string(runes[:column]))
+		out.WriteString(strings.Repeat(" ", pad.width))
+		out.WriteString(string(runes[column:]))
+	}
+
+	return out.Bytes(), nil
+}
+
+// alignment is the padding to insert before the value starting at column (as
+// counted by the YAML decoder, in runes from 1) on a line.
+type alignment struct {
+	column int
+	width  int
+}
+
+// collectPadding records the padding needed for each line in node's block
+// mappings, keyed by line number.
+func collectPadding(node *yaml.Node, padding map[int]alignment) {
+	for _, child := range node.Content {
+		collectPadding(child, padding)
+	}
+
+	if node.Kind != yaml.MappingNode || node.Style&yaml.FlowStyle != 0 {
+		return
+	}
+
+	target := 0
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if key.Line == value.Line {
+			target = max(target, value.Column)
+		}
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if key.Line == value.Line && value.Column < target {
+			padding[value.Line] = alignment{column: value.Column, width: target - value.Column}
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package normalizer
+
+import (
+	"fmt"
+	"regexp"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// checkDuplicateAnchors returns an error if an anchor name is defined more
+// than once in the document. Redefining an anchor is legal YAML, but later
+// aliases silently refer to the last definition, which is rarely intended.
+func checkDuplicateAnchors(doc *yaml.Node) error {
+	anchors := make(map[string]*yaml.Node)
+
+	var walk func(node *yaml.Node) error
+	walk = func(node *yaml.Node) error {
+		if node.Anchor != "" {
+			if prev, ok := anchors[node.Anchor]; ok {
+				return fmt.Errorf("anchor %q defined at line %d, column %d is redefined at line %d, column %d",
+					node.Anchor, prev.Line, prev.Column, node.Line, node.Column)
+			}
+			anchors[node.Anchor] = node
+		}
+		for _, child := range node.Content {
+			if err := walk(child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return walk(doc)
+}
+
+// checkAliasScope returns an error if an alias in the document refers to an
+// anchor defined in an earlier document. The decoder allows this, but YAML
+// anchors are scoped to a single document.
+func checkAliasScope(doc *yaml.Node) error {
+	defined := make(map[*yaml.Node]bool)
+
+	var walk func(node *yaml.Node) error
+	walk = func(node *yaml.Node) error {
+		if node.Anchor != "" {
+			defined[node] = true
+		}
+		if node.Kind == yaml.AliasNode && !defined[node.Alias] {
+			return fmt.Errorf("alias *%s at line %d, column %d refers to an anchor in an earlier document",
+				node.Value, node.Line, node.Column)
+		}
+		for _, child := range node.Content {
+			if err := walk(child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return walk(doc)
+}
+
+// unknownAnchor matches the decoder's error for an alias to an anchor that
+// isn't defined.
+var unknownAnchor = regexp.MustCompile(`unknown anchor '(.*)' referenced`)
+
+// decodeError describes an error decoding the document described by where,
+// naming the anchor if an alias refers to one that isn't defined.
+func decodeError(err error, where string) error {
+	if m := unknownAnchor.FindStringSubmatch(err.Error()); m != nil {
+		return fmt.Errorf("failed to decode YAML input in %s: alias *%s refers to an undefined anchor &%s", where, m[1], m[1])
+	}
+	return fmt.Errorf("failed to decode YAML input in %s: %w", where, err)
+}
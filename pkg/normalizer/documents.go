@@ -0,0 +1,290 @@
+package normalizer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// document is the source of a single document in a YAML stream.
+type document struct {
+	source []byte
+	// explicit is set if the source contains a "---" document start marker
+	explicit bool
+	// line is the line in the stream that the source starts on
+	line int
+}
+
+// splitDocuments splits a YAML stream into the source bytes of each
+// document. A document starts at each line beginning with a "---" marker and
+// ends after each line beginning with a "..." marker. Markers cannot appear
+// inside document content at the start of a line, so no parsing is required.
+//
+// Comments before a "---" marker that don't form a document on their own
+// belong to the following document, as they do when decoding the stream.
+func splitDocuments(data []byte) []document {
+	var docs []document
+
+	start, startLine := 0, 1
+	explicit := false
+	for offset, line := 0, 1; offset < len(data); line++ {
+		end := bytes.IndexByte(data[offset:], '\n')
+		if end < 0 {
+			end = len(data)
+		} else {
+			end += offset + 1
+		}
+		text := data[offset:end]
+
+		switch {
+		case isMarkerLine(text, "---"):
+			if offset > start && (explicit || !onlyComments(data[start:offset])) {
+				docs = append(docs, document{source: data[start:offset], explicit: explicit, line: startLine})
+				start, startLine = offset, line
+			}
+			explicit = true
+		case isMarkerLine(text, "..."):
+			docs = append(docs, document{source: data[start:end], explicit: explicit, line: startLine})
+			start, startLine = end, line+1
+			explicit = false
+		}
+
+		offset = end
+	}
+	if start < len(data) {
+		docs = append(docs, document{source: data[start:], explicit: explicit, line: startLine})
+	}
+
+	return docs
+}
+
+// onlyComments reports whether data contains only blank lines and comments.
+func onlyComments(data []byte) bool {
+	for line := range bytes.Lines(data) {
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 && line[0] != '#' {
+			return false
+		}
+	}
+	return true
+}
+
+// splitDocumentsBy splits a YAML stream into documents at each line matching
+// sep, which is removed, as well as at standard document markers.
+func splitDocumentsBy(data []byte, sep *regexp.Regexp) []document {
+	var docs []document
+	appendChunk := func(chunk []byte, line int) {
+		for _, doc := range splitDocuments(chunk) {
+			doc.line += line - 1
+			docs = append(docs, doc)
+		}
+	}
+
+	start, startLine := 0, 1
+	for offset, line := 0, 1; offset < len(data); line++ {
+		end := bytes.IndexByte(data[offset:], '\n')
+		if end < 0 {
+			end = len(data)
+		} else {
+			end += offset + 1
+		}
+
+		if sep.Match(bytes.TrimRight(data[offset:end], "\r\n")) {
+			appendChunk(data[start:offset], startLine)
+			start, startLine = end, line+1
+		}
+
+		offset = end
+	}
+	appendChunk(data[start:], startLine)
+
+	return docs
+}
+
+func isMarkerLine(line []byte, marker string) bool {
+	if !bytes.HasPrefix(line, []byte(marker)) {
+		return false
+	}
+	rest := line[len(marker):]
+	return len(rest) == 0 || rest[0] == ' ' || rest[0] == '\t' || rest[0] == '\r' || rest[0] == '\n'
+}
+
+// sourceContent returns the source of a document that is copied through
+// unchanged, with an end marker added if o.ExplicitEnd is set and it doesn't
+// already end with one.
+func (o Options) sourceContent(doc document) []byte {
+	if !o.ExplicitEnd || endsWithEndMarker(doc.source) {
+		return doc.source
+	}
+	content := slices.Clip(doc.source)
+	if len(content) > 0 && content[len(content)-1] != '\n' {
+		content = append(content, '\n')
+	}
+	return append(content, "...\n"...)
+}
+
+// endsWithEndMarker reports whether the last line of data that isn't blank
+// is a "..." marker.
+func endsWithEndMarker(data []byte) bool {
+	data = bytes.TrimRight(data, " \t\r\n")
+	if i := bytes.LastIndexByte(data, '\n'); i >= 0 {
+		data = data[i+1:]
+	}
+	return isMarkerLine(data, "...")
+}
+
+// isEmptyDocument reports whether a document has no content, or only a null.
+func isEmptyDocument(node *yaml.Node) bool {
+	if node.Kind != yaml.DocumentNode {
+		return false
+	}
+	if len(node.Content) == 0 {
+		return true
+	}
+	root := node.Content[0]
+	return root.Kind == yaml.ScalarNode && root.ShortTag() == "!!null"
+}
+
+// documentKind returns the value of the top-level "kind" key of a document,
+// or the empty string if there is none.
+func documentKind(node *yaml.Node) string {
+	if node.Kind != yaml.DocumentNode || len(node.Content) == 0 {
+		return ""
+	}
+	root := node.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return ""
+	}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		if key.Value == "kind" && value.Kind == yaml.ScalarNode {
+			return value.Value
+		}
+	}
+	return ""
+}
+
+// matchesLabels reports whether the metadata.labels mapping of a document has
+// every label in selector with the same value.
+func matchesLabels(node *yaml.Node, selector map[string]string) bool {
+	if node.Kind != yaml.DocumentNode || len(node.Content) == 0 {
+		return false
+	}
+	labels := mappingValue(mappingValue(node.Content[0], "metadata"), "labels")
+	for key, want := range selector {
+		value := mappingValue(labels, key)
+		if value == nil || value.Kind != yaml.ScalarNode || value.Value != want {
+			return false
+		}
+	}
+	return true
+}
+
+// mappingValue returns the value of key in the mapping node, or nil if node
+// is nil, is not a mapping, or doesn't have key.
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if k := node.Content[i]; k.Kind == yaml.ScalarNode && k.Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// containsKey reports whether any mapping in node has one of the given keys.
+func containsKey(node *yaml.Node, keys []string) bool {
+	if node.Kind == yaml.MappingNode {
+		for i := 0; i < len(node.Content); i += 2 {
+			if key := node.Content[i]; key.Kind == yaml.ScalarNode && slices.Contains(keys, key.Value) {
+				return true
+			}
+		}
+	}
+	for _, child := range node.Content {
+		if containsKey(child, keys) {
+			return true
+		}
+	}
+	return false
+}
+
+// offsetLines shifts the line numbers of node and all of its descendants by
+// offset, so that positions in a document decoded on its own are relative to
+// the whole stream.
+func offsetLines(node *yaml.Node, offset int) {
+	node.Line += offset
+	for _, child := range node.Content {
+		offsetLines(child, offset)
+	}
+}
+
+// cloneNode returns a deep copy of node. Aliases still point to the original
+// anchored nodes.
+func cloneNode(node *yaml.Node) *yaml.Node {
+	clone := *node
+	if node.Content != nil {
+		clone.Content = make([]*yaml.Node, len(node.Content))
+		for i, child := range node.Content {
+			clone.Content[i] = cloneNode(child)
+		}
+	}
+	return &clone
+}
+
+// sameContent reports whether two nodes have the same content, tags,
+// anchors, and comments, in the same order, ignoring style and position.
+func sameContent(a, b *yaml.Node) bool {
+	if a.Kind != b.Kind || a.Tag != b.Tag || a.Value != b.Value || a.Anchor != b.Anchor ||
+		a.HeadComment != b.HeadComment || a.LineComment != b.LineComment || a.FootComment != b.FootComment ||
+		len(a.Content) != len(b.Content) {
+		return false
+	}
+	for i := range a.Content {
+		if !sameContent(a.Content[i], b.Content[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkTrailingContent returns an error if doc has content after its value
+// that the decoder would otherwise drop or silently join onto it. node is the
+// first document decoded from doc's source, and dec is the decoder it was
+// decoded with.
+func checkTrailingContent(doc document, node *yaml.Node, dec *yaml.Decoder) error {
+	// Anything after the value that isn't a comment or a marker would
+	// otherwise be dropped, since the source is only decoded once
+	var next yaml.Node
+	if err := dec.Decode(&next); !errors.Is(err, io.EOF) {
+		return fmt.Errorf("document starting at line %d has unexpected content after its value; separate documents with \"---\"", doc.line)
+	}
+
+	// A plain scalar continues onto any following lines that aren't
+	// comments, so that "foo\nbar" is read as "foo bar"
+	if len(node.Content) == 0 {
+		return nil
+	}
+	root := node.Content[0]
+	if root.Kind != yaml.ScalarNode || root.Style != 0 {
+		return nil
+	}
+	line := doc.line
+	for text := range bytes.Lines(doc.source) {
+		if line > root.Line {
+			trimmed := bytes.TrimSpace(text)
+			if len(trimmed) > 0 && trimmed[0] != '#' && !isMarkerLine(text, "...") {
+				return fmt.Errorf("plain scalar at line %d continues onto line %d; quote it, or separate documents with \"---\"", root.Line, line)
+			}
+		}
+		line++
+	}
+	return nil
+}
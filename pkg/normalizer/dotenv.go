@@ -0,0 +1,87 @@
+package normalizer
+
+import (
+	"slices"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// normalizeDotenv sorts the KEY=value lines of the dotenv content held in the
+// string scalar node by key and replaces its value with the result as a
+// literal block scalar.
+func normalizeDotenv(node *yaml.Node) {
+	node.Value = sortDotenv(node.Value)
+	node.Style = yaml.LiteralStyle
+}
+
+// dotenvEntry is a single assignment in dotenv content, along with the
+// comment lines directly above it.
+type dotenvEntry struct {
+	key   string
+	lines []string
+}
+
+// sortDotenv sorts dotenv lines by key. When a key is assigned more than once
+// only the last assignment is kept, as that is the one that takes effect.
+// Comments stay with the assignment that follows them, and blank lines are
+// dropped.
+func sortDotenv(value string) string {
+	var entries []dotenvEntry
+	var comments []string
+	for line := range strings.Lines(value) {
+		line = strings.TrimRight(line, "\r\n")
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "":
+			continue
+		case strings.HasPrefix(trimmed, "#"):
+			comments = append(comments, line)
+			continue
+		}
+
+		entries = append(entries, dotenvEntry{
+			key:   dotenvKey(trimmed),
+			lines: append(comments, line),
+		})
+		comments = nil
+	}
+
+	// Keep the last assignment to each key
+	last := make(map[string]int, len(entries))
+	for i, entry := range entries {
+		last[entry.key] = i
+	}
+	var deduped []dotenvEntry
+	for i, entry := range entries {
+		if last[entry.key] == i {
+			deduped = append(deduped, entry)
+		}
+	}
+
+	slices.SortStableFunc(deduped, func(a, b dotenvEntry) int {
+		return stringNaturalCmp(a.key, b.key)
+	})
+
+	var b strings.Builder
+	for _, entry := range deduped {
+		for _, line := range entry.lines {
+			b.WriteString(line)
+			b.WriteByte('\n')
+		}
+	}
+	// Trailing comments stay at the end
+	for _, line := range comments {
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// dotenvKey returns the name assigned by a dotenv line, ignoring any leading
+// "export".
+func dotenvKey(line string) string {
+	line = strings.TrimPrefix(line, "export ")
+	key, _, _ := strings.Cut(line, "=")
+	return strings.TrimSpace(key)
+}
@@ -0,0 +1,31 @@
+package normalizer
+
+import (
+	"fmt"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// checkDuplicateKeys returns an error if a mapping has the same scalar key
+// more than once. The decoder keeps both pairs, and sorting would then place
+// them next to each other, hiding which one consumers will actually use.
+// Merge keys may be repeated, and other non-scalar keys are not compared.
+func checkDuplicateKeys(node *yaml.Node) error {
+	seen := make(map[string]*yaml.Node, len(node.Content)/2)
+	for i := 0; i < len(node.Content); i += 2 {
+		key := node.Content[i]
+		if key.Tag == "!!merge" {
+			continue
+		}
+		id, ok := mergeKeyID(key)
+		if !ok {
+			continue
+		}
+		if prev, ok := seen[id]; ok {
+			return fmt.Errorf("duplicate key %q at line %d, column %d, first defined at line %d, column %d",
+				key.Value, key.Line, key.Column, prev.Line, prev.Column)
+		}
+		seen[id] = key
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package normalizer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+)
+
+var errNotEmbeddedYAML = errors.New("value is not a YAML mapping or sequence")
+
+// normalizeEmbedded normalizes the YAML held in the string scalar node and
+// replaces its value with the result as a literal block scalar. Values that
+// are not YAML mappings or sequences are left alone unless opts.EmbeddedStrict
+// is set.
+func normalizeEmbedded(node *yaml.Node, path []string, opts Options) error {
+	out, err := normalizeEmbeddedYAML(node.Value, opts)
+	if err != nil {
+		if opts.EmbeddedStrict {
+			return fmt.Errorf("failed to normalize embedded YAML at %s: %w", formatPath(path), err)
+		}
+		return nil
+	}
+
+	node.Value = out
+	node.Style = yaml.LiteralStyle
+	return nil
+}
+
+func normalizeEmbeddedYAML(value string, opts Options) (string, error) {
+	opts.EmbeddedPaths = nil
+	opts.Format = FormatYAML
+	opts.ExplicitStart = false
+	opts.ExplicitEnd = false
+
+	dec := yaml.NewDecoder(strings.NewReader(value))
+
+	var buf bytes.Buffer
+	wrote := false
+	for {
+		var node yaml.Node
+
+		err := dec.Decode(&node)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+
+		if len(node.Content) == 0 || (node.Content[0].Kind != yaml.MappingNode && node.Content[0].Kind != yaml.SequenceNode) {
+			return "", errNotEmbeddedYAML
+		}
+
+		if err := normalizeDocumentNode(&node, opts); err != nil {
+			return "", err
+		}
+		if err := encodeDocument(&buf, &node, !wrote, opts); err != nil {
+			return "", err
+		}
+		wrote = true
+	}
+	if !wrote {
+		return "", errNotEmbeddedYAML
+	}
+
+	return buf.String(), nil
+}
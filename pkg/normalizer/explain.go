@@ -0,0 +1,87 @@
+package normalizer
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// Change is a single transformation made to a node during normalization.
+type Change struct {
+	// Line is the line of the node in the input.
+	Line int
+	// Path is the dotted path of the node, as matched by path options.
+	Path        string
+	Description string
+}
+
+// Explanation records the changes made during normalization. It is safe for
+// concurrent use.
+type Explanation struct {
+	mu      sync.Mutex
+	changes []Change
+}
+
+// Changes returns the changes recorded so far, ordered by line.
+func (e *Explanation) Changes() []Change {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	changes := slices.Clone(e.changes)
+	slices.SortStableFunc(changes, func(a, b Change) int {
+		return cmp.Compare(a.Line, b.Line)
+	})
+	return changes
+}
+
+// WriteReport writes a line describing each change to w, as YAML comments so
+// that the report can follow normalized output.
+func (e *Explanation) WriteReport(w io.Writer) error {
+	changes := e.Changes()
+	if _, err := fmt.Fprintf(w, "# %d changes:\n", len(changes)); err != nil {
+		return err
+	}
+	for _, change := range changes {
+		if _, err := fmt.Fprintf(w, "#   line %d, %s: %s\n", change.Line, change.Path, change.Description); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *Explanation) record(node *yaml.Node, path []string, description string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.changes = append(e.changes, Change{
+		Line:        node.Line,
+		Path:        formatPath(path),
+		Description: description,
+	})
+}
+
+// styleName describes the style of a node, ignoring whether it has an
+// explicit tag.
+func styleName(style yaml.Style) string {
+	var names []string
+	for _, s := range []struct {
+		style yaml.Style
+		name  string
+	}{
+		{yaml.DoubleQuotedStyle, "double-quoted"},
+		{yaml.SingleQuotedStyle, "single-quoted"},
+		{yaml.LiteralStyle, "literal"},
+		{yaml.FoldedStyle, "folded"},
+		{yaml.FlowStyle, "flow"},
+	} {
+		if style&s.style != 0 {
+			names = append(names, s.name)
+		}
+	}
+	return strings.Join(names, " ")
+}
@@ -0,0 +1,169 @@
+package normalizer
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// foldedFlow is a flow collection that is written folded onto several lines
+// by unfoldFlowSets. The encoder writes flow collections on a single line,
+// however long, so while the document is encoded, the node is replaced by a
+// placeholder, which is then replaced by the folded text.
+type foldedFlow struct {
+	node  *yaml.Node
+	saved yaml.Node
+	token []byte
+	// start is the text before the first item, such as "!!set {"
+	start string
+	// items are the encoded pairs of the collection, such as "a: null"
+	items []string
+}
+
+// foldFlowSets replaces the flow !!set mappings in node, as written by
+// FlowSets, with placeholders, so that unfoldFlowSets can write them folded
+// after encoding. Sets with comments, or with keys or values that aren't
+// scalars, are left to the encoder.
+func foldFlowSets(node *yaml.Node) ([]*foldedFlow, error) {
+	prefix := "norml_folded"
+	for containsText(node, prefix) {
+		prefix += "_"
+	}
+
+	var sets []*foldedFlow
+	seen := make(map[*yaml.Node]bool)
+	var walk func(node *yaml.Node) error
+	walk = func(node *yaml.Node) error {
+		if seen[node] {
+			return nil
+		}
+		seen[node] = true
+
+		if !isFoldableSet(node) {
+			for _, child := range node.Content {
+				if err := walk(child); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		f := &foldedFlow{
+			node:  node,
+			saved: *node,
+			token: fmt.Appendf(nil, "%s_%d_", prefix, len(sets)),
+			start: node.Tag + " {",
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			item, err := encodeFlowPair(node.Content[i], node.Content[i+1])
+			if err != nil {
+				return err
+			}
+			f.items = append(f.items, item)
+		}
+		*node = yaml.Node{
+			Kind:        yaml.ScalarNode,
+			Tag:         "!!str",
+			Value:       string(f.token),
+			HeadComment: node.HeadComment,
+			LineComment: node.LineComment,
+			FootComment: node.FootComment,
+		}
+		sets = append(sets, f)
+		return nil
+	}
+	if err := walk(node); err != nil {
+		for _, f := range sets {
+			*f.node = f.saved
+		}
+		return nil, err
+	}
+	return sets, nil
+}
+
+// unfoldFlowSets replaces the placeholders of sets in data with the folded
+// text of each set, and restores the original nodes. Each line is kept to
+// width characters where possible, with continuation lines indented by
+// indent past the key of the set.
+func unfoldFlowSets(data []byte, sets []*foldedFlow, width, indent int) []byte {
+	for _, f := range sets {
+		*f.node = f.saved
+		data = replaceToken(data, f.token, func(prefix []byte) []byte {
+			continuation := "\n" + strings.Repeat(" ", keyIndent(prefix)+indent)
+
+			var b strings.Builder
+			b.WriteString(f.start)
+			column := utf8.RuneCount(prefix) + utf8.RuneCountInString(f.start)
+			for i, item := range f.items {
+				length := utf8.RuneCountInString(item)
+				if i > 0 {
+					// Room for the separator, and the "," or "}" that follows
+					if column+2+length+1 > width {
+						b.WriteString("," + continuation)
+						column = len(continuation) - 1
+					} else {
+						b.WriteString(", ")
+						column += 2
+					}
+				}
+				b.WriteString(item)
+				column += length
+			}
+			b.WriteString("}")
+			return []byte(b.String())
+		})
+	}
+	return data
+}
+
+// isFoldableSet reports whether node is a non-empty flow !!set mapping of
+// scalars without comments, which can be encoded one pair at a time. Sets
+// with anchors are left to the encoder, which needs them to write aliases to
+// the set.
+func isFoldableSet(node *yaml.Node) bool {
+	if node.Kind != yaml.MappingNode || node.Tag != "!!set" || node.Style&yaml.FlowStyle == 0 || node.Anchor != "" || len(node.Content) == 0 {
+		return false
+	}
+	for _, child := range node.Content {
+		if child.Kind != yaml.ScalarNode {
+			return false
+		}
+		if child.HeadComment != "" || child.LineComment != "" || child.FootComment != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// encodeFlowPair encodes a key and value as they would be written within a
+// flow mapping.
+func encodeFlowPair(key, value *yaml.Node) (string, error) {
+	out, err := yaml.Marshal(&yaml.Node{
+		Kind:    yaml.MappingNode,
+		Style:   yaml.FlowStyle,
+		Content: []*yaml.Node{key, value},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode set item: %w", err)
+	}
+	out = bytes.TrimSuffix(out, []byte("\n"))
+	out = bytes.TrimPrefix(out, []byte("{"))
+	out = bytes.TrimSuffix(out, []byte("}"))
+	return string(out), nil
+}
+
+// containsText reports whether any scalar value or anchor in node contains s.
+func containsText(node *yaml.Node, s string) bool {
+	if strings.Contains(node.Value, s) || strings.Contains(node.Anchor, s) {
+		return true
+	}
+	for _, child := range node.Content {
+		if containsText(child, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,74 @@
+package normalizer
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// readHeader reads the file header from the start of r, if there is one. The
+// header is the first block of comment lines, optionally after blank lines,
+// that is followed by a blank line, a "---" marker, or the end of the input,
+// so that it isn't the comment of the first key. It returns the header and a
+// reader for the input with the header's lines left blank, so that line
+// numbers in errors are unchanged.
+func readHeader(r *bufio.Reader) (header []byte, rest io.Reader, err error) {
+	var consumed [][]byte
+	// The header is made of the n lines of consumed from start
+	start, n := 0, 0
+	found := false
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, nil, fmt.Errorf("failed to read YAML input: %w", err)
+		}
+		if len(line) > 0 {
+			consumed = append(consumed, line)
+			blank := len(bytes.TrimSpace(line)) == 0
+			if line[0] == '#' {
+				if n == 0 {
+					start = len(consumed) - 1
+				}
+				n++
+			} else if !blank || n > 0 {
+				found = n > 0 && (blank || isMarkerLine(line, "---"))
+				break
+			}
+		}
+		if err == io.EOF {
+			found = n > 0
+			break
+		}
+	}
+
+	var prefix bytes.Buffer
+	for i, line := range consumed {
+		if found && i >= start && i < start+n {
+			header = append(header, bytes.TrimRight(line, "\r\n")...)
+			header = append(header, '\n')
+			prefix.WriteByte('\n')
+			continue
+		}
+		prefix.Write(line)
+	}
+	return header, io.MultiReader(&prefix, r), nil
+}
+
+// headerWriter writes a file header, followed by a blank line, before the
+// first write to w.
+type headerWriter struct {
+	w      io.Writer
+	header []byte
+	wrote  bool
+}
+
+func (h *headerWriter) Write(p []byte) (int, error) {
+	if !h.wrote {
+		h.wrote = true
+		if _, err := h.w.Write(append(h.header, '\n')); err != nil {
+			return 0, fmt.Errorf("failed to write file header: %w", err)
+		}
+	}
+	return h.w.Write(p)
+}
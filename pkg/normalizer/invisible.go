@@ -0,0 +1,71 @@
+package normalizer
+
+import (
+	"fmt"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// invisibleChars are the characters removed or replaced by cleanInvisible,
+// with a replacement of "" for characters that are removed. Zero-width
+// joiners and non-joiners are kept, since they are meaningful in emoji
+// sequences and some scripts.
+var invisibleChars = []struct {
+	char        rune
+	name        string
+	replacement string
+}{
+	{'\u200B', "zero-width space", ""},
+	{'\u2060', "word joiner", ""},
+	{'\uFEFF', "zero-width no-break space", ""},
+	{'\u00A0', "no-break space", " "},
+	{'\u2007', "figure space", " "},
+	{'\u202F', "narrow no-break space", " "},
+}
+
+// cleanInvisible removes zero-width characters from, and replaces
+// non-breaking spaces with spaces in, every string in node, including keys,
+// warning about each string that is changed.
+func cleanInvisible(node *yaml.Node, opts Options) error {
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!str" {
+		var removed, replaced []string
+		value := node.Value
+		for _, c := range invisibleChars {
+			if !strings.ContainsRune(value, c.char) {
+				continue
+			}
+			name := fmt.Sprintf("%s (%U)", c.name, c.char)
+			if c.replacement == "" {
+				removed = append(removed, name)
+			} else {
+				replaced = append(replaced, name)
+			}
+			value = strings.ReplaceAll(value, string(c.char), c.replacement)
+		}
+		if len(removed) > 0 || len(replaced) > 0 {
+			var changes []string
+			if len(removed) > 0 {
+				changes = append(changes, "removed "+strings.Join(removed, ", "))
+			}
+			if len(replaced) > 0 {
+				changes = append(changes, "replaced "+strings.Join(replaced, ", ")+" with a space")
+			}
+			err := opts.warn(Warning{
+				Line:    node.Line,
+				Message: fmt.Sprintf("%s in %q", strings.Join(changes, " and "), node.Value),
+			})
+			if err != nil {
+				return err
+			}
+			node.Value = value
+		}
+	}
+
+	for _, child := range node.Content {
+		if err := cleanInvisible(child, opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+package normalizer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// Format is the output format of normalized documents.
+type Format int
+
+const (
+	// FormatYAML writes documents as YAML, separated by "---".
+	FormatYAML Format = iota
+	// FormatJSONLines writes each document as a JSON value on its own line,
+	// with mapping keys in normalized order.
+	FormatJSONLines
+)
+
+// appendJSON appends the JSON encoding of node to b. Unlike decoding the node
+// and marshaling the result, this keeps mapping keys in the order they
+// appear in the node.
+func appendJSON(b []byte, node *yaml.Node) ([]byte, error) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) == 0 {
+			return append(b, "null"...), nil
+		}
+		return appendJSON(b, node.Content[0])
+	case yaml.AliasNode:
+		return appendJSON(b, node.Alias)
+	case yaml.SequenceNode:
+		b = append(b, '[')
+		for i, child := range node.Content {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			var err error
+			if b, err = appendJSON(b, child); err != nil {
+				return nil, err
+			}
+		}
+		return append(b, ']'), nil
+	case yaml.MappingNode:
+		b = append(b, '{')
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			key, err := jsonKey(node.Content[i])
+			if err != nil {
+				return nil, err
+			}
+			if b, err = appendJSONValue(b, key); err != nil {
+				return nil, err
+			}
+			b = append(b, ':')
+			if b, err = appendJSON(b, node.Content[i+1]); err != nil {
+				return nil, err
+			}
+		}
+		return append(b, '}'), nil
+	default:
+		var value any
+		if err := node.Decode(&value); err != nil {
+			return nil, err
+		}
+		b, err := appendJSONValue(b, value)
+		if err != nil {
+			return nil, fmt.Errorf("value at line %d, column %d cannot be converted to JSON: %w", node.Line, node.Column, err)
+		}
+		return b, nil
+	}
+}
+
+// jsonKey returns the JSON object key for a mapping key.
+func jsonKey(node *yaml.Node) (string, error) {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	if node.Kind != yaml.ScalarNode {
+		return "", fmt.Errorf("mapping key at line %d, column %d cannot be converted to JSON", node.Line, node.Column)
+	}
+	if node.Tag == "!!str" {
+		return node.Value, nil
+	}
+
+	var value any
+	if err := node.Decode(&value); err != nil {
+		return "", err
+	}
+	if value == nil {
+		return "null", nil
+	}
+	return fmt.Sprint(value), nil
+}
+
+// appendJSONValue appends the JSON encoding of a scalar value to b, without
+// escaping HTML characters.
+func appendJSONValue(b []byte, value any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(value); err != nil {
+		return nil, err
+	}
+	return append(b, bytes.TrimSuffix(buf.Bytes(), []byte("\n"))...), nil
+}
+
+// jsonToYAML reads a stream of JSON values from r and returns them as a
+// stream of YAML documents. Values are converted token by token rather than
+// decoded, so that object keys keep their order and numbers are written
+// exactly as they appear, however large or precise.
+func jsonToYAML(r io.Reader) ([]byte, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	wrote := false
+	for {
+		node, err := jsonNode(dec)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode JSON input at offset %d: %w", dec.InputOffset(), err)
+		}
+		doc := &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{node}}
+		if err := enc.Encode(doc); err != nil {
+			return nil, fmt.Errorf("failed to convert JSON input: %w", err)
+		}
+		wrote = true
+	}
+	if !wrote {
+		// Closing the encoder without encoding anything fails
+		return nil, nil
+	}
+	if err := enc.Close(); err != nil {
+		return nil, fmt.Errorf("failed to convert JSON input: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// jsonNode reads the next JSON value from dec as a node.
+func jsonNode(dec *json.Decoder) (*yaml.Node, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch tok := tok.(type) {
+	case json.Delim:
+		node := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		if tok == '{' {
+			node = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		}
+		for dec.More() {
+			if node.Kind == yaml.MappingNode {
+				key, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key.(string)})
+			}
+			child, err := jsonNode(dec)
+			if err != nil {
+				return nil, unexpectedEOF(err)
+			}
+			node.Content = append(node.Content, child)
+		}
+		// Consume the closing delimiter
+		if _, err := dec.Token(); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		return node, nil
+	case string:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: tok}, nil
+	case json.Number:
+		// Leave the tag to be resolved from the value, so that the number is
+		// written as is rather than with an explicit tag when it is too large
+		// for the decoder's integer or float types
+		return &yaml.Node{Kind: yaml.ScalarNode, Value: tok.String()}, nil
+	case bool:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: fmt.Sprint(tok)}, nil
+	case nil:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"}, nil
+	default:
+		return nil, fmt.Errorf("unexpected JSON token %v", tok)
+	}
+}
+
+// unexpectedEOF reports the end of the input within a value as an error,
+// rather than as the end of the stream.
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,61 @@
+package normalizer
+
+import (
+	"fmt"
+	"io"
+	"slices"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// KeyPaths returns the path to every leaf value in the YAML stream read from
+// r, in normalized order. Mapping values are named by their key and sequence
+// items by their index, so a container image might be at
+// ["spec", "containers", "0", "image"]. Empty mappings and sequences are
+// leaves. For multi-document streams, the paths of each document are
+// returned in turn.
+func KeyPaths(r io.Reader) ([][]string, error) {
+	dec := yaml.NewDecoder(r)
+
+	var paths [][]string
+	for {
+		var node yaml.Node
+
+		err := dec.Decode(&node)
+		if err == io.EOF {
+			return paths, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode YAML input: %w", err)
+		}
+
+		if err := normalizeNode(&node, nil, Options{}); err != nil {
+			return nil, fmt.Errorf("failed to normalize YAML node: %w", err)
+		}
+
+		paths = appendLeafPaths(paths, &node, []string{})
+	}
+}
+
+func appendLeafPaths(paths [][]string, node *yaml.Node, path []string) [][]string {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			paths = appendLeafPaths(paths, child, path)
+		}
+		return paths
+	case yaml.MappingNode, yaml.SequenceNode:
+		if len(node.Content) == 0 {
+			return append(paths, slices.Clone(path))
+		}
+		for i, child := range node.Content {
+			if node.Kind == yaml.MappingNode && i%2 == 0 {
+				continue
+			}
+			paths = appendLeafPaths(paths, child, childPath(node, path, i))
+		}
+		return paths
+	default:
+		return append(paths, slices.Clone(path))
+	}
+}
@@ -0,0 +1,220 @@
+package normalizer
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// expandMerges replaces the merge keys in every mapping in node with the
+// pairs they merge in, then removes anchors that are no longer referenced.
+func expandMerges(node *yaml.Node) error {
+	if err := expandMergesIn(node); err != nil {
+		return err
+	}
+
+	referenced := make(map[*yaml.Node]bool)
+	collectAliased(node, referenced)
+	removeAnchors(node, referenced)
+	return nil
+}
+
+func expandMergesIn(node *yaml.Node) error {
+	for _, child := range node.Content {
+		if err := expandMergesIn(child); err != nil {
+			return err
+		}
+	}
+	if node.Kind == yaml.MappingNode {
+		return expandMapping(node)
+	}
+	return nil
+}
+
+// expandMapping replaces the merge keys of a mapping with the pairs of the
+// mappings they refer to. Keys of the mapping itself override merged keys,
+// and keys from earlier mappings in a sequence of merge sources override
+// those from later ones, as when decoding.
+func expandMapping(node *yaml.Node) error {
+	var sources []*yaml.Node
+	content := make([]*yaml.Node, 0, len(node.Content))
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if !isMergeKey(key) {
+			content = append(content, key, value)
+			continue
+		}
+
+		if value.Kind == yaml.SequenceNode {
+			for _, item := range value.Content {
+				source, err := mergeSource(item)
+				if err != nil {
+					return err
+				}
+				sources = append(sources, source)
+			}
+		} else {
+			source, err := mergeSource(value)
+			if err != nil {
+				return err
+			}
+			sources = append(sources, source)
+		}
+	}
+	if len(sources) == 0 {
+		return nil
+	}
+
+	seen := make(map[string]bool, len(content)/2)
+	for i := 0; i < len(content); i += 2 {
+		if id, ok := mergeKeyID(content[i]); ok {
+			seen[id] = true
+		}
+	}
+
+	for _, source := range sources {
+		// Expand a copy, so that the source keeps its own merge keys if it
+		// is referenced elsewhere
+		merged := cloneNode(source)
+		if err := expandMergesIn(merged); err != nil {
+			return err
+		}
+		for i := 0; i+1 < len(merged.Content); i += 2 {
+			key, value := merged.Content[i], merged.Content[i+1]
+			if id, ok := mergeKeyID(key); ok {
+				if seen[id] {
+					continue
+				}
+				seen[id] = true
+			}
+			// The original nodes keep any anchors defined on them
+			clearAnchors(key)
+			clearAnchors(value)
+			content = append(content, key, value)
+		}
+	}
+
+	node.Content = content
+	return nil
+}
+
+// mergeSource returns the mapping that a merge key's value refers to.
+func mergeSource(value *yaml.Node) (*yaml.Node, error) {
+	source := value
+	if source.Kind == yaml.AliasNode {
+		source = source.Alias
+	}
+	if source.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("line %d: merge key value must be a mapping or a sequence of mappings", value.Line)
+	}
+	return source, nil
+}
+
+// mergeKeyID identifies a scalar key for deciding which of two merged keys
+// takes precedence. Other keys can't be compared, and are always kept.
+func mergeKeyID(key *yaml.Node) (string, bool) {
+	if key.Kind != yaml.ScalarNode {
+		return "", false
+	}
+	return key.ShortTag() + " " + key.Value, true
+}
+
+// collectAliased records the nodes that aliases in node refer to.
+func collectAliased(node *yaml.Node, referenced map[*yaml.Node]bool) {
+	if node.Kind == yaml.AliasNode {
+		referenced[node.Alias] = true
+	}
+	for _, child := range node.Content {
+		collectAliased(child, referenced)
+	}
+}
+
+// removeAnchors removes the anchors from the nodes in node that aren't
+// referenced.
+func removeAnchors(node *yaml.Node, referenced map[*yaml.Node]bool) {
+	if !referenced[node] {
+		node.Anchor = ""
+	}
+	for _, child := range node.Content {
+		removeAnchors(child, referenced)
+	}
+}
+
+func clearAnchors(node *yaml.Node) {
+	removeAnchors(node, nil)
+}
+
+// mergedValue is the decoded value of a mapping with merge keys.
+type mergedValue struct {
+	node  *yaml.Node
+	value any
+}
+
+// collectMergedValues decodes every mapping in node with merge keys, so that
+// checkMergedValues can confirm that normalizing node didn't change what they
+// merge in.
+func collectMergedValues(node *yaml.Node) ([]mergedValue, error) {
+	var values []mergedValue
+	var collect func(node *yaml.Node) error
+	collect = func(node *yaml.Node) error {
+		for _, child := range node.Content {
+			if err := collect(child); err != nil {
+				return err
+			}
+		}
+		if node.Kind != yaml.MappingNode || !hasMergeKey(node) {
+			return nil
+		}
+		var value any
+		if err := node.Decode(&value); err != nil {
+			return fmt.Errorf("failed to decode mapping with merge keys at line %d: %w", node.Line, err)
+		}
+		values = append(values, mergedValue{node: node, value: value})
+		return nil
+	}
+	if err := collect(node); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// checkMergedValues decodes the mappings in values again, and returns an error
+// if any no longer decodes to the same value.
+func checkMergedValues(values []mergedValue) error {
+	for _, v := range values {
+		var value any
+		if err := v.node.Decode(&value); err != nil {
+			return fmt.Errorf("failed to decode normalized mapping with merge keys at line %d: %w", v.node.Line, err)
+		}
+		if !reflect.DeepEqual(v.value, value) {
+			return fmt.Errorf("normalization changed the merged value of the mapping at line %d", v.node.Line)
+		}
+	}
+	return nil
+}
+
+func hasMergeKey(node *yaml.Node) bool {
+	for i := 0; i < len(node.Content); i += 2 {
+		if isMergeKey(node.Content[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsMergeKey reports whether any mapping in node has a merge key.
+func containsMergeKey(node *yaml.Node) bool {
+	if node.Kind == yaml.MappingNode && hasMergeKey(node) {
+		return true
+	}
+	return slices.ContainsFunc(node.Content, containsMergeKey)
+}
+
+// isMergeKey reports whether key is a merge key. Normalizing clears the
+// !!merge tag of merge keys, leaving a plain <<, which is still decoded as a
+// merge key.
+func isMergeKey(key *yaml.Node) bool {
+	return key.Kind == yaml.ScalarNode && (key.Tag == "!!merge" || key.Tag == "" && key.Style == 0 && key.Value == "<<")
+}
@@ -3,51 +3,724 @@ package normalizer
 import (
 	"bufio"
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
 
 	"go.yaml.in/yaml/v3"
+	"golang.org/x/sync/errgroup"
 )
 
-func normalizeNode(node *yaml.Node, preserveComments bool) error {
+// Options controls how documents are normalized. The zero value strips
+// comments and performs no additional checks.
+type Options struct {
+	// PreserveComments keeps head, line, and foot comments on nodes.
+	PreserveComments bool
+	// VerifyEqual decodes both the input and the normalized output and
+	// returns an error if any document's decoded value differs.
+	VerifyEqual bool
+	// OnlyKinds, if non-empty, limits normalization to documents whose
+	// top-level "kind" is in the list. Other documents are copied through
+	// unchanged.
+	OnlyKinds []string
+	// AlignValues pads the keys of each block mapping so that values on the
+	// same line as their key start in the same column.
+	AlignValues bool
+	// EmbeddedPaths lists dotted paths (e.g. "data.*") of string values that
+	// hold YAML, which is normalized and re-embedded as a literal block.
+	EmbeddedPaths []string
+	// EmbeddedStrict makes values under EmbeddedPaths that are not YAML
+	// mappings or sequences an error instead of leaving them unchanged.
+	EmbeddedStrict bool
+	// TypeStats, if set, records the types of all nodes in normalized
+	// documents.
+	TypeStats *TypeStats
+	// Atomic buffers the entire output and only writes it once every
+	// document has been normalized successfully.
+	Atomic bool
+	// TrimScalars strips leading and trailing whitespace from string values
+	// (not keys). Literal and folded block scalars are left unchanged.
+	TrimScalars bool
+	// DocumentWorkers is the number of documents in a single stream to
+	// normalize in parallel. Parallel normalization reads the whole stream
+	// into memory first.
+	DocumentWorkers int
+	// StrictAnchors makes it an error to define the same anchor name more
+	// than once in a document.
+	StrictAnchors bool
+	// ForceBlockSequences guarantees that non-empty sequences are always
+	// emitted in block style, with one item per line.
+	ForceBlockSequences bool
+	// KeyQuoteStyle, if set to yaml.DoubleQuotedStyle or
+	// yaml.SingleQuotedStyle, is used for all string keys that need quoting.
+	KeyQuoteStyle yaml.Style
+	// TagHandlers maps tags (e.g. "!include") to functions that may modify
+	// nodes with that tag in-place before they are normalized. Nodes with
+	// other tags are left as-is.
+	TagHandlers map[string]func(*yaml.Node) error
+	// SkipUnchanged makes NormalizeFile leave files that are already
+	// normalized untouched, rather than rewriting them with the same content.
+	SkipUnchanged bool
+	// MixedKeyOrder controls the relative order of keys of different types.
+	MixedKeyOrder MixedKeyOrder
+	// DocumentSeparator, if set, splits the input into documents at each line
+	// that it matches, in addition to standard "---" markers. Separator lines
+	// are replaced by "---" in the output.
+	DocumentSeparator *regexp.Regexp
+	// PreserveUnchanged copies documents that normalization would only
+	// restyle, without changing their order or contents, through exactly as
+	// they appear in the input.
+	PreserveUnchanged bool
+	// DotenvPaths lists dotted paths of string values containing dotenv
+	// KEY=value lines to sort and deduplicate. They take precedence over
+	// EmbeddedPaths for values that match both.
+	DotenvPaths []string
+	// MaxLineLength, if positive, produces a warning for each line of output
+	// longer than this many characters.
+	MaxLineLength int
+	// Warn, if set, is called with each warning. It may be called
+	// concurrently when normalizing documents in parallel.
+	Warn func(Warning)
+	// Strict makes warnings errors. Output is only written if there are no
+	// warnings.
+	Strict bool
+	// Format is the output format, YAML by default.
+	Format Format
+	// StrictText makes input that is not valid UTF-8, or that contains
+	// control characters other than tabs and line breaks, an error.
+	StrictText bool
+	// LastKeys lists keys that are placed after all other keys in every
+	// mapping, in the order listed.
+	LastKeys []string
+	// WarnVersionFloats warns about floats written like version numbers, such
+	// as 1.10, whose value differs from how they are written.
+	WarnVersionFloats bool
+	// ReportQuoteInconsistency warns about documents whose string values
+	// are written in a mix of quoting styles.
+	ReportQuoteInconsistency bool
+	// PreserveValueKeys lists keys whose scalar values are written as they
+	// appear in the input rather than in the canonical style. Quoted and
+	// block scalars in block mappings are copied from the input, only
+	// re-indented along with their key. Other values, and values in nodes
+	// passed to NormalizeNode, only keep their quoting or block style.
+	PreserveValueKeys []string
+	// CompactSequenceIndent writes block sequence items at the same
+	// indentation as the key they belong to, rather than indented below it.
+	CompactSequenceIndent bool
+	// Explain, if set, records each change made while normalizing.
+	Explain *Explanation
+	// RequireLF makes input containing carriage returns, such as Windows
+	// line endings, an error.
+	RequireLF bool
+	// AnchorsFirst places merge keys ("<<") first in each mapping, followed
+	// by keys whose key or value defines an anchor, followed by the remaining
+	// keys. Each group is sorted as usual.
+	AnchorsFirst bool
+	// JSONWriter, if set, also receives each normalized document as a line of
+	// JSON, in the same order as the main output.
+	JSONWriter io.Writer
+	// PreserveOrderPaths lists dotted paths of subtrees in which mapping keys
+	// keep their original order. Everything in the subtree is still
+	// normalized otherwise.
+	PreserveOrderPaths []string
+	// IntKeyStyle controls whether integer keys are written bare or quoted.
+	IntKeyStyle IntKeyStyle
+	// Indent is the number of spaces to indent nested collections by, from 2
+	// to 9. If it is 0, the default of 2 is used.
+	Indent int
+	// WarnCaseCollisions warns about keys in the same mapping that differ
+	// only by case, such as Name and name.
+	WarnCaseCollisions bool
+	// KeepKeyOrder leaves the keys of every mapping in their original order.
+	KeepKeyOrder bool
+	// KeyOrder lists keys that are placed before all other keys in the
+	// top-level mapping of each document, in the order listed. Nested
+	// mappings are sorted as usual.
+	KeyOrder []string
+	// SortDescending sorts mapping keys in descending order instead. Numbers
+	// within keys still compare by value, so key10 comes before key2.
+	SortDescending bool
+	// WarnSingleKeyChains, if positive, warns about chains of more than this
+	// many nested mappings that each have a single key.
+	WarnSingleKeyChains int
+	// FlowSets writes !!set mappings in flow style on a single line. The
+	// encoder can't omit the values of a flow mapping, so they are written
+	// explicitly, as in !!set {a: null, b: null}.
+	FlowSets bool
+	// FlowWidth, if positive, folds sets written by FlowSets onto several
+	// lines, still in flow style, so that lines are no longer than this many
+	// characters unless a single item is. Continuation lines are indented
+	// past the key of the set.
+	FlowWidth int
+	// ExpandMerges replaces merge keys ("<<") with the keys they merge in,
+	// and removes anchors that are no longer referenced.
+	ExpandMerges bool
+	// StrictAliases rejects aliases to anchors defined in earlier documents
+	// of the stream, which the decoder otherwise allows. Anchors are never
+	// shared between documents that are decoded separately, such as with
+	// DocumentWorkers or OnlyKinds.
+	StrictAliases bool
+	// EscapeUnicode writes characters outside the Basic Multilingual Plane,
+	// such as emoji, as escape sequences in double-quoted strings, as the
+	// encoder does by default. Otherwise, printable characters are written
+	// literally. Other non-ASCII characters are always written literally.
+	EscapeUnicode bool
+	// StripDocumentTags removes custom tags, such as !Config, from the
+	// top-level node of each document. Standard tags such as !!str are kept,
+	// since removing them could change the document's contents.
+	StripDocumentTags bool
+	// ScopeKeys, if non-empty, limits normalization to documents with at
+	// least one of these keys in any of their mappings. Other documents are
+	// copied through unchanged.
+	ScopeKeys []string
+	// StrictDuplicates rejects mappings with the same key more than once,
+	// which the decoder otherwise allows. Merge keys ("<<") may be repeated.
+	StrictDuplicates bool
+	// FromJSON reads the input as a stream of JSON values rather than YAML.
+	// Line numbers in errors and warnings refer to the values converted to
+	// YAML.
+	FromJSON bool
+	// StrictTrailingContent rejects documents with content after their
+	// value, such as "foo\nbar: baz", rather than reading only part of them,
+	// and top-level plain scalars that continue onto later lines, such as
+	// "foo\nbar", which would otherwise be read as one scalar, "foo bar".
+	StrictTrailingContent bool
+	// UnsortedTags lists tags, such as "!ordered", of mappings whose keys
+	// keep their original order. Their descendants are still sorted.
+	UnsortedTags []string
+	// TreeWriter, if set, receives an indented description of the nodes of
+	// each document as decoded, before it is normalized, for debugging.
+	TreeWriter io.Writer
+	// OrderedMapPaths lists dotted paths of mappings, such as OpenAPI's
+	// "paths", whose keys keep their original order. Unlike
+	// PreserveOrderPaths, mappings nested within them are still sorted.
+	OrderedMapPaths []string
+	// BackupSuffix, if set, makes NormalizeFile copy the original file to
+	// its name with this suffix added, such as ".bak", before replacing it.
+	// The file is left untouched if the backup fails.
+	BackupSuffix string
+	// CleanInvisible removes zero-width spaces from, and replaces
+	// non-breaking spaces with ordinary spaces in, keys and string values,
+	// warning about each one that is changed.
+	CleanInvisible bool
+	// GroupKeysByPrefix sorts keys by the part before their first "." before
+	// the rest of the key, so that keys such as "log.level" and "log.format"
+	// stay together even when other keys share a prefix, such as "log-x".
+	// It applies to mappings whose keys are all strings.
+	GroupKeysByPrefix bool
+	// RejectEmptyOutput fails instead of writing output with no content,
+	// such as when the input only has comments and PreserveComments is not
+	// set, so that normalizing a file in-place can't blank it out. Output
+	// with only empty documents counts as empty. Like Atomic, it buffers
+	// the output in memory.
+	RejectEmptyOutput bool
+	// HoistHeader treats the first block of comment lines of the input as a
+	// header for the whole stream if it is followed by a blank line or a
+	// "---" marker, such as a license shared by every document. The header
+	// is written before all documents, followed by a blank line, rather
+	// than attached to the first one, and is kept even if PreserveComments
+	// is not set. It has no effect with FormatJSONLines.
+	HoistHeader bool
+	// VerifyMerges checks that each mapping with merge keys ("<<") decodes
+	// to the same value, with the merged keys included, after normalizing
+	// as before. Unlike VerifyEqual, it checks each document as it is
+	// normalized. Options that change values, such as TrimScalars, can make
+	// it fail.
+	VerifyMerges bool
+	// DropEmptyDocuments removes documents with no content, or only a
+	// null, such as those between consecutive "---" markers, along with
+	// their separators. A stream of only empty documents produces no
+	// output.
+	DropEmptyDocuments bool
+	// ExplicitStart writes a "---" marker before every document, including
+	// the first, for tools that require one. Documents copied through
+	// unchanged keep their original markers.
+	ExplicitStart bool
+	// IgnoreReadOnly makes NormalizeFile try to replace files without the
+	// owner write permission rather than refusing to, for callers with
+	// their own permission policy. Since files are replaced by renaming a
+	// new file over them, this can succeed if the directory is writable;
+	// otherwise, the error from the operating system is returned.
+	IgnoreReadOnly bool
+	// ExplicitEnd writes a "..." marker after every document, for parsers
+	// that expect documents to be terminated. Documents copied through
+	// unchanged get one too, if they don't already end with one.
+	ExplicitEnd bool
+	// LabelSelector, if non-empty, limits normalization to documents whose
+	// metadata.labels mapping, as in Kubernetes objects, has each of these
+	// labels with the given value. Other documents are copied through
+	// unchanged.
+	LabelSelector map[string]string
+	// file is the name of the file being normalized, if set with WithFile
+	file string
+	// onDocument, if set, is called with the normalized content of each
+	// document written, without the separator before it
+	onDocument func(content []byte)
+}
+
+// validate returns an error if the options can't be used together.
+func (o Options) validate() error {
+	if o.Indent != 0 && (o.Indent < minIndent || o.Indent > maxIndent) {
+		return fmt.Errorf("invalid indent %d: must be between %d and %d", o.Indent, minIndent, maxIndent)
+	}
+	return nil
+}
+
+// needsSource reports whether the options require access to the source bytes
+// of each document.
+func (o Options) needsSource() bool {
+	return len(o.OnlyKinds) > 0 || len(o.ScopeKeys) > 0 || len(o.LabelSelector) > 0 || o.StrictTrailingContent || o.DocumentWorkers > 1 || o.DocumentSeparator != nil || o.PreserveUnchanged || len(o.PreserveValueKeys) > 0
+}
+
+// splitDocuments splits a YAML stream into the source of each document.
+func (o Options) splitDocuments(data []byte) []document {
+	if o.DocumentSeparator != nil {
+		return splitDocumentsBy(data, o.DocumentSeparator)
+	}
+	return splitDocuments(data)
+}
+
+// passThrough reports whether a document should be copied to the output
+// as-is rather than normalized.
+func (o Options) passThrough(node *yaml.Node) bool {
+	if len(o.OnlyKinds) > 0 && !slices.Contains(o.OnlyKinds, documentKind(node)) {
+		return true
+	}
+	if len(o.ScopeKeys) > 0 && !containsKey(node, o.ScopeKeys) {
+		return true
+	}
+	if len(o.LabelSelector) > 0 && !matchesLabels(node, o.LabelSelector) {
+		return true
+	}
+	return false
+}
+
+func normalizeNode(node *yaml.Node, path []string, opts Options) error {
+	// Run tag handlers first so that any content they produce is normalized
+	if handler, ok := opts.TagHandlers[node.Tag]; ok {
+		if err := handler(node); err != nil {
+			return fmt.Errorf("handler for tag %s failed at line %d, column %d: %w", node.Tag, node.Line, node.Column, err)
+		}
+	}
+
+	if opts.TypeStats != nil {
+		opts.TypeStats.record(node)
+	}
+
+	if opts.WarnVersionFloats {
+		if err := checkVersionFloat(node, opts); err != nil {
+			return err
+		}
+	}
+
+	if opts.StrictDuplicates && node.Kind == yaml.MappingNode {
+		if err := checkDuplicateKeys(node); err != nil {
+			return err
+		}
+	}
+
+	if opts.WarnCaseCollisions && node.Kind == yaml.MappingNode {
+		if err := checkCaseCollisions(node, path, opts); err != nil {
+			return err
+		}
+	}
+
+	if opts.Explain != nil {
+		if style := node.Style &^ yaml.TaggedStyle; style != 0 {
+			opts.Explain.record(node, path, "reset "+styleName(style)+" style")
+		}
+		if !opts.PreserveComments && (node.HeadComment != "" || node.LineComment != "" || node.FootComment != "") {
+			opts.Explain.record(node, path, "stripped comment")
+		}
+	}
+
 	// Reset style
 	node.Style = 0
 
 	// Strip comments
-	if !preserveComments {
+	if !opts.PreserveComments {
 		node.HeadComment = ""
 		node.LineComment = ""
 		node.FootComment = ""
 	}
 
-	// Normalize children
-	for _, node := range node.Content {
-		err := normalizeNode(node, preserveComments)
+	// Normalize children, making room for their paths up front so that they
+	// can all share path's backing array
+	if len(node.Content) > 0 {
+		path = slices.Grow(path, 1)
+	}
+	for i, child := range node.Content {
+		isKey := node.Kind == yaml.MappingNode && i%2 == 0
+		preserve := !isKey && node.Kind == yaml.MappingNode && child.Kind == yaml.ScalarNode &&
+			slices.Contains(opts.PreserveValueKeys, node.Content[i-1].Value)
+		if opts.TrimScalars && !isKey && !preserve {
+			trimScalar(child)
+		}
+
+		style := child.Style
+		err := normalizeNode(child, childPath(node, path, i), opts)
 		if err != nil {
 			return err
 		}
+		if preserve {
+			child.Style = style
+		}
+
+		if isKey && opts.KeyQuoteStyle != 0 {
+			if err := quoteKey(child, opts.KeyQuoteStyle); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Normalize embedded YAML and dotenv strings
+	if node.Kind == yaml.MappingNode && (len(opts.EmbeddedPaths) > 0 || len(opts.DotenvPaths) > 0) {
+		for i := 1; i < len(node.Content); i += 2 {
+			value := node.Content[i]
+			if value.Kind != yaml.ScalarNode || value.Tag != "!!str" {
+				continue
+			}
+			valuePath := childPath(node, path, i)
+			// Dotenv paths come first, since they are usually listed to pick
+			// files out of a pattern given for embedded YAML, such as data.*
+			switch {
+			case matchAnyPath(opts.DotenvPaths, valuePath):
+				normalizeDotenv(value)
+			case matchAnyPath(opts.EmbeddedPaths, valuePath):
+				if err := normalizeEmbedded(value, valuePath, opts); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if node.Kind == yaml.MappingNode && !opts.KeepKeyOrder && !matchAnyPathPrefix(opts.PreserveOrderPaths, path) &&
+		!matchAnyPath(opts.OrderedMapPaths, path) && !slices.Contains(opts.UnsortedTags, node.Tag) {
+		var before []*yaml.Node
+		if opts.Explain != nil {
+			before = slices.Clone(node.Content)
+		}
+		if err := sortMapKeys(node.Content, len(path) == 0, opts); err != nil {
+			return err
+		}
+		if opts.Explain != nil && !slices.Equal(before, node.Content) {
+			opts.Explain.record(node, path, "sorted keys")
+		}
 	}
 
+	// The encoder writes merge keys with an explicit !!merge tag unless it is
+	// cleared, and a plain << is decoded as a merge key anyway. Cleared only
+	// after sorting, which orders merge keys by their tag
 	if node.Kind == yaml.MappingNode {
-		content, err := sortMapKeys(node.Content)
-		if err != nil {
+		for i := 0; i < len(node.Content); i += 2 {
+			if key := node.Content[i]; key.Kind == yaml.ScalarNode && key.Tag == "!!merge" {
+				key.Tag = ""
+			}
+		}
+	}
+
+	// Quote integer keys only after sorting, so that they keep their numeric
+	// order
+	if node.Kind == yaml.MappingNode && opts.IntKeyStyle == IntKeysQuoted {
+		quoteIntKeys(node, opts.KeyQuoteStyle)
+	}
+
+	if opts.FlowSets && node.Kind == yaml.MappingNode && node.Tag == "!!set" {
+		flowSet(node)
+	}
+
+	// Applied last so that it overrides any other choice of style
+	if opts.ForceBlockSequences && node.Kind == yaml.SequenceNode {
+		node.Style &^= yaml.FlowStyle
+	}
+
+	return nil
+}
+
+// normalizeDocumentNode checks and normalizes a decoded document.
+func normalizeDocumentNode(node *yaml.Node, opts Options) error {
+	if opts.CleanInvisible {
+		if err := cleanInvisible(node, opts); err != nil {
+			return err
+		}
+	}
+	if opts.ExpandMerges {
+		if err := expandMerges(node); err != nil {
+			return err
+		}
+	}
+	if opts.StrictAnchors {
+		if err := checkDuplicateAnchors(node); err != nil {
+			return err
+		}
+	}
+	if opts.ReportQuoteInconsistency {
+		if err := checkQuoteConsistency(node, opts); err != nil {
+			return err
+		}
+	}
+	if opts.WarnSingleKeyChains > 0 {
+		if err := checkSingleKeyChains(node, nil, opts.WarnSingleKeyChains, opts); err != nil {
+			return err
+		}
+	}
+	var merged []mergedValue
+	if opts.VerifyMerges {
+		var err error
+		if merged, err = collectMergedValues(node); err != nil {
+			return err
+		}
+	}
+	if err := normalizeNode(node, nil, opts); err != nil {
+		return err
+	}
+	if opts.VerifyMerges {
+		if err := checkMergedValues(merged); err != nil {
 			return err
 		}
-		node.Content = content
 	}
 
+	// Stripped only after normalizing, so that tag handlers still see the tag
+	if opts.StripDocumentTags && node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		if root := node.Content[0]; !strings.HasPrefix(root.Tag, "!!") {
+			root.Tag = ""
+		}
+	}
+	return nil
+}
+
+// quoteKey sets the style of a string key to style if the key would be quoted
+// anyway. If the key can't be represented in that style, the encoder falls
+// back to double quotes.
+func quoteKey(node *yaml.Node, style yaml.Style) error {
+	if node.Kind != yaml.ScalarNode || node.Tag != "!!str" {
+		return nil
+	}
+
+	out, err := yaml.Marshal(node)
+	if err != nil {
+		return err
+	}
+	switch out[0] {
+	case '"', '\'', '|', '>':
+		node.Style = style
+	}
 	return nil
 }
 
-func Normalize(r io.Reader, w io.Writer, preserveComments bool) error {
+// flowSet sets a !!set mapping to be written in flow style. Empty null values
+// are written as null, since the encoder would otherwise quote them, turning
+// them into strings.
+func flowSet(node *yaml.Node) {
+	node.Style |= yaml.FlowStyle
+	for i := 1; i < len(node.Content); i += 2 {
+		if value := node.Content[i]; value.Kind == yaml.ScalarNode && value.Tag == "!!null" && value.Value == "" {
+			value.Value = "null"
+		}
+	}
+}
+
+// IntKeyStyle controls how integer mapping keys are written.
+type IntKeyStyle int
+
+const (
+	// IntKeysBare leaves integer keys as they are, so that they are written
+	// bare, as in 1: a. Quoted keys such as "1" are strings, and stay quoted.
+	IntKeysBare IntKeyStyle = iota
+	// IntKeysQuoted turns integer keys into quoted strings, as in "1": a.
+	// This changes the type of the keys, but not their order.
+	IntKeysQuoted
+)
+
+// quoteIntKeys turns the integer keys of a mapping into strings quoted with
+// style, or with double quotes if style is 0.
+func quoteIntKeys(node *yaml.Node, style yaml.Style) {
+	if style == 0 {
+		style = yaml.DoubleQuotedStyle
+	}
+	for i := 0; i < len(node.Content); i += 2 {
+		if key := node.Content[i]; key.Kind == yaml.ScalarNode && key.Tag == "!!int" {
+			key.Tag = "!!str"
+			key.Style = style
+		}
+	}
+}
+
+// trimScalar strips surrounding whitespace from a string scalar, unless it is a
+// block scalar where whitespace is likely intentional.
+func trimScalar(node *yaml.Node) {
+	if node.Kind != yaml.ScalarNode || node.Tag != "!!str" {
+		return
+	}
+	if node.Style&(yaml.LiteralStyle|yaml.FoldedStyle) != 0 {
+		return
+	}
+	node.Value = strings.TrimSpace(node.Value)
+}
+
+func Normalize(r io.Reader, w io.Writer, opts Options) error {
+	if err := opts.validate(); err != nil {
+		return err
+	}
+
+	if opts.StrictText || opts.RequireLF {
+		text := &textReader{r: r, strict: opts.StrictText, requireLF: opts.RequireLF}
+		opts.StrictText, opts.RequireLF = false, false
+		err := Normalize(text, w, opts)
+		if text.err != nil {
+			// Report the problem with the input rather than however the
+			// decoder happened to wrap it
+			return text.err
+		}
+		return err
+	}
+
+	if opts.FromJSON {
+		data, err := jsonToYAML(r)
+		if err != nil {
+			return err
+		}
+		opts.FromJSON = false
+		return Normalize(bytes.NewReader(data), w, opts)
+	}
+
+	if opts.HoistHeader && opts.Format != FormatJSONLines {
+		header, rest, err := readHeader(bufio.NewReader(r))
+		if err != nil {
+			return err
+		}
+		opts.HoistHeader = false
+		if header == nil {
+			return Normalize(rest, w, opts)
+		}
+		hw := &headerWriter{w: w, header: header}
+		if err := Normalize(rest, hw, opts); err != nil {
+			return err
+		}
+		if !hw.wrote {
+			// Keep the header even if there are no documents
+			if _, err := w.Write(header); err != nil {
+				return fmt.Errorf("failed to write file header: %w", err)
+			}
+		}
+		return nil
+	}
+
+	if opts.VerifyEqual || opts.Atomic || opts.Strict || opts.RejectEmptyOutput {
+		return normalizeBuffered(r, w, opts)
+	}
+	return normalize(r, w, opts)
+}
+
+// NormalizeBytes normalizes the YAML stream in data and returns the result.
+// It is equivalent to calling Normalize with data as the input and a buffer as
+// the output. Empty input produces empty output.
+func NormalizeBytes(data []byte, opts Options) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := Normalize(bytes.NewReader(data), &buf, opts); err != nil {
+		return nil, err
+	}
+	if buf.Len() == 0 {
+		return []byte{}, nil
+	}
+	return buf.Bytes(), nil
+}
+
+// NormalizeString is like NormalizeBytes, but for strings.
+func NormalizeString(in string, opts Options) (string, error) {
+	var sb strings.Builder
+	if err := Normalize(strings.NewReader(in), &sb, opts); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+// NormalizeWithHashes normalizes the YAML stream in r, and returns the
+// normalized content of each document along with the hex-encoded SHA-256 of
+// each, keyed by the document's index in the returned slice. Separators
+// written between documents are not included, but markers that are part of a
+// document, such as with ExplicitStart or documents copied through unchanged,
+// are. Documents dropped by DropEmptyDocuments, and any header hoisted by
+// HoistHeader, are not included.
+func NormalizeWithHashes(r io.Reader, opts Options) (map[int]string, [][]byte, error) {
+	var docs [][]byte
+	opts.onDocument = func(content []byte) {
+		docs = append(docs, bytes.Clone(content))
+	}
+	if err := Normalize(r, io.Discard, opts); err != nil {
+		return nil, nil, err
+	}
+
+	hashes := make(map[int]string, len(docs))
+	for i, doc := range docs {
+		sum := sha256.Sum256(doc)
+		hashes[i] = hex.EncodeToString(sum[:])
+	}
+	return hashes, docs, nil
+}
+
+// NormalizeNode normalizes an already decoded node in place, sorting keys and
+// resetting styles as Normalize would. Steps that apply to whole documents,
+// such as StrictAnchors, ExpandMerges, and CleanInvisible, are not run;
+// options that only affect encoding, such as Indent, have no effect.
+func NormalizeNode(node *yaml.Node, opts Options) error {
+	return normalizeNode(node, nil, opts)
+}
+
+// NormalizeValue encodes v as YAML, as yaml.Marshal would, and returns it
+// normalized. Options that only apply to reading input, such as StrictText,
+// have no effect.
+func NormalizeValue(v any, opts Options) ([]byte, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	var node yaml.Node
+	if err := node.Encode(v); err != nil {
+		return nil, fmt.Errorf("failed to encode value: %w", err)
+	}
+	if err := normalizeDocumentNode(&node, opts); err != nil {
+		return nil, fmt.Errorf("failed to normalize YAML node: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := encodeDocument(&buf, &node, true, opts); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func normalize(r io.Reader, w io.Writer, opts Options) error {
+	if opts.MaxLineLength > 0 {
+		lw := &lineLengthWriter{w: w, opts: opts, max: opts.MaxLineLength}
+		if err := normalizeStream(r, lw, opts); err != nil {
+			return err
+		}
+		lw.endLine()
+		return lw.err
+	}
+	return normalizeStream(r, w, opts)
+}
+
+func normalizeStream(r io.Reader, w io.Writer, opts Options) error {
+	if opts.needsSource() {
+		return normalizeDocuments(r, w, opts)
+	}
+
 	dec := yaml.NewDecoder(r)
-	enc := yaml.NewEncoder(w)
-	enc.SetIndent(2)
 
 	wrote := false
-	for {
+	for index := 1; ; index++ {
 		var node yaml.Node
 
 		err := dec.Decode(&node)
@@ -55,46 +728,507 @@ func Normalize(r io.Reader, w io.Writer, preserveComments bool) error {
 			break
 		}
 		if err != nil {
-			return fmt.Errorf("failed to decode YAML input: %w", err)
+			return decodeError(err, fmt.Sprintf("document %d", index))
+		}
+		if opts.StrictAliases {
+			if err := checkAliasScope(&node); err != nil {
+				return fmt.Errorf("document %d: %w", index, err)
+			}
+		}
+		if opts.TreeWriter != nil {
+			if err := writeTree(opts.TreeWriter, &node, fmt.Sprintf("document %d", index), opts); err != nil {
+				return err
+			}
+		}
+		if opts.DropEmptyDocuments && isEmptyDocument(&node) {
+			continue
 		}
 
-		err = normalizeNode(&node, preserveComments)
+		err = normalizeDocumentNode(&node, opts)
 		if err != nil {
-			return fmt.Errorf("failed to normalize YAML node: %w", err)
+			return fmt.Errorf("failed to normalize YAML node in document %d: %w", index, err)
 		}
 
-		err = enc.Encode(&node)
-		if err != nil {
-			return fmt.Errorf("failed to encode normalized YAML: %w", err)
+		if opts.onDocument != nil {
+			var buf bytes.Buffer
+			if err := encodeDocument(&buf, &node, true, opts); err != nil {
+				return err
+			}
+			if wrote && !opts.ExplicitStart && opts.Format == FormatYAML {
+				if _, err := io.WriteString(w, "---\n"); err != nil {
+					return fmt.Errorf("failed to write document separator: %w", err)
+				}
+			}
+			if _, err := w.Write(buf.Bytes()); err != nil {
+				return fmt.Errorf("failed to write normalized YAML: %w", err)
+			}
+			opts.onDocument(buf.Bytes())
+		} else if err := encodeDocument(w, &node, !wrote, opts); err != nil {
+			return err
+		}
+		if opts.JSONWriter != nil {
+			if err := encodeJSONLine(opts.JSONWriter, &node); err != nil {
+				return err
+			}
 		}
 
 		wrote = true
 	}
 
-	var err error
-	if wrote {
+	return nil
+}
+
+// The encoder only supports indents in this range, and silently uses the
+// default for any other.
+const (
+	defaultIndent = 2
+	minIndent     = 2
+	maxIndent     = 9
+)
+
+// encodeDocument writes a normalized document to w, preceded by a document
+// separator unless it is the first document in the stream and
+// opts.ExplicitStart is not set, and followed by an end marker if
+// opts.ExplicitEnd is set.
+func encodeDocument(w io.Writer, node *yaml.Node, first bool, opts Options) error {
+	if opts.Format == FormatJSONLines {
+		return encodeJSONLine(w, node)
+	}
+
+	if !first || opts.ExplicitStart {
+		if _, err := io.WriteString(w, "---\n"); err != nil {
+			return fmt.Errorf("failed to write document separator: %w", err)
+		}
+	}
+
+	var replacer *strings.Replacer
+	if !opts.EscapeUnicode {
+		var restore func()
+		replacer, restore = literalUnicode(node)
+		if restore != nil {
+			defer restore()
+		}
+	}
+
+	var folded []*foldedFlow
+	if opts.FlowSets && opts.FlowWidth > 0 {
+		var err error
+		if folded, err = foldFlowSets(node); err != nil {
+			return err
+		}
+	}
+
+	out := w
+	var buf *bytes.Buffer
+	if opts.AlignValues || replacer != nil || folded != nil {
+		buf = new(bytes.Buffer)
+		out = buf
+	}
+
+	enc := yaml.NewEncoder(out)
+	indent := opts.Indent
+	if indent == 0 {
+		indent = defaultIndent
+	}
+	enc.SetIndent(indent)
+	if opts.CompactSequenceIndent {
+		enc.CompactSeqIndent()
+	}
+	err := enc.Encode(node)
+	if err == nil {
 		err = enc.Close()
 	}
+	if folded != nil {
+		// Restores the nodes even if encoding fails
+		data := unfoldFlowSets(buf.Bytes(), folded, opts.FlowWidth, indent)
+		buf = bytes.NewBuffer(data)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to encode normalized YAML: %w", err)
+	}
+
+	if buf != nil {
+		data := buf.Bytes()
+		if opts.AlignValues {
+			aligned, err := alignValues(data)
+			if err != nil {
+				return fmt.Errorf("failed to align values: %w", err)
+			}
+			data = aligned
+		}
+		if replacer != nil {
+			data = []byte(replacer.Replace(string(data)))
+		}
+		if _, err := w.Write(data); err != nil {
+			return fmt.Errorf("failed to write normalized YAML: %w", err)
+		}
+	}
+
+	if opts.ExplicitEnd {
+		if _, err := io.WriteString(w, "...\n"); err != nil {
+			return fmt.Errorf("failed to write document end marker: %w", err)
+		}
+	}
+	return nil
+}
+
+// encodeJSONLine writes a document to w as JSON on a single line.
+func encodeJSONLine(w io.Writer, node *yaml.Node) error {
+	// JSON has no merge keys, so the pairs they merge in are written instead
+	if containsMergeKey(node) {
+		node = cloneNode(node)
+		if err := expandMerges(node); err != nil {
+			return fmt.Errorf("failed to encode normalized JSON: %w", err)
+		}
+	}
+
+	out, err := appendJSON(nil, node)
+	if err != nil {
+		return fmt.Errorf("failed to encode normalized JSON: %w", err)
+	}
+	if _, err := w.Write(append(out, '\n')); err != nil {
+		return fmt.Errorf("failed to write normalized JSON: %w", err)
+	}
+	return nil
+}
+
+// normalizeDocuments normalizes a stream one document at a time, keeping the
+// source of each document so that it can be copied through unchanged.
+// Documents are normalized in parallel if opts.DocumentWorkers is set.
+func normalizeDocuments(r io.Reader, w io.Writer, opts Options) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("failed to read YAML input: %w", err)
+	}
+
+	docs := opts.splitDocuments(data)
+	results := make([]documentResult, len(docs))
+
+	var g errgroup.Group
+	g.SetLimit(max(opts.DocumentWorkers, 1))
+	for i, doc := range docs {
+		g.Go(func() error {
+			result, err := normalizeDocument(doc, opts)
+			if err != nil {
+				return err
+			}
+			results[i] = result
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return err
+	}
+
+	wrote := false
+	for _, result := range results {
+		if result.content == nil {
+			continue
+		}
+
+		if (wrote || opts.ExplicitStart) && !result.marked && opts.Format == FormatYAML {
+			if _, err := io.WriteString(w, "---\n"); err != nil {
+				return fmt.Errorf("failed to write document separator: %w", err)
+			}
+		}
+		if _, err := w.Write(result.content); err != nil {
+			return fmt.Errorf("failed to write document: %w", err)
+		}
+		if opts.onDocument != nil {
+			opts.onDocument(result.content)
+		}
+		if opts.JSONWriter != nil {
+			if _, err := opts.JSONWriter.Write(result.json); err != nil {
+				return fmt.Errorf("failed to write normalized JSON: %w", err)
+			}
+		}
+		wrote = true
+	}
+
+	return nil
+}
+
+// documentResult is the output for a single document in a stream.
+type documentResult struct {
+	// content is nil if the source contained no document
+	content []byte
+	// marked is set if content starts with its own document marker, so no
+	// separator is needed before it
+	marked bool
+	// json is the document as a line of JSON, if opts.JSONWriter is set
+	json []byte
+}
+
+func normalizeDocument(doc document, opts Options) (documentResult, error) {
+	var node yaml.Node
+	dec := yaml.NewDecoder(bytes.NewReader(doc.source))
+	err := dec.Decode(&node)
+	if err == io.EOF {
+		return documentResult{}, nil
+	}
+	if err != nil {
+		return documentResult{}, decodeError(err, fmt.Sprintf("document starting at line %d", doc.line))
+	}
+	if doc.line > 1 {
+		offsetLines(&node, doc.line-1)
+	}
+	if opts.StrictTrailingContent {
+		if err := checkTrailingContent(doc, &node, dec); err != nil {
+			return documentResult{}, err
+		}
+	}
+	if opts.TreeWriter != nil {
+		if err := writeTree(opts.TreeWriter, &node, fmt.Sprintf("document starting at line %d", doc.line), opts); err != nil {
+			return documentResult{}, err
+		}
+	}
+	if opts.DropEmptyDocuments && isEmptyDocument(&node) {
+		return documentResult{}, nil
+	}
+
+	result, err := normalizeDecodedDocument(doc, &node, opts)
+	if err != nil || opts.JSONWriter == nil {
+		return result, err
+	}
+
+	var buf bytes.Buffer
+	if err := encodeJSONLine(&buf, &node); err != nil {
+		return documentResult{}, err
+	}
+	result.json = buf.Bytes()
+	return result, nil
+}
+
+func normalizeDecodedDocument(doc document, node *yaml.Node, opts Options) (documentResult, error) {
+	if opts.passThrough(node) {
+		if opts.Format != FormatYAML {
+			var buf bytes.Buffer
+			if err := encodeDocument(&buf, node, true, opts); err != nil {
+				return documentResult{}, err
+			}
+			return documentResult{content: buf.Bytes()}, nil
+		}
+		return documentResult{content: opts.sourceContent(doc), marked: doc.explicit}, nil
+	}
+
+	var original *yaml.Node
+	if opts.PreserveUnchanged && opts.Format == FormatYAML {
+		original = cloneNode(node)
+	}
+
+	if err := normalizeDocumentNode(node, opts); err != nil {
+		return documentResult{}, fmt.Errorf("failed to normalize YAML node in document starting at line %d: %w", doc.line, err)
+	}
+
+	if original != nil && sameContent(original, node) {
+		return documentResult{content: opts.sourceContent(doc), marked: doc.explicit}, nil
+	}
+
+	var preserved []*preservedValue
+	if len(opts.PreserveValueKeys) > 0 && opts.Format == FormatYAML {
+		preserved = preserveValues(doc, node, opts)
+	}
+
+	var buf bytes.Buffer
+	err := encodeDocument(&buf, node, true, opts)
+	content := restoreValues(buf.Bytes(), preserved)
+	if err != nil {
+		return documentResult{}, err
+	}
+	return documentResult{content: content, marked: opts.ExplicitStart}, nil
+}
+
+// normalizeBuffered normalizes the whole input into memory and only writes it
+// out once every document has been normalized and, if requested, verified.
+func normalizeBuffered(r io.Reader, w io.Writer, opts Options) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("failed to read YAML input: %w", err)
+	}
+
+	jsonOut := opts.JSONWriter
+	var jsonBuf bytes.Buffer
+	if jsonOut != nil {
+		opts.JSONWriter = &jsonBuf
+	}
+
+	var buf bytes.Buffer
+	if err := normalize(bytes.NewReader(data), &buf, opts); err != nil {
+		return err
+	}
+
+	if opts.VerifyEqual {
+		if err := verifyEqual(data, buf.Bytes(), opts.Format); err != nil {
+			return err
+		}
+	}
+
+	if opts.RejectEmptyOutput && isEmptyOutput(buf.Bytes()) {
+		return errors.New("normalized output is empty")
+	}
+
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	if jsonOut != nil {
+		if _, err := jsonOut.Write(jsonBuf.Bytes()); err != nil {
+			return fmt.Errorf("failed to write normalized JSON: %w", err)
+		}
+	}
+	return nil
+}
+
+// isEmptyOutput reports whether normalized output has nothing but blank lines
+// and document markers.
+func isEmptyOutput(data []byte) bool {
+	for line := range bytes.Lines(data) {
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 && !bytes.Equal(line, []byte("---")) && !bytes.Equal(line, []byte("...")) {
+			return false
+		}
+	}
+	return true
+}
+
+// verifyEqual checks that two YAML streams contain the same number of
+// documents and that each pair of documents decodes to equal values. The
+// normalized stream is in the given format; JSON lines are compared with the
+// original documents as converted to JSON.
+func verifyEqual(original, normalized []byte, format Format) error {
+	want, err := decodeAll(original)
+	if err != nil {
+		return fmt.Errorf("failed to decode original YAML for verification: %w", err)
+	}
+	var got []any
+	if format == FormatJSONLines {
+		if want, err = jsonValues(want); err != nil {
+			return fmt.Errorf("failed to verify normalized JSON: %w", err)
+		}
+		got, err = decodeLines(normalized)
+	} else {
+		got, err = decodeAll(normalized)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to decode normalized YAML for verification: %w", err)
+	}
+
+	if len(want) != len(got) {
+		return fmt.Errorf("normalization changed the number of documents from %d to %d", len(want), len(got))
+	}
+	for i := range want {
+		if !reflect.DeepEqual(want[i], got[i]) {
+			return fmt.Errorf("normalization changed the value of document %d", i)
+		}
+	}
+	return nil
+}
+
+func decodeAll(data []byte) ([]any, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+
+	var docs []any
+	for {
+		var doc any
+		err := dec.Decode(&doc)
+		if err == io.EOF {
+			return docs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+}
+
+// jsonValues converts each of docs to the value it decodes to once written as
+// JSON, such as a string for a timestamp, and float64 for every number. Keys
+// that aren't strings would become strings, so they are an error.
+func jsonValues(docs []any) ([]any, error) {
+	values := make([]any, len(docs))
+	for i, doc := range docs {
+		if hasNonStringKeys(doc) {
+			return nil, fmt.Errorf("document %d has mapping keys that are not strings", i)
+		}
+		data, err := json.Marshal(doc)
+		if err != nil {
+			return nil, fmt.Errorf("document %d cannot be converted to JSON: %w", i, err)
+		}
+		if err := json.Unmarshal(data, &values[i]); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+// hasNonStringKeys reports whether a decoded value contains a mapping with a
+// key that isn't a string, which the decoder returns as map[any]any.
+func hasNonStringKeys(value any) bool {
+	switch value := value.(type) {
+	case map[any]any:
+		return true
+	case map[string]any:
+		for _, v := range value {
+			if hasNonStringKeys(v) {
+				return true
+			}
+		}
+	case []any:
+		for _, v := range value {
+			if hasNonStringKeys(v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// decodeLines decodes each line of data as a separate JSON document.
+func decodeLines(data []byte) ([]any, error) {
+	var docs []any
+	for line := range bytes.Lines(data) {
+		var doc any
+		if err := json.Unmarshal(line, &doc); err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
+
+func NormalizeFile(filename string, opts Options) error {
+	_, err := normalizeFile(filename, opts, false)
 	return err
 }
 
-func NormalizeFile(filename string, preserveComments bool) (finalErr error) {
+// NormalizeFileChanged is like NormalizeFile, but also reports whether
+// normalizing changed the content of the file. Large files are read again to
+// compare them.
+func NormalizeFileChanged(filename string, opts Options) (changed bool, err error) {
+	return normalizeFile(filename, opts, true)
+}
+
+// normalizeFile normalizes filename in-place. With compare set, or with
+// opts.SkipUnchanged, changed reports whether its content changed; otherwise,
+// it is true for large files.
+func normalizeFile(filename string, opts Options, compare bool) (changed bool, err error) {
+	opts = opts.WithFile(filename)
+
 	fileInfo, err := os.Stat(filename)
 	if err != nil {
-		return fmt.Errorf("failed to stat file: %w", err)
+		return false, fmt.Errorf("failed to stat file: %w", err)
 	}
 
-	if fileInfo.Mode()&0200 == 0 {
-		return fmt.Errorf("file to normalize is not writable: %s", filename)
+	if !opts.IgnoreReadOnly && fileInfo.Mode()&0200 == 0 {
+		return false, fmt.Errorf("file to normalize is not writable: %s", filename)
 	}
 
 	// For small files (<1MiB), just read into memory; otherwise, stream to
 	// temporary file and atomically rename
 	const largeFileThreshold = 1 * 1024 * 1024
 	if fileInfo.Size() <= largeFileThreshold {
-		return normalizeFileSmall(filename, fileInfo.Mode(), preserveComments)
+		return normalizeFileSmall(filename, fileInfo, opts)
 	}
-	return normalizeFileLarge(filename, fileInfo.Mode(), preserveComments)
+	return normalizeFileLarge(filename, fileInfo, opts, compare)
 }
 
 const (
@@ -104,12 +1238,10 @@ const (
 	largeBufferSize = 64 * 1024
 )
 
-func normalizeFileLarge(filename string, mode os.FileMode, preserveComments bool) (finalErr error) {
-	tmpFile := filepath.Join(filepath.Dir(filename), ".tmp_"+filepath.Base(filename))
-
+func normalizeFileLarge(filename string, fileInfo os.FileInfo, opts Options, compare bool) (changed bool, finalErr error) {
 	inFile, err := os.Open(filename)
 	if err != nil {
-		return fmt.Errorf("failed to read file: %w", err)
+		return false, fmt.Errorf("failed to read file: %w", err)
 	}
 	defer func() {
 		if err := inFile.Close(); finalErr == nil && err != nil {
@@ -118,44 +1250,294 @@ func normalizeFileLarge(filename string, mode os.FileMode, preserveComments bool
 	}()
 	r := bufio.NewReaderSize(inFile, largeBufferSize)
 
-	err = normalizeToFile(r, tmpFile, mode, largeBufferSize, preserveComments)
+	f, err := createTempFile(filename)
 	if err != nil {
-		return err
+		return false, fmt.Errorf("%w: %w", errTempFile, err)
+	}
+	// Cleared once the temporary file has replaced filename
+	tmpFile := f.Name()
+	defer func() {
+		if tmpFile != "" {
+			_ = os.Remove(tmpFile)
+		}
+	}()
+
+	err = writeTo(f, largeBufferSize, func(w io.Writer) error {
+		return Normalize(r, w, opts)
+	})
+	if err != nil {
+		return false, err
+	}
+
+	changed = true
+	if opts.SkipUnchanged || compare {
+		same, err := sameFileContents(filename, tmpFile)
+		if err != nil {
+			return false, err
+		}
+		if same && opts.SkipUnchanged {
+			return false, nil
+		}
+		changed = !same
+	}
+
+	if err := copyAttributes(tmpFile, fileInfo, opts); err != nil {
+		return false, err
+	}
+
+	if opts.BackupSuffix != "" {
+		if err := BackupFile(filename, opts.BackupSuffix); err != nil {
+			return false, err
+		}
 	}
 
 	err = os.Rename(tmpFile, filename)
 	if err != nil {
-		return fmt.Errorf("failed to replace original file: %w", err)
+		return false, fmt.Errorf("failed to replace original file: %w", err)
 	}
+	tmpFile = ""
+	syncDir(filename)
 
-	return nil
+	return changed, nil
 }
 
-func normalizeFileSmall(filename string, mode os.FileMode, preserveComments bool) (finalErr error) {
+func normalizeFileSmall(filename string, fileInfo os.FileInfo, opts Options) (changed bool, err error) {
 	data, err := os.ReadFile(filename)
+	if err != nil {
+		return false, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	// Normalize into memory first, so that the file is left untouched if
+	// normalizing fails
+	var buf bytes.Buffer
+	if err := Normalize(bytes.NewReader(data), &buf, opts); err != nil {
+		return false, err
+	}
+	changed = !bytes.Equal(buf.Bytes(), data)
+	if opts.SkipUnchanged && !changed {
+		return false, nil
+	}
+
+	if opts.BackupSuffix != "" {
+		if err := BackupFile(filename, opts.BackupSuffix); err != nil {
+			return false, err
+		}
+	}
+
+	write := func(w io.Writer) error {
+		_, err := w.Write(buf.Bytes())
+		return err
+	}
+	err = replaceFile(filename, fileInfo, smallBufferSize, write, opts)
+	if errors.Is(err, errTempFile) {
+		// The directory may not be writable even though the file is. The
+		// content is already in memory, so only a crash while writing it can
+		// leave the file incomplete.
+		return changed, writeFile(filename, fileInfo.Mode(), smallBufferSize, write)
+	}
+	return changed, err
+}
+
+// BackupFile copies filename to its name with suffix added, keeping its
+// mode. An existing backup is overwritten.
+func BackupFile(filename, suffix string) (finalErr error) {
+	fileInfo, err := os.Stat(filename)
+	if err != nil {
+		return fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	f, err := os.Open(filename)
 	if err != nil {
 		return fmt.Errorf("failed to read file: %w", err)
 	}
-	return normalizeToFile(bytes.NewReader(data), filename, mode, smallBufferSize, preserveComments)
+	defer func() {
+		if err := f.Close(); finalErr == nil && err != nil {
+			finalErr = err
+		}
+	}()
+
+	err = writeFile(filename+suffix, fileInfo.Mode(), largeBufferSize, func(w io.Writer) error {
+		_, err := io.Copy(w, f)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to back up file: %w", err)
+	}
+	return nil
+}
+
+// WriteFileAtomic calls write with a temporary file next to filename, then
+// replaces filename with the temporary file once write succeeds. If write
+// fails, filename is left untouched. Existing files keep their mode and, if
+// permitted, their owner; new files are created with mode 0644.
+func WriteFileAtomic(filename string, write func(w io.Writer) error) error {
+	fileInfo, err := os.Stat(filename)
+	if err != nil {
+		fileInfo = nil
+	}
+	return replaceFile(filename, fileInfo, largeBufferSize, write, Options{})
+}
+
+// errTempFile is returned by replaceFile when the temporary file can't be
+// created.
+var errTempFile = errors.New("failed to create temporary file")
+
+// replaceFile calls write with a temporary file next to filename, then
+// replaces filename with it once write succeeds. The new file takes the mode
+// and owner of original, as with copyAttributes, or mode 0644 if original is
+// nil. If anything fails, filename is left untouched and the temporary file
+// is removed.
+func replaceFile(filename string, original os.FileInfo, bufferSize int, write func(w io.Writer) error, opts Options) error {
+	mode := os.FileMode(0644)
+	if original != nil {
+		mode = original.Mode()
+	}
+
+	f, err := createTempFile(filename)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errTempFile, err)
+	}
+	tmpFile := f.Name()
+
+	if err := writeTo(f, bufferSize, write); err != nil {
+		_ = os.Remove(tmpFile)
+		return err
+	}
+	if original != nil {
+		err = copyAttributes(tmpFile, original, opts)
+	} else {
+		// The temporary file is created with mode 0600
+		err = os.Chmod(tmpFile, mode)
+	}
+	if err != nil {
+		_ = os.Remove(tmpFile)
+		return err
+	}
+
+	if err := os.Rename(tmpFile, filename); err != nil {
+		_ = os.Remove(tmpFile)
+		return fmt.Errorf("failed to replace file: %w", err)
+	}
+	syncDir(filename)
+	return nil
+}
+
+// syncDir flushes the directory containing filename to disk, so that a file
+// renamed into it survives a crash. It is best effort, since not every
+// platform or file system can sync directories, and the rename has already
+// succeeded.
+func syncDir(filename string) {
+	dir, err := os.Open(filepath.Dir(filename))
+	if err != nil {
+		return
+	}
+	_ = dir.Sync()
+	_ = dir.Close()
+}
+
+// copyAttributes gives filename the owner and group of original, if they
+// differ, then its mode, including the setuid, setgid, and sticky bits. Not
+// having permission to change the owner is reported as a warning rather than
+// an error, since it only matters to files owned by another user.
+func copyAttributes(filename string, original os.FileInfo, opts Options) error {
+	if err := chownLike(filename, original); err != nil {
+		if !errors.Is(err, fs.ErrPermission) {
+			return fmt.Errorf("failed to set file owner: %w", err)
+		}
+		err := opts.warn(Warning{Message: fmt.Sprintf("could not preserve the owner of the file: %v", err)})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Changing the owner clears the setuid and setgid bits, so the mode is
+	// set afterwards. The mode given when creating the file is also subject
+	// to the umask.
+	mode := original.Mode() & (fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky)
+	if err := os.Chmod(filename, mode); err != nil {
+		return fmt.Errorf("failed to set file mode: %w", err)
+	}
+	return nil
+}
+
+// createTempFile creates a new temporary file next to filename to replace it
+// with, with a unique name so that it never overwrites an existing file or
+// the temporary file of another write to the same file. It has mode 0600.
+func createTempFile(filename string) (*os.File, error) {
+	return os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".*")
 }
 
-func normalizeToFile(r io.Reader, filename string, mode os.FileMode, bufferSize int, preserveComments bool) (finalErr error) {
+func writeFile(filename string, mode os.FileMode, bufferSize int, write func(w io.Writer) error) error {
 	outFile, err := os.OpenFile(filename, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
 	if err != nil {
 		return fmt.Errorf("failed to open file for writing: %w", err)
 	}
+	return writeTo(outFile, bufferSize, write)
+}
+
+// writeTo calls write with a buffered writer for f, then flushes f to disk
+// and closes it.
+func writeTo(f *os.File, bufferSize int, write func(w io.Writer) error) (finalErr error) {
+	defer func() {
+		if err := f.Close(); finalErr == nil && err != nil {
+			finalErr = err
+		}
+	}()
+
+	w := bufio.NewWriterSize(f, bufferSize)
+	if err := write(w); err != nil {
+		_ = w.Flush()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	// Synced before closing, so that a temporary file renamed over another
+	// file is never left empty after a crash
+	return f.Sync()
+}
+
+// sameFileContents reports whether two files have identical contents.
+func sameFileContents(a, b string) (same bool, finalErr error) {
+	fa, err := os.Open(a)
+	if err != nil {
+		return false, fmt.Errorf("failed to read file: %w", err)
+	}
 	defer func() {
-		if err := outFile.Close(); finalErr == nil && err != nil {
+		if err := fa.Close(); finalErr == nil && err != nil {
 			finalErr = err
 		}
 	}()
 
-	w := bufio.NewWriterSize(outFile, bufferSize)
+	fb, err := os.Open(b)
+	if err != nil {
+		return false, fmt.Errorf("failed to read file: %w", err)
+	}
 	defer func() {
-		if err := w.Flush(); finalErr == nil && err != nil {
+		if err := fb.Close(); finalErr == nil && err != nil {
 			finalErr = err
 		}
 	}()
 
-	return Normalize(r, w, preserveComments)
+	bufA := make([]byte, largeBufferSize)
+	bufB := make([]byte, largeBufferSize)
+	for {
+		na, errA := io.ReadFull(fa, bufA)
+		nb, errB := io.ReadFull(fb, bufB)
+		if !bytes.Equal(bufA[:na], bufB[:nb]) {
+			return false, nil
+		}
+
+		doneA := errA == io.EOF || errA == io.ErrUnexpectedEOF
+		doneB := errB == io.EOF || errB == io.ErrUnexpectedEOF
+		if errA != nil && !doneA {
+			return false, fmt.Errorf("failed to read file: %w", errA)
+		}
+		if errB != nil && !doneB {
+			return false, fmt.Errorf("failed to read file: %w", errB)
+		}
+		if doneA || doneB {
+			return doneA && doneB, nil
+		}
+	}
 }
@@ -2,13 +2,21 @@ package normalizer
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"reflect"
+	"regexp"
 	"strings"
 	"testing"
+	"testing/iotest"
+	"time"
 
 	"go.yaml.in/yaml/v3"
 )
@@ -202,7 +210,7 @@ spec:
 				t.Fatalf("Failed to write test file: %v", err)
 			}
 
-			err := NormalizeFile(filename, true)
+			err := NormalizeFile(filename, Options{PreserveComments: true})
 
 			if tt.expectError {
 				if err == nil {
@@ -326,7 +334,7 @@ object:
 
 			var output bytes.Buffer
 
-			err := Normalize(input, &output, true)
+			err := Normalize(input, &output, Options{PreserveComments: true})
 
 			if tt.expectError {
 				if err == nil {
@@ -355,7 +363,7 @@ object:
 func TestNormalizeFile_NonExistentFile(t *testing.T) {
 	t.Parallel()
 
-	err := NormalizeFile("nonexistent.yaml", true)
+	err := NormalizeFile("nonexistent.yaml", Options{PreserveComments: true})
 	if err == nil {
 		t.Error("Expected error for non-existent file, but got none")
 	}
@@ -375,7 +383,7 @@ func TestNormalizeFile_UnwritableFile(t *testing.T) {
 		t.Fatalf("Failed to make file read-only: %v", err)
 	}
 
-	err := NormalizeFile(filename, true)
+	err := NormalizeFile(filename, Options{PreserveComments: true})
 	if err == nil {
 		t.Error("Expected error for unwritable file, but got none")
 	}
@@ -387,7 +395,7 @@ func TestNormalize_ReaderError(t *testing.T) {
 	badReader := &badReader{}
 	var output bytes.Buffer
 
-	err := Normalize(badReader, &output, true)
+	err := Normalize(badReader, &output, Options{PreserveComments: true})
 	if err == nil {
 		t.Error("Expected error for bad reader, but got none")
 	}
@@ -399,7 +407,7 @@ func TestNormalize_WriterError(t *testing.T) {
 	input := strings.NewReader("key: value\n")
 	badWriter := &badWriter{}
 
-	err := Normalize(input, badWriter, true)
+	err := Normalize(input, badWriter, Options{PreserveComments: true})
 	if err == nil {
 		t.Error("Expected error for bad writer, but got none")
 	}
@@ -431,7 +439,7 @@ object:
 `
 
 	var output bytes.Buffer
-	err := Normalize(strings.NewReader(input), &output, true)
+	err := Normalize(strings.NewReader(input), &output, Options{PreserveComments: true})
 	if err != nil {
 		t.Fatalf("Normalize failed: %v", err)
 	}
@@ -480,7 +488,7 @@ unicode: "café"
 `
 
 	var output bytes.Buffer
-	err := Normalize(strings.NewReader(input), &output, true)
+	err := Normalize(strings.NewReader(input), &output, Options{PreserveComments: true})
 	if err != nil {
 		t.Fatalf("Normalize failed: %v", err)
 	}
@@ -750,7 +758,7 @@ mixed: "ASCII and 中文 and العربية"
 				t.Fatalf("Failed to write test file: %v", err)
 			}
 
-			err := NormalizeFile(filename, true)
+			err := NormalizeFile(filename, Options{PreserveComments: true})
 			if err != nil {
 				t.Fatalf("NormalizeFile failed: %v", err)
 			}
@@ -761,7 +769,7 @@ mixed: "ASCII and 中文 and العربية"
 			}
 
 			var bufferContent bytes.Buffer
-			err = Normalize(strings.NewReader(tc.input), &bufferContent, true)
+			err = Normalize(strings.NewReader(tc.input), &bufferContent, Options{PreserveComments: true})
 			if err != nil {
 				t.Fatalf("Normalize failed: %v", err)
 			}
@@ -913,11 +921,11 @@ service2:
   timeout: 30
 ---
 service1:
-  !!merge <<: *default
+  <<: *default
   name: frontend
 ---
 service2:
-  !!merge <<: *default
+  <<: *default
   name: backend
 `,
 		},
@@ -981,7 +989,7 @@ nested:
 			input := strings.NewReader(tt.input)
 			var output bytes.Buffer
 
-			err := Normalize(input, &output, true)
+			err := Normalize(input, &output, Options{PreserveComments: true})
 
 			if tt.expectError {
 				if err == nil {
@@ -1144,7 +1152,7 @@ spec:
 				t.Fatalf("Failed to write test file: %v", err)
 			}
 
-			err := NormalizeFile(filename, true)
+			err := NormalizeFile(filename, Options{PreserveComments: true})
 
 			if tt.expectError {
 				if err == nil {
@@ -1280,7 +1288,7 @@ another: key
 				}
 
 				var buf bytes.Buffer
-				err = Normalize(file, &buf, true)
+				err = Normalize(file, &buf, Options{PreserveComments: true})
 				err = errors.Join(err, file.Close())
 
 				if tt.expectError {
@@ -1369,7 +1377,7 @@ unicode_array:
 ---
 mixed: "ASCII and 中文 and العربية"
 `,
-			expected: `emoji: "\U0001F680"
+			expected: `emoji: 🚀
 greeting: こんにちは
 name: café
 ---
@@ -1414,15 +1422,15 @@ deployment:
 service: &service
   name: test-service
   settings:
-    !!merge <<: *defaults
+    <<: *defaults
     custom: value
 ---
 deployment:
   config:
-    !!merge <<: *config
+    <<: *config
   template:
     spec:
-      !!merge <<: *service
+      <<: *service
       replicas: 3
 `,
 		},
@@ -1466,7 +1474,7 @@ literal: |2
 			input := strings.NewReader(tt.input)
 			var output bytes.Buffer
 
-			err := Normalize(input, &output, true)
+			err := Normalize(input, &output, Options{PreserveComments: true})
 
 			if tt.expectError {
 				if err == nil {
@@ -1532,7 +1540,7 @@ second: valid
 			input := strings.NewReader(tt.input)
 			var output bytes.Buffer
 
-			err := Normalize(input, &output, true)
+			err := Normalize(input, &output, Options{PreserveComments: true})
 
 			if tt.expectError {
 				if err == nil {
@@ -1561,7 +1569,7 @@ doc3: value3
 	// Create a writer that fails after the first document
 	failingWriter := &failingWriter{failAfter: 20}
 
-	err := Normalize(input, failingWriter, true)
+	err := Normalize(input, failingWriter, Options{PreserveComments: true})
 	if err == nil {
 		t.Error("Expected error for failing writer, but got none")
 	}
@@ -1579,3 +1587,4252 @@ func (w *failingWriter) Write(p []byte) (n int, err error) {
 	w.written += len(p)
 	return len(p), nil
 }
+
+func TestNormalize_VerifyEqual(t *testing.T) {
+	t.Parallel()
+
+	input := `b: 2
+a: [1, 2, 3]
+---
+service:
+  <<: &defaults
+    timeout: 30
+  name: frontend
+`
+
+	expected := `a:
+  - 1
+  - 2
+  - 3
+b: 2
+---
+service:
+  <<: &defaults
+    timeout: 30
+  name: frontend
+`
+
+	var output bytes.Buffer
+	if err := Normalize(strings.NewReader(input), &output, Options{VerifyEqual: true}); err != nil {
+		t.Fatalf("Normalize failed: %v", err)
+	}
+
+	if got := output.String(); got != expected {
+		t.Errorf("Normalize() = %q, want %q", got, expected)
+	}
+}
+
+func TestVerifyEqual_DetectsChangedValues(t *testing.T) {
+	t.Parallel()
+
+	original := []byte(`name: test
+replicas: 3
+---
+other: document
+`)
+
+	// Simulate a buggy transform that rewrites a scalar value while
+	// normalizing
+	buggyTransform := func(node *yaml.Node) {
+		mapping := node.Content[0]
+		for i := 0; i < len(mapping.Content); i += 2 {
+			if mapping.Content[i].Value == "replicas" {
+				mapping.Content[i+1].Value = "03"
+				mapping.Content[i+1].Tag = "!!str"
+			}
+		}
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(original))
+	var normalized bytes.Buffer
+	enc := yaml.NewEncoder(&normalized)
+	for {
+		var node yaml.Node
+		err := dec.Decode(&node)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Decode failed: %v", err)
+		}
+		if err := normalizeNode(&node, nil, Options{}); err != nil {
+			t.Fatalf("normalizeNode failed: %v", err)
+		}
+		buggyTransform(&node)
+		if err := enc.Encode(&node); err != nil {
+			t.Fatalf("Encode failed: %v", err)
+		}
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	err := verifyEqual(original, normalized.Bytes(), FormatYAML)
+	if err == nil {
+		t.Fatal("Expected verification error for changed value, but got none")
+	}
+	if !strings.Contains(err.Error(), "document 0") {
+		t.Errorf("Expected error to identify document 0, got: %v", err)
+	}
+
+	if err := verifyEqual(original, []byte("name: test\nreplicas: 3\n"), FormatYAML); err == nil {
+		t.Error("Expected verification error for dropped document, but got none")
+	}
+}
+
+func TestNormalize_OnlyKinds(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name: "mixed kinds",
+			input: `kind: Deployment
+metadata:
+  name: web
+apiVersion: apps/v1
+---
+kind: CustomResourceDefinition
+metadata:   {name: "widgets.example.com"}
+apiVersion: apiextensions.k8s.io/v1
+---
+spec:
+  ports: [80]
+kind: Service
+apiVersion: v1
+`,
+			expected: `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+---
+kind: CustomResourceDefinition
+metadata:   {name: "widgets.example.com"}
+apiVersion: apiextensions.k8s.io/v1
+---
+apiVersion: v1
+kind: Service
+spec:
+  ports:
+    - 80
+`,
+		},
+		{
+			name: "pass-through first document with leading marker and comment",
+			input: `# header
+---
+kind: ConfigMap
+data: {b: 2, a: 1}
+---
+kind: Service
+b: 2
+a: 1
+`,
+			expected: `# header
+---
+kind: ConfigMap
+data: {b: 2, a: 1}
+---
+a: 1
+b: 2
+kind: Service
+`,
+		},
+		{
+			name: "documents without kind are copied",
+			input: `b: 2
+a: 1
+...
+---
+- z
+- y
+`,
+			expected: `b: 2
+a: 1
+...
+---
+- z
+- y
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var output bytes.Buffer
+			opts := Options{OnlyKinds: []string{"Deployment", "Service"}}
+			if err := Normalize(strings.NewReader(tt.input), &output, opts); err != nil {
+				t.Fatalf("Normalize failed: %v", err)
+			}
+
+			if got := output.String(); got != tt.expected {
+				t.Errorf("Normalize() = %q, want %q", got, tt.expected)
+			}
+
+			var obj any
+			dec := yaml.NewDecoder(&output)
+			for {
+				if err := dec.Decode(&obj); err != nil {
+					if !errors.Is(err, io.EOF) {
+						t.Errorf("Normalized output is not valid YAML: %v", err)
+					}
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestSplitDocuments(t *testing.T) {
+	t.Parallel()
+
+	input := `# comment
+---
+a: 1
+--- !tag
+b: |
+  ---indented
+...
+# trailing
+c: 3`
+
+	expected := []document{
+		{source: []byte("# comment\n---\na: 1\n"), explicit: true, line: 1},
+		{source: []byte("--- !tag\nb: |\n  ---indented\n...\n"), explicit: true, line: 4},
+		{source: []byte("# trailing\nc: 3"), line: 8},
+	}
+
+	got := splitDocuments([]byte(input))
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("splitDocuments() = %+v, want %+v", got, expected)
+	}
+}
+
+func TestNormalize_AlignValues(t *testing.T) {
+	t.Parallel()
+
+	input := `name: web
+replicas: 3
+image: "nginx:latest"
+labels:
+  app: web
+  tier: frontend
+ports:
+- name: http
+  containerPort: 80
+---
+a: 1
+`
+
+	expected := `image:    nginx:latest
+labels:
+  app:  web
+  tier: frontend
+name:     web
+ports:
+  - containerPort: 80
+    name:          http
+replicas: 3
+---
+a: 1
+`
+
+	var output bytes.Buffer
+	if err := Normalize(strings.NewReader(input), &output, Options{AlignValues: true, VerifyEqual: true}); err != nil {
+		t.Fatalf("Normalize failed: %v", err)
+	}
+
+	if got := output.String(); got != expected {
+		t.Errorf("Normalize() = %q, want %q", got, expected)
+	}
+}
+
+func TestNormalize_EmbeddedYAML(t *testing.T) {
+	t.Parallel()
+
+	input := `kind: ConfigMap
+apiVersion: v1
+data:
+  config.yaml: |
+    server:
+      port: 8080
+      host: "0.0.0.0"
+    logging: {level: info}
+  flat.yaml: "b: 2\na: 1\n"
+  notes.txt: just some text
+other:
+  config.yaml: "b: 2\na: 1\n"
+`
+
+	expected := `apiVersion: v1
+data:
+  config.yaml: |
+    logging:
+      level: info
+    server:
+      host: 0.0.0.0
+      port: 8080
+  flat.yaml: |
+    a: 1
+    b: 2
+  notes.txt: just some text
+kind: ConfigMap
+other:
+  config.yaml: |
+    b: 2
+    a: 1
+`
+
+	var output bytes.Buffer
+	if err := Normalize(strings.NewReader(input), &output, Options{EmbeddedPaths: []string{"data.*"}}); err != nil {
+		t.Fatalf("Normalize failed: %v", err)
+	}
+
+	if got := output.String(); got != expected {
+		t.Errorf("Normalize() = %q, want %q", got, expected)
+	}
+}
+
+func TestNormalize_EmbeddedYAMLStrict(t *testing.T) {
+	t.Parallel()
+
+	input := `data:
+  notes.txt: just some text
+`
+
+	var output bytes.Buffer
+	err := Normalize(strings.NewReader(input), &output, Options{EmbeddedPaths: []string{"data.notes.txt"}, EmbeddedStrict: true})
+	if err == nil {
+		t.Fatal("Expected error for non-YAML embedded value, but got none")
+	}
+	if !strings.Contains(err.Error(), "data.notes.txt") {
+		t.Errorf("Expected error to name the path, got: %v", err)
+	}
+}
+
+func TestMatchPath(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		pattern string
+		path    []string
+		want    bool
+	}{
+		{pattern: "data.*", path: []string{"data", "config.yaml"}, want: true},
+		{pattern: "data.config.yaml", path: []string{"data", "config.yaml"}, want: true},
+		{pattern: "data.config", path: []string{"data", "config.yaml"}, want: false},
+		{pattern: "data.*", path: []string{"data"}, want: false},
+		{pattern: "data.*", path: []string{"data", "a", "b"}, want: false},
+		{pattern: "spec.*.name", path: []string{"spec", "0", "name"}, want: true},
+		{pattern: "*", path: []string{"metadata"}, want: true},
+	}
+
+	for _, tt := range tests {
+		if got := matchPath(tt.pattern, tt.path); got != tt.want {
+			t.Errorf("matchPath(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestNormalize_TypeStats(t *testing.T) {
+	t.Parallel()
+
+	input := `name: web
+replicas: 3
+ratio: 0.5
+enabled: true
+owner: null
+created: 2024-01-01
+ports:
+  - 80
+  - 443
+---
+- a
+- {b: c}
+`
+
+	expected := TypeCounts{
+		// Keys are strings too
+		Str:       11,
+		Int:       3,
+		Float:     1,
+		Bool:      1,
+		Null:      1,
+		Other:     1,
+		Mappings:  2,
+		Sequences: 2,
+	}
+
+	stats := new(TypeStats)
+	if err := Normalize(strings.NewReader(input), io.Discard, Options{TypeStats: stats}); err != nil {
+		t.Fatalf("Normalize failed: %v", err)
+	}
+
+	if got := stats.Counts(); got != expected {
+		t.Errorf("Counts() = %+v, want %+v", got, expected)
+	}
+}
+
+func TestNormalize_Atomic(t *testing.T) {
+	t.Parallel()
+
+	input := `first: valid
+---
+second: valid
+---
+  third: invalid
+    very: bad
+`
+
+	t.Run("streaming writes earlier documents", func(t *testing.T) {
+		t.Parallel()
+
+		var output bytes.Buffer
+		if err := Normalize(strings.NewReader(input), &output, Options{}); err == nil {
+			t.Fatal("Expected error but got none")
+		}
+		if output.Len() == 0 {
+			t.Error("Expected partial output without Atomic")
+		}
+	})
+
+	t.Run("atomic writes nothing", func(t *testing.T) {
+		t.Parallel()
+
+		var output bytes.Buffer
+		if err := Normalize(strings.NewReader(input), &output, Options{Atomic: true}); err == nil {
+			t.Fatal("Expected error but got none")
+		}
+		if output.Len() != 0 {
+			t.Errorf("Expected no output with Atomic, got %q", output.String())
+		}
+	})
+}
+
+func TestNormalize_TrimScalars(t *testing.T) {
+	t.Parallel()
+
+	input := `quoted: "  x  "
+" spaced key ": value
+items:
+  - "  y"
+  - '	z	'
+literal: |
+  indented
+    text
+number: " 42 "
+`
+
+	tests := []struct {
+		name     string
+		opts     Options
+		expected string
+	}{
+		{
+			name: "disabled",
+			opts: Options{},
+			expected: `' spaced key ': value
+items:
+  - '  y'
+  - "\tz\t"
+literal: |
+  indented
+    text
+number: ' 42 '
+quoted: '  x  '
+`,
+		},
+		{
+			name: "enabled",
+			opts: Options{TrimScalars: true},
+			expected: `' spaced key ': value
+items:
+  - y
+  - z
+literal: |
+  indented
+    text
+number: "42"
+quoted: x
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var output bytes.Buffer
+			if err := Normalize(strings.NewReader(input), &output, tt.opts); err != nil {
+				t.Fatalf("Normalize failed: %v", err)
+			}
+
+			if got := output.String(); got != tt.expected {
+				t.Errorf("Normalize() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+// largeMultiDocument generates a multi-document stream of unsorted manifests
+func largeMultiDocument(docs int) string {
+	var sb strings.Builder
+	for i := range docs {
+		if i > 0 {
+			sb.WriteString("---\n")
+		}
+		fmt.Fprintf(&sb, `# document %d
+spec:
+  template:
+    spec:
+      containers:
+        - name: app-%d
+          image: "example.com/app:%d"
+          env:
+            - {name: B, value: "2"}
+            - {name: A, value: "1"}
+          ports: [80, 443]
+  replicas: %d
+metadata:
+  name: app-%d
+  labels: {tier: backend, app: app-%d}
+kind: Deployment
+apiVersion: apps/v1
+`, i, i, i, i%5, i, i)
+	}
+	return sb.String()
+}
+
+func TestNormalize_DocumentWorkers(t *testing.T) {
+	t.Parallel()
+
+	inputs := []string{
+		largeMultiDocument(50),
+		"# header\n---\nb: 1\na: 2\n---\n---\n- z\n...\n---\nc: 3\n",
+		"",
+	}
+
+	for i, input := range inputs {
+		var sequential, parallel bytes.Buffer
+		if err := Normalize(strings.NewReader(input), &sequential, Options{PreserveComments: true}); err != nil {
+			t.Fatalf("input %d: sequential Normalize failed: %v", i, err)
+		}
+		if err := Normalize(strings.NewReader(input), &parallel, Options{PreserveComments: true, DocumentWorkers: 4}); err != nil {
+			t.Fatalf("input %d: parallel Normalize failed: %v", i, err)
+		}
+
+		if sequential.String() != parallel.String() {
+			t.Errorf("input %d: parallel output %q differs from sequential output %q", i, parallel.String(), sequential.String())
+		}
+	}
+
+	var output bytes.Buffer
+	err := Normalize(strings.NewReader("a: 1\n---\n  b: bad\n    c: worse\n"), &output, Options{DocumentWorkers: 4})
+	if err == nil {
+		t.Error("Expected error for malformed document, but got none")
+	}
+}
+
+func BenchmarkNormalize_DocumentWorkers(b *testing.B) {
+	input := largeMultiDocument(2000)
+
+	var expected bytes.Buffer
+	if err := Normalize(strings.NewReader(input), &expected, Options{}); err != nil {
+		b.Fatalf("Normalize failed: %v", err)
+	}
+
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("workers_%d", workers), func(b *testing.B) {
+			opts := Options{DocumentWorkers: workers}
+
+			var output bytes.Buffer
+			if err := Normalize(strings.NewReader(input), &output, opts); err != nil {
+				b.Fatalf("Normalize failed: %v", err)
+			}
+			if !bytes.Equal(output.Bytes(), expected.Bytes()) {
+				b.Fatal("parallel output differs from sequential output")
+			}
+
+			b.SetBytes(int64(len(input)))
+			b.ResetTimer()
+			for b.Loop() {
+				output.Reset()
+				if err := Normalize(strings.NewReader(input), &output, opts); err != nil {
+					b.Fatalf("Normalize failed: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestNormalize_StrictAnchors(t *testing.T) {
+	t.Parallel()
+
+	input := `first: &shared
+  a: 1
+second: &shared
+  b: 2
+third: *shared
+`
+
+	var output bytes.Buffer
+	if err := Normalize(strings.NewReader(input), &output, Options{}); err != nil {
+		t.Fatalf("Expected duplicate anchors to be allowed by default, got: %v", err)
+	}
+
+	for _, opts := range []Options{
+		{StrictAnchors: true},
+		{StrictAnchors: true, DocumentWorkers: 2},
+	} {
+		output.Reset()
+		err := Normalize(strings.NewReader("a: &x 1\n---\n"+input), &output, opts)
+		if err == nil {
+			t.Fatal("Expected error for duplicate anchor, but got none")
+		}
+
+		want := `anchor "shared" defined at line 3, column 8 is redefined at line 5, column 9`
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error containing %q, got: %v", want, err)
+		}
+	}
+
+	output.Reset()
+	if err := Normalize(strings.NewReader("a: &x 1\n---\nb: &x 2\n"), &output, Options{StrictAnchors: true}); err != nil {
+		t.Errorf("Expected anchors in separate documents to be allowed, got: %v", err)
+	}
+}
+
+func TestKeyPaths(t *testing.T) {
+	t.Parallel()
+
+	input := `spec:
+  replicas: 3
+  containers:
+    - name: app
+      image: nginx
+  volumes: []
+metadata:
+  name: web
+---
+other: value
+`
+
+	expected := [][]string{
+		{"metadata", "name"},
+		{"spec", "containers", "0", "image"},
+		{"spec", "containers", "0", "name"},
+		{"spec", "replicas"},
+		{"spec", "volumes"},
+		{"other"},
+	}
+
+	paths, err := KeyPaths(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("KeyPaths failed: %v", err)
+	}
+
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("KeyPaths() = %q, want %q", paths, expected)
+	}
+
+	if _, err := KeyPaths(strings.NewReader("key: value\n  invalid: indentation\n")); err == nil {
+		t.Error("Expected error for invalid YAML, but got none")
+	}
+}
+
+func TestNormalize_ForceBlockSequences(t *testing.T) {
+	t.Parallel()
+
+	input := `ports: [80, 443]
+empty: []
+nested: [[1, 2], {a: [3]}]
+`
+
+	expected := `empty: []
+nested:
+  - - 1
+    - 2
+  - a:
+      - 3
+ports:
+  - 80
+  - 443
+`
+
+	var output bytes.Buffer
+	if err := Normalize(strings.NewReader(input), &output, Options{ForceBlockSequences: true}); err != nil {
+		t.Fatalf("Normalize failed: %v", err)
+	}
+
+	if got := output.String(); got != expected {
+		t.Errorf("Normalize() = %q, want %q", got, expected)
+	}
+}
+
+func TestNormalize_KeyQuoteStyle(t *testing.T) {
+	t.Parallel()
+
+	input := `"weird: key": 1
+'#comment-like': 2
+plain: 3
+"123": 4
+`
+
+	tests := []struct {
+		name     string
+		style    yaml.Style
+		expected string
+	}{
+		{
+			name:  "default",
+			style: 0,
+			expected: `'#comment-like': 2
+"123": 4
+plain: 3
+'weird: key': 1
+`,
+		},
+		{
+			name:  "double",
+			style: yaml.DoubleQuotedStyle,
+			expected: `"#comment-like": 2
+"123": 4
+plain: 3
+"weird: key": 1
+`,
+		},
+		{
+			name:  "single",
+			style: yaml.SingleQuotedStyle,
+			expected: `'#comment-like': 2
+'123': 4
+plain: 3
+'weird: key': 1
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var output bytes.Buffer
+			if err := Normalize(strings.NewReader(input), &output, Options{KeyQuoteStyle: tt.style}); err != nil {
+				t.Fatalf("Normalize failed: %v", err)
+			}
+
+			if got := output.String(); got != tt.expected {
+				t.Errorf("Normalize() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestNormalize_TagHandlers(t *testing.T) {
+	t.Parallel()
+
+	input := `greeting: !upper hello
+secret: !secret db-password
+nested:
+  - !upper world
+`
+
+	expected := `greeting: !upper HELLO
+nested:
+  - !upper WORLD
+secret: !secret db-password
+`
+
+	opts := Options{
+		TagHandlers: map[string]func(*yaml.Node) error{
+			"!upper": func(node *yaml.Node) error {
+				if node.Kind != yaml.ScalarNode {
+					return errors.New("!upper only applies to scalars")
+				}
+				node.Value = strings.ToUpper(node.Value)
+				return nil
+			},
+		},
+	}
+
+	var output bytes.Buffer
+	if err := Normalize(strings.NewReader(input), &output, opts); err != nil {
+		t.Fatalf("Normalize failed: %v", err)
+	}
+
+	if got := output.String(); got != expected {
+		t.Errorf("Normalize() = %q, want %q", got, expected)
+	}
+
+	output.Reset()
+	err := Normalize(strings.NewReader("key: !upper [a]\n"), &output, opts)
+	if err == nil || !strings.Contains(err.Error(), "!upper only applies to scalars") {
+		t.Errorf("Expected handler error, got: %v", err)
+	}
+}
+
+func TestNormalize_TagHandlerContentIsNormalized(t *testing.T) {
+	t.Parallel()
+
+	// A handler that inlines content, as an !include resolver would
+	opts := Options{
+		TagHandlers: map[string]func(*yaml.Node) error{
+			"!include": func(node *yaml.Node) error {
+				var included yaml.Node
+				if err := yaml.Unmarshal([]byte("z: 1\na: {c: 3, b: 2}\n"), &included); err != nil {
+					return err
+				}
+				*node = *included.Content[0]
+				return nil
+			},
+		},
+	}
+
+	expected := `config:
+  a:
+    b: 2
+    c: 3
+  z: 1
+`
+
+	var output bytes.Buffer
+	if err := Normalize(strings.NewReader("config: !include other.yaml\n"), &output, opts); err != nil {
+		t.Fatalf("Normalize failed: %v", err)
+	}
+
+	if got := output.String(); got != expected {
+		t.Errorf("Normalize() = %q, want %q", got, expected)
+	}
+}
+
+func TestNormalizeFile_SkipUnchanged(t *testing.T) {
+	t.Parallel()
+
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	tests := []struct {
+		name    string
+		content string
+		changed bool
+	}{
+		{
+			name:    "small normalized",
+			content: "a: 1\nb: 2\n",
+		},
+		{
+			name:    "small unnormalized",
+			content: "b: 2\na: 1\n",
+			changed: true,
+		},
+		{
+			name:    "large normalized",
+			content: largeNormalizedDocument(),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			filename := filepath.Join(t.TempDir(), "test.yaml")
+			if err := os.WriteFile(filename, []byte(tt.content), 0644); err != nil {
+				t.Fatalf("Failed to write test file: %v", err)
+			}
+			if err := os.Chtimes(filename, past, past); err != nil {
+				t.Fatalf("Failed to set file times: %v", err)
+			}
+
+			if err := NormalizeFile(filename, Options{SkipUnchanged: true}); err != nil {
+				t.Fatalf("NormalizeFile failed: %v", err)
+			}
+
+			info, err := os.Stat(filename)
+			if err != nil {
+				t.Fatalf("Failed to stat file: %v", err)
+			}
+			if modified := !info.ModTime().Equal(past); modified != tt.changed {
+				t.Errorf("file modified = %v, want %v", modified, tt.changed)
+			}
+
+			entries, err := os.ReadDir(filepath.Dir(filename))
+			if err != nil {
+				t.Fatalf("Failed to read directory: %v", err)
+			}
+			if len(entries) != 1 {
+				t.Errorf("Expected no temporary files to be left behind, got %d entries", len(entries))
+			}
+		})
+	}
+}
+
+// largeNormalizedDocument returns an already-normalized document over the
+// threshold for streaming file normalization
+func largeNormalizedDocument() string {
+	var sb strings.Builder
+	for i := 0; sb.Len() <= 2*1024*1024; i++ {
+		fmt.Fprintf(&sb, "key%07d: value %d\n", i, i)
+	}
+	return sb.String()
+}
+
+func TestNormalize_MixedKeyOrder(t *testing.T) {
+	t.Parallel()
+
+	input := `b: string
+10: ten
+a: string
+1.5: one and a half
+2: two
+1: one
+`
+
+	tests := []struct {
+		name     string
+		order    MixedKeyOrder
+		expected string
+	}{
+		{
+			name:  "by kind",
+			order: MixedKeysByKind,
+			expected: `1: one
+2: two
+10: ten
+1.5: one and a half
+a: string
+b: string
+`,
+		},
+		{
+			name:  "numbers first",
+			order: MixedKeysNumbersFirst,
+			expected: `1: one
+1.5: one and a half
+2: two
+10: ten
+a: string
+b: string
+`,
+		},
+		{
+			name:  "strings first",
+			order: MixedKeysStringsFirst,
+			expected: `a: string
+b: string
+1: one
+1.5: one and a half
+2: two
+10: ten
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var output bytes.Buffer
+			if err := Normalize(strings.NewReader(input), &output, Options{MixedKeyOrder: tt.order}); err != nil {
+				t.Fatalf("Normalize failed: %v", err)
+			}
+
+			if got := output.String(); got != tt.expected {
+				t.Errorf("Normalize() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestNormalize_DocumentSeparator(t *testing.T) {
+	t.Parallel()
+
+	input := `b: 2
+a: 1
+%%%% next
+d: 4
+c: 3
+---
+f: 6
+e: 5
+%%%% last
+`
+
+	expected := `a: 1
+b: 2
+---
+c: 3
+d: 4
+---
+e: 5
+f: 6
+`
+
+	opts := Options{DocumentSeparator: regexp.MustCompile(`^%%%% \w+$`)}
+
+	var output bytes.Buffer
+	if err := Normalize(strings.NewReader(input), &output, opts); err != nil {
+		t.Fatalf("Normalize failed: %v", err)
+	}
+
+	if got := output.String(); got != expected {
+		t.Errorf("Normalize() = %q, want %q", got, expected)
+	}
+
+	output.Reset()
+	opts.StrictAnchors = true
+	err := Normalize(strings.NewReader("a: 1\n%%%% next\nb: &x 1\nc: &x 2\n"), &output, opts)
+	if err == nil || !strings.Contains(err.Error(), "line 3") {
+		t.Errorf("Expected error reporting line 3, got: %v", err)
+	}
+}
+
+func TestNormalize_PreserveUnchanged(t *testing.T) {
+	t.Parallel()
+
+	canonical := `---
+apiVersion: v1
+kind: Service
+spec:
+  ports: [80, 443]
+  selector: {app: "web"}
+`
+
+	input := `kind: Deployment
+apiVersion: apps/v1
+` + canonical + `---
+# comment
+a: 1
+`
+
+	tests := []struct {
+		name     string
+		opts     Options
+		expected string
+	}{
+		{
+			name: "comments stripped",
+			opts: Options{PreserveUnchanged: true},
+			expected: `apiVersion: apps/v1
+kind: Deployment
+` + canonical + `---
+a: 1
+`,
+		},
+		{
+			name: "comments preserved",
+			opts: Options{PreserveUnchanged: true, PreserveComments: true},
+			expected: `apiVersion: apps/v1
+kind: Deployment
+` + canonical + `---
+# comment
+a: 1
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var output bytes.Buffer
+			if err := Normalize(strings.NewReader(input), &output, tt.opts); err != nil {
+				t.Fatalf("Normalize failed: %v", err)
+			}
+
+			if got := output.String(); got != tt.expected {
+				t.Errorf("Normalize() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestNormalize_Dotenv(t *testing.T) {
+	t.Parallel()
+
+	input := `kind: ConfigMap
+data:
+  app.env: |
+    PORT=8080
+    # database connection
+    DB_HOST=db
+    export LOG_LEVEL=info
+
+    PORT=9090
+    API_KEY=abc=123
+  inline.env: "B=2\nA=1\n"
+`
+
+	expected := `data:
+  app.env: |
+    API_KEY=abc=123
+    # database connection
+    DB_HOST=db
+    export LOG_LEVEL=info
+    PORT=9090
+  inline.env: |
+    A=1
+    B=2
+kind: ConfigMap
+`
+
+	var output bytes.Buffer
+	if err := Normalize(strings.NewReader(input), &output, Options{DotenvPaths: []string{"data.*"}}); err != nil {
+		t.Fatalf("Normalize failed: %v", err)
+	}
+
+	if got := output.String(); got != expected {
+		t.Errorf("Normalize() = %q, want %q", got, expected)
+	}
+}
+
+func TestNormalize_DotenvWithEmbedded(t *testing.T) {
+	t.Parallel()
+
+	input := `kind: ConfigMap
+data:
+  env: |
+    PORT=8080
+    HOST=localhost
+  config.yaml: |
+    b: 2
+    a: 1
+`
+
+	expected := `data:
+  config.yaml: |
+    a: 1
+    b: 2
+  env: |
+    HOST=localhost
+    PORT=8080
+kind: ConfigMap
+`
+
+	opts := Options{EmbeddedPaths: []string{"data.*"}, EmbeddedStrict: true, DotenvPaths: []string{"data.env"}}
+	var output bytes.Buffer
+	if err := Normalize(strings.NewReader(input), &output, opts); err != nil {
+		t.Fatalf("Normalize failed: %v", err)
+	}
+
+	if got := output.String(); got != expected {
+		t.Errorf("Normalize() = %q, want %q", got, expected)
+	}
+}
+
+func TestNormalize_MaxLineLength(t *testing.T) {
+	t.Parallel()
+
+	long := strings.Repeat("x", 40)
+	input := "b: " + long + "\na: short\n---\nc: héllo\n"
+	expected := "a: short\nb: " + long + "\n---\nc: héllo\n"
+
+	t.Run("warn", func(t *testing.T) {
+		t.Parallel()
+
+		var warnings []Warning
+		opts := Options{
+			MaxLineLength: 8,
+			Warn:          func(w Warning) { warnings = append(warnings, w) },
+		}
+
+		var output bytes.Buffer
+		if err := Normalize(strings.NewReader(input), &output, opts.WithFile("values.yaml")); err != nil {
+			t.Fatalf("Normalize failed: %v", err)
+		}
+		if got := output.String(); got != expected {
+			t.Errorf("Normalize() = %q, want %q", got, expected)
+		}
+
+		want := []Warning{{File: "values.yaml", Line: 2, Message: "line is 43 characters long, exceeding the maximum of 8"}}
+		if !reflect.DeepEqual(warnings, want) {
+			t.Errorf("warnings = %+v, want %+v", warnings, want)
+		}
+	})
+
+	t.Run("strict", func(t *testing.T) {
+		t.Parallel()
+
+		var output bytes.Buffer
+		err := Normalize(strings.NewReader(input), &output, Options{MaxLineLength: 8, Strict: true})
+
+		var w Warning
+		if !errors.As(err, &w) || w.Line != 2 {
+			t.Fatalf("expected warning error for line 2, got: %v", err)
+		}
+		if output.Len() != 0 {
+			t.Errorf("expected no output, got %q", output.String())
+		}
+	})
+}
+
+func TestNormalize_PreserveCommentsFollowKeys(t *testing.T) {
+	t.Parallel()
+
+	input := `# Helm values for the app
+
+replicas: 3 # TODO: lower after load test
+# DO NOT EDIT: managed by the platform team
+image:
+  tag: v1.2.3 # TODO: pin digest
+  # TODO: switch registry
+  repository: example/app
+# TODO: enable once ready
+autoscaling:
+  enabled: false
+`
+
+	expected := `# Helm values for the app
+
+# TODO: enable once ready
+autoscaling:
+  enabled: false
+# DO NOT EDIT: managed by the platform team
+image:
+  # TODO: switch registry
+  repository: example/app
+  tag: v1.2.3 # TODO: pin digest
+replicas: 3 # TODO: lower after load test
+`
+
+	var output bytes.Buffer
+	if err := Normalize(strings.NewReader(input), &output, Options{PreserveComments: true}); err != nil {
+		t.Fatalf("Normalize failed: %v", err)
+	}
+
+	if got := output.String(); got != expected {
+		t.Errorf("Normalize() = %q, want %q", got, expected)
+	}
+}
+
+// largeNestedDocument returns a document of nested mappings, depth levels
+// deep with width keys at each level, in reverse order. Every other level
+// mixes integer and string keys.
+func largeNestedDocument(depth, width int) string {
+	var sb strings.Builder
+	var write func(level int)
+	write = func(level int) {
+		indent := strings.Repeat("  ", level)
+		for i := width - 1; i >= 0; i-- {
+			if level%2 == 1 && i%2 == 0 {
+				fmt.Fprintf(&sb, "%s%d:", indent, i)
+			} else {
+				fmt.Fprintf(&sb, "%skey%d:", indent, i)
+			}
+			if level+1 < depth {
+				sb.WriteString("\n")
+				write(level + 1)
+			} else {
+				fmt.Fprintf(&sb, " value%d\n", i)
+			}
+		}
+	}
+	write(0)
+	return sb.String()
+}
+
+func TestNormalize_StableMixedKeys(t *testing.T) {
+	t.Parallel()
+
+	// null and ~ are equal keys, so they keep their original order
+	input := "b: 1\nnull: first\n2: x\n~: second\ntrue: t\n"
+	expected := "null: first\n~: second\ntrue: t\n2: x\nb: 1\n"
+
+	var output bytes.Buffer
+	if err := Normalize(strings.NewReader(input), &output, Options{}); err != nil {
+		t.Fatalf("Normalize failed: %v", err)
+	}
+
+	if got := output.String(); got != expected {
+		t.Errorf("Normalize() = %q, want %q", got, expected)
+	}
+}
+
+func BenchmarkNormalize_LargeNested(b *testing.B) {
+	input := largeNestedDocument(5, 8)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(input)))
+	var output bytes.Buffer
+	for b.Loop() {
+		output.Reset()
+		if err := Normalize(strings.NewReader(input), &output, Options{}); err != nil {
+			b.Fatalf("Normalize failed: %v", err)
+		}
+	}
+}
+
+func TestNormalize_JSONLines(t *testing.T) {
+	t.Parallel()
+
+	input := `kind: Service
+metadata:
+  name: web
+  labels: {b: "2", a: "1"}
+---
+items:
+  - count: 3
+    enabled: true
+  - &shared {path: "/a<b>", ratio: 0.5}
+  - *shared
+---
+2: two
+10: ten
+1: null
+`
+
+	expected := []string{
+		`{"kind":"Service","metadata":{"labels":{"a":"1","b":"2"},"name":"web"}}`,
+		`{"items":[{"count":3,"enabled":true},{"path":"/a<b>","ratio":0.5},{"path":"/a<b>","ratio":0.5}]}`,
+		`{"1":null,"2":"two","10":"ten"}`,
+	}
+
+	for _, opts := range []Options{
+		{Format: FormatJSONLines},
+		{Format: FormatJSONLines, DocumentWorkers: 2},
+	} {
+		var output bytes.Buffer
+		if err := Normalize(strings.NewReader(input), &output, opts); err != nil {
+			t.Fatalf("Normalize failed with %+v: %v", opts, err)
+		}
+
+		lines := strings.Split(strings.TrimSuffix(output.String(), "\n"), "\n")
+		if len(lines) != len(expected) {
+			t.Fatalf("expected %d lines, got %d: %q", len(expected), len(lines), output.String())
+		}
+		for i, line := range lines {
+			if !json.Valid([]byte(line)) {
+				t.Errorf("line %d is not valid JSON: %q", i+1, line)
+			}
+			if line != expected[i] {
+				t.Errorf("line %d = %q, want %q", i+1, line, expected[i])
+			}
+		}
+	}
+
+	// JSON object keys are always strings, so integer keys don't survive
+	var output bytes.Buffer
+	if err := Normalize(strings.NewReader(input), &output, Options{Format: FormatJSONLines, VerifyEqual: true}); err == nil {
+		t.Error("expected verification to fail for integer keys")
+	}
+	first, _, _ := strings.Cut(input, "---\n")
+	if err := Normalize(strings.NewReader(first), &output, Options{Format: FormatJSONLines, VerifyEqual: true}); err != nil {
+		t.Errorf("expected verification to pass, got: %v", err)
+	}
+}
+
+func TestNormalize_JSONLinesVerifyEqual(t *testing.T) {
+	t.Parallel()
+
+	input := `f: 1.0
+t: 2001-12-14t21:59:43.10-05:00
+d: 2002-12-14
+n: [1, 2.5e3, -0.0]
+`
+
+	expected := `{"d":"2002-12-14T00:00:00Z","f":1,"n":[1,2500,-0],"t":"2001-12-14T21:59:43.1-05:00"}` + "\n"
+
+	var output bytes.Buffer
+	if err := Normalize(strings.NewReader(input), &output, Options{Format: FormatJSONLines, VerifyEqual: true}); err != nil {
+		t.Fatalf("Normalize failed: %v", err)
+	}
+	if got := output.String(); got != expected {
+		t.Errorf("Normalize() = %q, want %q", got, expected)
+	}
+
+	if err := verifyEqual([]byte(input), []byte(strings.Replace(expected, `"f":1`, `"f":2`, 1)), FormatJSONLines); err == nil {
+		t.Error("Expected verification error for changed value, but got none")
+	}
+}
+
+func TestNormalize_JSONLinesMergeKeys(t *testing.T) {
+	t.Parallel()
+
+	input := `base: &base {a: 1, b: 2}
+derived:
+  <<: *base
+  c: 4
+  b: 3
+`
+
+	// Merged keys follow the mapping's own keys
+	expected := `{"base":{"a":1,"b":2},"derived":{"b":3,"c":4,"a":1}}` + "\n"
+
+	for _, opts := range []Options{
+		{Format: FormatJSONLines, VerifyEqual: true},
+		{Format: FormatJSONLines, DocumentWorkers: 2},
+	} {
+		var output bytes.Buffer
+		if err := Normalize(strings.NewReader(input), &output, opts); err != nil {
+			t.Fatalf("Normalize failed with %+v: %v", opts, err)
+		}
+		if got := output.String(); got != expected {
+			t.Errorf("Normalize() = %q, want %q", got, expected)
+		}
+	}
+}
+
+func TestNormalize_StrictText(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		input   string
+		wantErr string
+	}{
+		{name: "valid", input: "b: \"tab\\there\"\r\na: héllo\t# comment\n"},
+		{name: "control character", input: "a: 1\nb: x\x07y\n", wantErr: "control character U+0007 at byte offset 9"},
+		{name: "escape character in comment", input: "a: 1 # \x1b[31m\n", wantErr: "control character U+001B at byte offset 7"},
+		{name: "invalid UTF-8", input: "a: caf\xe9\n", wantErr: "invalid UTF-8 at byte offset 6"},
+		{name: "truncated rune", input: "a: caf\xc3", wantErr: "invalid UTF-8 at byte offset 6"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			for _, opts := range []Options{{StrictText: true}, {StrictText: true, DocumentWorkers: 2}} {
+				// Read a byte at a time so that runes are split across reads
+				r := iotest.OneByteReader(strings.NewReader(tt.input))
+
+				var output bytes.Buffer
+				err := Normalize(r, &output, opts)
+				if tt.wantErr == "" {
+					if err != nil {
+						t.Errorf("expected no error, got: %v", err)
+					}
+					continue
+				}
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("expected error %q, got: %v", tt.wantErr, err)
+				}
+			}
+		})
+	}
+}
+
+// expandAliases replaces every alias in node with a copy of the node it
+// refers to, and removes anchors, keeping the order of all content.
+func expandAliases(node *yaml.Node) *yaml.Node {
+	if node.Kind == yaml.AliasNode {
+		return expandAliases(node.Alias)
+	}
+	expanded := *node
+	expanded.Anchor = ""
+	expanded.Content = nil
+	for _, child := range node.Content {
+		expanded.Content = append(expanded.Content, expandAliases(child))
+	}
+	return &expanded
+}
+
+func TestNormalize_AliasesCommuteWithExpansion(t *testing.T) {
+	t.Parallel()
+
+	input := `defaults: &defaults
+  timeout: 30
+  retries: 3
+  env: &env {ZONE: b, REGION: a}
+ports: &ports [{target: 80, published: 8080}]
+services:
+  web:
+    <<: *defaults
+    name: web
+    ports: *ports
+  worker:
+    settings: *defaults
+    name: worker
+extra:
+  env: *env
+`
+
+	expand := func(t *testing.T, data string) string {
+		t.Helper()
+
+		var node yaml.Node
+		if err := yaml.Unmarshal([]byte(data), &node); err != nil {
+			t.Fatalf("failed to decode YAML: %v", err)
+		}
+		out, err := yaml.Marshal(expandAliases(&node))
+		if err != nil {
+			t.Fatalf("failed to encode YAML: %v", err)
+		}
+		return string(out)
+	}
+	normalize := func(t *testing.T, data string) string {
+		t.Helper()
+
+		var output bytes.Buffer
+		if err := Normalize(strings.NewReader(data), &output, Options{}); err != nil {
+			t.Fatalf("Normalize failed: %v", err)
+		}
+		return output.String()
+	}
+
+	normalizedFirst := normalize(t, expand(t, normalize(t, input)))
+	expandedFirst := normalize(t, expand(t, input))
+
+	if normalizedFirst != expandedFirst {
+		t.Errorf("normalizing then expanding gave %q, but expanding then normalizing gave %q", normalizedFirst, expandedFirst)
+	}
+}
+
+func TestNormalize_LastKeys(t *testing.T) {
+	t.Parallel()
+
+	input := `children:
+  - name: leaf
+    children: []
+    id: 2
+name: root
+items: [a]
+id: 1
+1: one
+`
+
+	expected := `1: one
+id: 1
+name: root
+children:
+  - id: 2
+    name: leaf
+    children: []
+items:
+  - a
+`
+
+	var output bytes.Buffer
+	if err := Normalize(strings.NewReader(input), &output, Options{LastKeys: []string{"children", "items"}}); err != nil {
+		t.Fatalf("Normalize failed: %v", err)
+	}
+
+	if got := output.String(); got != expected {
+		t.Errorf("Normalize() = %q, want %q", got, expected)
+	}
+}
+
+func TestNormalize_WarnVersionFloats(t *testing.T) {
+	t.Parallel()
+
+	input := `name: app
+version: 1.10
+python: 3.0
+ratio: 1.5
+quoted: "1.10"
+tagged: !!float 2.50
+---
+go: 1.20
+`
+
+	var warnings []Warning
+	opts := Options{
+		WarnVersionFloats: true,
+		Warn:              func(w Warning) { warnings = append(warnings, w) },
+	}
+
+	var output bytes.Buffer
+	if err := Normalize(strings.NewReader(input), &output, opts); err != nil {
+		t.Fatalf("Normalize failed: %v", err)
+	}
+
+	want := []Warning{
+		{Line: 2, Message: "1.10 is a float and will be read as 1.1; quote it if it is a version"},
+		{Line: 3, Message: "3.0 is a float and will be read as 3; quote it if it is a version"},
+		{Line: 8, Message: "1.20 is a float and will be read as 1.2; quote it if it is a version"},
+	}
+	if !reflect.DeepEqual(warnings, want) {
+		t.Errorf("warnings = %+v, want %+v", warnings, want)
+	}
+}
+
+func TestNormalize_ReportQuoteInconsistency(t *testing.T) {
+	t.Parallel()
+
+	input := `name: web
+image: nginx
+tier: "frontend"
+enabled: "true"
+version: '1.10'
+note: |
+  block scalars don't count
+---
+a: "x"
+b: "y"
+c: 'z'
+---
+a: plain
+b: "needs: quoting"
+`
+
+	var warnings []Warning
+	opts := Options{
+		ReportQuoteInconsistency: true,
+		Warn:                     func(w Warning) { warnings = append(warnings, w) },
+	}
+
+	var output bytes.Buffer
+	if err := Normalize(strings.NewReader(input), &output, opts); err != nil {
+		t.Fatalf("Normalize failed: %v", err)
+	}
+
+	want := []Warning{
+		{Line: 3, Message: "string is double-quoted, but most strings in the document are plain (2 plain, 0 single-quoted, 1 double-quoted)"},
+		{Line: 11, Message: "string is single-quoted, but most strings in the document are double-quoted (0 plain, 1 single-quoted, 2 double-quoted)"},
+	}
+	if !reflect.DeepEqual(warnings, want) {
+		t.Errorf("warnings = %+v, want %+v", warnings, want)
+	}
+}
+
+func TestNormalize_PreserveValueKeys(t *testing.T) {
+	t.Parallel()
+
+	// Indented further than the default, which would be changed if the value
+	// were encoded again
+	pem := `  tls.crt: |
+      -----BEGIN CERTIFICATE-----
+      MIIBszCCAVmgAwIBAgIUW4lTyHKVgJOvHbrsYnQAbY+/yFcwCgYIKoZIzj0EAwIw
+      DzENMAsGA1UEAwwEdGVzdDAeFw0yNDAxMDEwMDAwMDBaFw0zNDAxMDEwMDAwMDBa
+      -----END CERTIFICATE-----
+`
+	// Would be joined into a single line if encoded again
+	note := `  note: >-
+    Issued for testing,
+    not for production
+`
+
+	input := `kind: Secret
+stringData:
+` + pem + note + `  password: "  hunter2  "
+  token: '  abc  '
+metadata:
+  name: "tls"
+`
+
+	expected := `kind: Secret
+metadata:
+  name: tls
+stringData:
+` + note + `  password: "  hunter2  "
+` + pem + `  token: abc
+`
+
+	opts := Options{
+		TrimScalars:       true,
+		PreserveValueKeys: []string{"tls.crt", "note", "password"},
+	}
+
+	var output bytes.Buffer
+	if err := Normalize(strings.NewReader(input), &output, opts); err != nil {
+		t.Fatalf("Normalize failed: %v", err)
+	}
+
+	if got := output.String(); got != expected {
+		t.Errorf("Normalize() = %q, want %q", got, expected)
+	}
+	if !strings.Contains(output.String(), pem) {
+		t.Errorf("expected certificate to be preserved byte-for-byte in %q", output.String())
+	}
+}
+
+func TestNormalize_CompactSequenceIndent(t *testing.T) {
+	t.Parallel()
+
+	input := `spec:
+  containers:
+    - name: app
+      command: ["sh", "-c"]
+      ports:
+        - containerPort: 80
+  matrix: [[1, 2], []]
+`
+
+	expected := `spec:
+  containers:
+  - command:
+    - sh
+    - -c
+    name: app
+    ports:
+    - containerPort: 80
+  matrix:
+  - - 1
+    - 2
+  - []
+`
+
+	var output bytes.Buffer
+	opts := Options{ForceBlockSequences: true, CompactSequenceIndent: true}
+	if err := Normalize(strings.NewReader(input), &output, opts); err != nil {
+		t.Fatalf("Normalize failed: %v", err)
+	}
+
+	if got := output.String(); got != expected {
+		t.Errorf("Normalize() = %q, want %q", got, expected)
+	}
+}
+
+func TestNormalize_Explain(t *testing.T) {
+	t.Parallel()
+
+	input := `kind: Pod
+spec:
+  containers: [{name: app, image: "nginx"}]
+metadata:
+  name: web # the name
+`
+
+	explanation := new(Explanation)
+	var output bytes.Buffer
+	if err := Normalize(strings.NewReader(input), &output, Options{Explain: explanation}); err != nil {
+		t.Fatalf("Normalize failed: %v", err)
+	}
+
+	want := []Change{
+		{Line: 1, Path: ".", Description: "sorted keys"},
+		{Line: 3, Path: "spec.containers", Description: "reset flow style"},
+		{Line: 3, Path: "spec.containers.0", Description: "reset flow style"},
+		{Line: 3, Path: "spec.containers.0.image", Description: "reset double-quoted style"},
+		{Line: 3, Path: "spec.containers.0", Description: "sorted keys"},
+		{Line: 5, Path: "metadata.name", Description: "stripped comment"},
+	}
+	if got := explanation.Changes(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Changes() = %+v, want %+v", got, want)
+	}
+
+	var report bytes.Buffer
+	if err := explanation.WriteReport(&report); err != nil {
+		t.Fatalf("WriteReport failed: %v", err)
+	}
+	if !strings.Contains(report.String(), "#   line 1, .: sorted keys\n") {
+		t.Errorf("expected report to mention sorted keys, got %q", report.String())
+	}
+}
+
+func TestNormalize_RequireLF(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		input   string
+		wantErr string
+	}{
+		{name: "LF", input: "b: 1\na: 2\n"},
+		{name: "CRLF", input: "b: 1\na: 2\r\n", wantErr: "line 2 contains a carriage return, but only LF line endings are allowed"},
+		{name: "CRLF in block scalar", input: "a: |\n  x\n  y\r\n", wantErr: "line 3 contains a carriage return, but only LF line endings are allowed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var output bytes.Buffer
+			err := Normalize(strings.NewReader(tt.input), &output, Options{RequireLF: true})
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("expected no error, got: %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("expected error %q, got: %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestDiffFiles(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	write := func(name, content string) string {
+		filename := filepath.Join(tmpDir, name)
+		if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return filename
+	}
+
+	base := write("base.yaml", `kind: Deployment
+metadata: {name: web, labels: {app: web}}
+spec:
+  replicas: 2
+  template:
+    spec:
+      containers:
+        - name: web
+          image: "nginx:1.25"
+          ports: [{containerPort: 80}]
+`)
+	reformatted := write("reformatted.yaml", `# same content, different formatting
+spec:
+  template:
+    spec:
+      containers:
+      - image: nginx:1.25
+        name: web
+        ports:
+        - containerPort: 80
+  replicas: 2
+metadata:
+  labels:
+    app: web
+  name: web
+kind: Deployment
+`)
+	changed := write("changed.yaml", `kind: Deployment
+metadata: {name: web, labels: {app: web}}
+spec:
+  replicas: 3
+  template:
+    spec:
+      containers:
+        - name: web
+          image: "nginx:1.26"
+          ports: [{containerPort: 80}]
+`)
+
+	diff, err := DiffFiles(base, reformatted, Options{})
+	if err != nil {
+		t.Fatalf("DiffFiles failed: %v", err)
+	}
+	if diff != "" {
+		t.Errorf("expected no diff for formatting-only changes, got %q", diff)
+	}
+
+	diff, err = DiffFiles(base, changed, Options{})
+	if err != nil {
+		t.Fatalf("DiffFiles failed: %v", err)
+	}
+	expected := "--- " + base + "\n+++ " + changed + `
+@@ -4,11 +4,11 @@
+     app: web
+   name: web
+ spec:
+-  replicas: 2
++  replicas: 3
+   template:
+     spec:
+       containers:
+-        - image: nginx:1.25
++        - image: nginx:1.26
+           name: web
+           ports:
+             - containerPort: 80
+`
+	if diff != expected {
+		t.Errorf("DiffFiles() = %q, want %q", diff, expected)
+	}
+
+	if _, err := DiffFiles(base, filepath.Join(tmpDir, "missing.yaml"), Options{}); err == nil {
+		t.Error("expected error for missing file")
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	t.Parallel()
+
+	lines := func(n int) []string {
+		var l []string
+		for i := range n {
+			l = append(l, fmt.Sprintf("line %d\n", i+1))
+		}
+		return l
+	}
+
+	tests := []struct {
+		name     string
+		a, b     string
+		expected string
+	}{
+		{
+			name:     "equal",
+			a:        "a\nb\n",
+			b:        "a\nb\n",
+			expected: "",
+		},
+		{
+			name:     "from empty",
+			a:        "",
+			b:        "a\n",
+			expected: "--- a\n+++ b\n@@ -0,0 +1 @@\n+a\n",
+		},
+		{
+			name:     "missing final newline",
+			a:        "a\nb\n",
+			b:        "a\nb",
+			expected: "--- a\n+++ b\n@@ -1,2 +1,2 @@\n a\n-b\n+b\n\\ No newline at end of file\n",
+		},
+		{
+			name: "separate hunks",
+			a:    strings.Join(lines(20), ""),
+			b:    strings.Replace(strings.Replace(strings.Join(lines(20), ""), "line 2\n", "", 1), "line 18\n", "line 18\nnew\n", 1),
+			expected: "--- a\n+++ b\n@@ -1,5 +1,4 @@\n line 1\n-line 2\n line 3\n line 4\n line 5\n" +
+				"@@ -16,5 +15,6 @@\n line 16\n line 17\n line 18\n+new\n line 19\n line 20\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := UnifiedDiff("a", "b", []byte(tt.a), []byte(tt.b)); got != tt.expected {
+				t.Errorf("UnifiedDiff() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestNormalize_AnchorsFirst(t *testing.T) {
+	t.Parallel()
+
+	input := `defaults: &defaults
+  timeout: 30
+service:
+  name: web
+  zeta: &zeta {z: 1}
+  <<: *defaults
+  beta: 2
+  alpha: &alpha [a]
+  gamma: *zeta
+`
+
+	expected := `defaults: &defaults
+  timeout: 30
+service:
+  <<: *defaults
+  alpha: &alpha
+    - a
+  zeta: &zeta
+    z: 1
+  beta: 2
+  gamma: *zeta
+  name: web
+`
+
+	var output bytes.Buffer
+	if err := Normalize(strings.NewReader(input), &output, Options{AnchorsFirst: true}); err != nil {
+		t.Fatalf("Normalize failed: %v", err)
+	}
+
+	if got := output.String(); got != expected {
+		t.Errorf("Normalize() = %q, want %q", got, expected)
+	}
+}
+
+func TestNormalize_JSONWriter(t *testing.T) {
+	t.Parallel()
+
+	input := "b: 1\na: [x, y]\n---\nkind: Secret\n---\nc: {e: 2, d: 1}\n"
+	expectedYAML := "a:\n  - x\n  - y\nb: 1\n---\nkind: Secret\n---\nc:\n  d: 1\n  e: 2\n"
+	expectedJSON := "{\"a\":[\"x\",\"y\"],\"b\":1}\n{\"kind\":\"Secret\"}\n{\"c\":{\"d\":1,\"e\":2}}\n"
+
+	for _, opts := range []Options{{}, {DocumentWorkers: 3}, {Atomic: true}} {
+		var output, jsonOutput bytes.Buffer
+		opts.JSONWriter = &jsonOutput
+		if err := Normalize(strings.NewReader(input), &output, opts); err != nil {
+			t.Fatalf("Normalize failed with %+v: %v", opts, err)
+		}
+
+		if got := output.String(); got != expectedYAML {
+			t.Errorf("Normalize() = %q, want %q", got, expectedYAML)
+		}
+		if got := jsonOutput.String(); got != expectedJSON {
+			t.Errorf("JSON output = %q, want %q", got, expectedJSON)
+		}
+	}
+}
+
+func TestNormalize_PreserveMultilineHeadComments(t *testing.T) {
+	t.Parallel()
+
+	input := `zeta: 1
+# line one
+# line two
+# line three
+alpha:
+  b: 2
+  # inner one
+  # inner two
+  # inner three
+  a: 1
+mid: 3
+`
+
+	expected := `# line one
+# line two
+# line three
+alpha:
+  # inner one
+  # inner two
+  # inner three
+  a: 1
+  b: 2
+mid: 3
+zeta: 1
+`
+
+	var output bytes.Buffer
+	if err := Normalize(strings.NewReader(input), &output, Options{PreserveComments: true}); err != nil {
+		t.Fatalf("Normalize failed: %v", err)
+	}
+
+	if got := output.String(); got != expected {
+		t.Errorf("Normalize() = %q, want %q", got, expected)
+	}
+}
+
+func TestNormalize_PreserveOrderPaths(t *testing.T) {
+	t.Parallel()
+
+	input := `spec:
+  template:
+    spec:
+      volumes:
+        - name: data
+      containers:
+        - name: app
+          image: app:latest
+          env:
+            - value: "1"
+              name: B
+      restartPolicy: Always
+  replicas: 1
+kind: Deployment
+`
+
+	expected := `kind: Deployment
+spec:
+  replicas: 1
+  template:
+    spec:
+      containers:
+        - name: app
+          image: app:latest
+          env:
+            - value: "1"
+              name: B
+      restartPolicy: Always
+      volumes:
+        - name: data
+`
+
+	var output bytes.Buffer
+	opts := Options{PreserveOrderPaths: []string{"spec.template.spec.containers"}}
+	if err := Normalize(strings.NewReader(input), &output, opts); err != nil {
+		t.Fatalf("Normalize failed: %v", err)
+	}
+
+	if got := output.String(); got != expected {
+		t.Errorf("Normalize() = %q, want %q", got, expected)
+	}
+}
+
+func TestNormalize_IntKeyStyle(t *testing.T) {
+	t.Parallel()
+
+	input := "10: b\n1: a\n2: c\n"
+
+	testCases := []struct {
+		name     string
+		opts     Options
+		expected string
+	}{
+		{
+			name:     "bare",
+			opts:     Options{IntKeyStyle: IntKeysBare},
+			expected: "1: a\n2: c\n10: b\n",
+		},
+		{
+			name:     "quoted",
+			opts:     Options{IntKeyStyle: IntKeysQuoted},
+			expected: "\"1\": a\n\"2\": c\n\"10\": b\n",
+		},
+		{
+			name:     "quoted with key quote style",
+			opts:     Options{IntKeyStyle: IntKeysQuoted, KeyQuoteStyle: yaml.SingleQuotedStyle},
+			expected: "'1': a\n'2': c\n'10': b\n",
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var output bytes.Buffer
+			if err := Normalize(strings.NewReader(input), &output, tt.opts); err != nil {
+				t.Fatalf("Normalize failed: %v", err)
+			}
+
+			if got := output.String(); got != tt.expected {
+				t.Errorf("Normalize() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestNormalize_Indent(t *testing.T) {
+	t.Parallel()
+
+	input := `spec:
+  containers:
+  - name: app
+    ports:
+    - containerPort: 80
+      protocol: TCP
+    env: {B: "2", A: "1"}
+`
+
+	expected := `spec:
+    containers:
+        - env:
+            A: "1"
+            B: "2"
+          name: app
+          ports:
+            - containerPort: 80
+              protocol: TCP
+`
+
+	opts := Options{Indent: 4}
+
+	var output bytes.Buffer
+	if err := Normalize(strings.NewReader(input), &output, opts); err != nil {
+		t.Fatalf("Normalize failed: %v", err)
+	}
+	if got := output.String(); got != expected {
+		t.Errorf("Normalize() = %q, want %q", got, expected)
+	}
+
+	// Normalizing the output again should not change it
+	var again bytes.Buffer
+	if err := Normalize(strings.NewReader(output.String()), &again, opts); err != nil {
+		t.Fatalf("Normalize failed on its own output: %v", err)
+	}
+	if got := again.String(); got != expected {
+		t.Errorf("Normalize() = %q, want %q", got, expected)
+	}
+}
+
+func TestNormalize_InvalidIndent(t *testing.T) {
+	t.Parallel()
+
+	for _, indent := range []int{-1, 1, 10} {
+		err := Normalize(strings.NewReader("a: 1\n"), io.Discard, Options{Indent: indent})
+		if err == nil || !strings.Contains(err.Error(), "invalid indent") {
+			t.Errorf("expected invalid indent error for %d, got: %v", indent, err)
+		}
+	}
+}
+
+func TestNormalize_LongFlowSequence(t *testing.T) {
+	t.Parallel()
+
+	// Flow style is always reset, so long flow sequences never end up on one
+	// long line
+	input := "deps: [alpha-package-one, beta-package-two, gamma-package-three, delta-package-four, epsilon-package-five]\n"
+
+	expected := `deps:
+  - alpha-package-one
+  - beta-package-two
+  - gamma-package-three
+  - delta-package-four
+  - epsilon-package-five
+`
+
+	var output bytes.Buffer
+	if err := Normalize(strings.NewReader(input), &output, Options{}); err != nil {
+		t.Fatalf("Normalize failed: %v", err)
+	}
+
+	if got := output.String(); got != expected {
+		t.Errorf("Normalize() = %q, want %q", got, expected)
+	}
+}
+
+func TestNormalize_FlowWidth(t *testing.T) {
+	t.Parallel()
+
+	input := `deps: !!set {alpha-package-one, beta-package-two, gamma-package-three, "delta, package four"}
+short: !!set {a, b}
+`
+
+	expected := `deps: !!set {alpha-package-one: null,
+  beta-package-two: null,
+  'delta, package four': null,
+  gamma-package-three: null}
+short: !!set {a: null, b: null}
+`
+
+	var output bytes.Buffer
+	if err := Normalize(strings.NewReader(input), &output, Options{FlowSets: true, FlowWidth: 40, VerifyEqual: true}); err != nil {
+		t.Fatalf("Normalize failed: %v", err)
+	}
+
+	if got := output.String(); got != expected {
+		t.Errorf("Normalize() = %q, want %q", got, expected)
+	}
+
+	// The folded set is still written in flow style
+	var doc yaml.Node
+	if err := yaml.Unmarshal(output.Bytes(), &doc); err != nil {
+		t.Fatalf("failed to decode output: %v", err)
+	}
+	if deps := doc.Content[0].Content[1]; deps.Style&yaml.FlowStyle == 0 || len(deps.Content) != 8 {
+		t.Errorf("expected deps to be a flow set of 4 items, got style %v with %d nodes", deps.Style, len(deps.Content))
+	}
+}
+
+func TestNormalize_WarnCaseCollisions(t *testing.T) {
+	t.Parallel()
+
+	input := `metadata:
+  labels:
+    App: web
+    app: web
+    tier: frontend
+Name: web
+name: web
+NAME: web
+other: value
+`
+
+	var warnings []Warning
+	opts := Options{
+		WarnCaseCollisions: true,
+		Warn:               func(w Warning) { warnings = append(warnings, w) },
+	}
+
+	var output bytes.Buffer
+	if err := Normalize(strings.NewReader(input), &output, opts); err != nil {
+		t.Fatalf("Normalize failed: %v", err)
+	}
+
+	want := []Warning{
+		{Line: 7, Message: `keys "Name" and "name" in . differ only by case`},
+		{Line: 8, Message: `keys "Name" and "NAME" in . differ only by case`},
+		{Line: 4, Message: `keys "App" and "app" in metadata.labels differ only by case`},
+	}
+	if !reflect.DeepEqual(warnings, want) {
+		t.Errorf("warnings = %+v, want %+v", warnings, want)
+	}
+
+	opts.Strict = true
+	err := Normalize(strings.NewReader(input), io.Discard, opts)
+	var warning Warning
+	if !errors.As(err, &warning) {
+		t.Fatalf("expected a warning error, got: %v", err)
+	}
+}
+
+func TestNewOptions(t *testing.T) {
+	t.Parallel()
+
+	input := `# comment
+b:
+  'needs: quoting': 1
+a: 2
+`
+
+	expected := `# comment
+b:
+    'needs: quoting': 1
+a: 2
+`
+
+	opts := NewOptions(
+		WithIndent(4),
+		WithSortKeys(false),
+		WithComments(true),
+		WithKeyQuoteStyle(yaml.SingleQuotedStyle),
+	)
+
+	var output bytes.Buffer
+	if err := Normalize(strings.NewReader(input), &output, opts); err != nil {
+		t.Fatalf("Normalize failed: %v", err)
+	}
+
+	if got := output.String(); got != expected {
+		t.Errorf("Normalize() = %q, want %q", got, expected)
+	}
+
+	if got := NewOptions(); !reflect.DeepEqual(got, Options{}) {
+		t.Errorf("NewOptions() = %+v, want the zero value", got)
+	}
+}
+
+func TestNormalize_KeyOrder(t *testing.T) {
+	t.Parallel()
+
+	input := `status:
+  conditions:
+    - type: Ready
+      status: "True"
+zeta: 1
+spec:
+  template:
+    spec: {}
+    metadata: {}
+kind: Pod
+data: 1
+apiVersion: v1
+---
+b: 1
+kind: List
+a: 2
+`
+
+	expected := `apiVersion: v1
+kind: Pod
+spec:
+  template:
+    metadata: {}
+    spec: {}
+status:
+  conditions:
+    - status: "True"
+      type: Ready
+data: 1
+zeta: 1
+---
+kind: List
+a: 2
+b: 1
+`
+
+	var output bytes.Buffer
+	opts := Options{KeyOrder: []string{"apiVersion", "kind", "metadata", "spec", "status"}}
+	if err := Normalize(strings.NewReader(input), &output, opts); err != nil {
+		t.Fatalf("Normalize failed: %v", err)
+	}
+
+	if got := output.String(); got != expected {
+		t.Errorf("Normalize() = %q, want %q", got, expected)
+	}
+}
+
+func TestNormalize_SortDescending(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "natural order reversed",
+			input:    "key2: a\nkey10: b\nkey1: c\nalpha: d\n",
+			expected: "key10: b\nkey2: a\nkey1: c\nalpha: d\n",
+		},
+		{
+			name:     "nested",
+			input:    "a:\n  x: 1\n  y: 2\nb: 3\n",
+			expected: "b: 3\na:\n  y: 2\n  x: 1\n",
+		},
+		{
+			name:     "mixed keys",
+			input:    "1: a\n10: b\nx: c\ntrue: d\n",
+			expected: "x: c\n10: b\n1: a\ntrue: d\n",
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var output bytes.Buffer
+			if err := Normalize(strings.NewReader(tt.input), &output, Options{SortDescending: true}); err != nil {
+				t.Fatalf("Normalize failed: %v", err)
+			}
+
+			if got := output.String(); got != tt.expected {
+				t.Errorf("Normalize() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestNormalize_WarnSingleKeyChains(t *testing.T) {
+	t.Parallel()
+
+	input := `a:
+  b:
+    c:
+      d: 1
+short:
+  e: 1
+list:
+  - p:
+      q:
+        r: 2
+        s: 3
+---
+x:
+  y:
+    z: {w: 1}
+`
+
+	var warnings []Warning
+	opts := Options{
+		WarnSingleKeyChains: 2,
+		Warn:                func(w Warning) { warnings = append(warnings, w) },
+	}
+
+	var output bytes.Buffer
+	if err := Normalize(strings.NewReader(input), &output, opts); err != nil {
+		t.Fatalf("Normalize failed: %v", err)
+	}
+
+	want := []Warning{
+		{Line: 2, Message: "3 nested mappings in a each have a single key; consider flattening them to b.c.d"},
+		{Line: 13, Message: "4 nested mappings in . each have a single key; consider flattening them to x.y.z.w"},
+	}
+	if !reflect.DeepEqual(warnings, want) {
+		t.Errorf("warnings = %+v, want %+v", warnings, want)
+	}
+
+	// The output is unchanged by the check
+	var plain bytes.Buffer
+	if err := Normalize(strings.NewReader(input), &plain, Options{}); err != nil {
+		t.Fatalf("Normalize failed: %v", err)
+	}
+	if output.String() != plain.String() {
+		t.Errorf("Normalize() = %q, want %q", output.String(), plain.String())
+	}
+}
+
+func TestNormalize_Sets(t *testing.T) {
+	t.Parallel()
+
+	input := `flow: !!set {c, a, b}
+block: !!set
+  ? zeta
+  ? item10
+  ? item2
+`
+
+	testCases := []struct {
+		name     string
+		opts     Options
+		expected string
+	}{
+		{
+			name: "block",
+			opts: Options{},
+			expected: `block: !!set
+  item2:
+  item10:
+  zeta:
+flow: !!set
+  a:
+  b:
+  c:
+`,
+		},
+		{
+			name: "flow",
+			opts: Options{FlowSets: true},
+			expected: `block: !!set {item2: null, item10: null, zeta: null}
+flow: !!set {a: null, b: null, c: null}
+`,
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var output bytes.Buffer
+			opts := tt.opts
+			opts.VerifyEqual = true
+			if err := Normalize(strings.NewReader(input), &output, opts); err != nil {
+				t.Fatalf("Normalize failed: %v", err)
+			}
+
+			if got := output.String(); got != tt.expected {
+				t.Errorf("Normalize() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestNormalize_MergeKeyWithoutTag(t *testing.T) {
+	t.Parallel()
+
+	input := `defaults: &defaults
+  a: 1
+  b: 2
+x:
+  b: 3
+  <<: *defaults
+`
+
+	expected := `defaults: &defaults
+  a: 1
+  b: 2
+x:
+  <<: *defaults
+  b: 3
+`
+
+	var output bytes.Buffer
+	if err := Normalize(strings.NewReader(input), &output, Options{}); err != nil {
+		t.Fatalf("Normalize failed: %v", err)
+	}
+	if got := output.String(); got != expected {
+		t.Errorf("Normalize() = %q, want %q", got, expected)
+	}
+
+	// The untagged key is still a merge key
+	var decoded struct {
+		X map[string]int `yaml:"x"`
+	}
+	if err := yaml.Unmarshal(output.Bytes(), &decoded); err != nil {
+		t.Fatalf("failed to decode output: %v", err)
+	}
+	if want := map[string]int{"a": 1, "b": 3}; !reflect.DeepEqual(decoded.X, want) {
+		t.Errorf("decoded x = %v, want %v", decoded.X, want)
+	}
+}
+
+func TestNormalize_ExpandMerges(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name: "single source",
+			input: `defaults: &defaults
+  a: 1
+  b: 2
+x:
+  <<: *defaults
+  b: 3
+`,
+			expected: `defaults:
+  a: 1
+  b: 2
+x:
+  a: 1
+  b: 3
+`,
+		},
+		{
+			name: "multiple sources",
+			input: `base: &base
+  a: 1
+  b: 2
+extra: &extra
+  b: 20
+  c: 30
+x:
+  <<: [*extra, *base]
+  c: 3
+`,
+			expected: `base:
+  a: 1
+  b: 2
+extra:
+  b: 20
+  c: 30
+x:
+  a: 1
+  b: 20
+  c: 3
+`,
+		},
+		{
+			name: "nested merges",
+			input: `base: &base
+  a: 1
+mid: &mid
+  <<: *base
+  b: 2
+x:
+  <<: *mid
+`,
+			expected: `base:
+  a: 1
+mid:
+  a: 1
+  b: 2
+x:
+  a: 1
+  b: 2
+`,
+		},
+		{
+			name: "other aliases keep their anchors",
+			input: `app: &app web
+base: &base
+  name: *app
+x:
+  <<: *base
+`,
+			expected: `app: &app web
+base:
+  name: *app
+x:
+  name: *app
+`,
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var output bytes.Buffer
+			if err := Normalize(strings.NewReader(tt.input), &output, Options{ExpandMerges: true}); err != nil {
+				t.Fatalf("Normalize failed: %v", err)
+			}
+
+			if got := output.String(); got != tt.expected {
+				t.Errorf("Normalize() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestNormalize_UndefinedAlias(t *testing.T) {
+	t.Parallel()
+
+	input := `a: 1
+---
+b: &b 2
+c: *missing
+`
+
+	testCases := []struct {
+		name     string
+		opts     Options
+		expected string
+	}{
+		{
+			name:     "streaming",
+			opts:     Options{},
+			expected: "failed to decode YAML input in document 2: alias *missing refers to an undefined anchor &missing",
+		},
+		{
+			name:     "split documents",
+			opts:     Options{DocumentWorkers: 2},
+			expected: "failed to decode YAML input in document starting at line 2: alias *missing refers to an undefined anchor &missing",
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := Normalize(strings.NewReader(input), io.Discard, tt.opts)
+			if err == nil || err.Error() != tt.expected {
+				t.Errorf("expected error %q, got: %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestNormalize_StrictAliases(t *testing.T) {
+	t.Parallel()
+
+	input := `a: &x 1
+---
+b: *x
+`
+
+	var output bytes.Buffer
+	if err := Normalize(strings.NewReader(input), &output, Options{}); err != nil {
+		t.Fatalf("expected aliases to earlier documents to be allowed, got: %v", err)
+	}
+
+	err := Normalize(strings.NewReader(input), io.Discard, Options{StrictAliases: true})
+	expected := "document 2: alias *x at line 3, column 4 refers to an anchor in an earlier document"
+	if err == nil || err.Error() != expected {
+		t.Errorf("expected error %q, got: %v", expected, err)
+	}
+
+	// Aliases within the same document are fine
+	if err := Normalize(strings.NewReader("a: &x 1\nb: *x\n"), io.Discard, Options{StrictAliases: true}); err != nil {
+		t.Errorf("expected no error, got: %v", err)
+	}
+}
+
+func TestNormalize_EscapeUnicode(t *testing.T) {
+	t.Parallel()
+
+	input := `emoji: "🚀 launch 🎉"
+cjk: "日本語"
+accented: "crème brûlée"
+control: "🚀\x01"
+"key 🔑": value
+`
+
+	testCases := []struct {
+		name     string
+		opts     Options
+		expected string
+	}{
+		{
+			name: "literal",
+			opts: Options{},
+			expected: `accented: crème brûlée
+cjk: 日本語
+control: "🚀\x01"
+emoji: 🚀 launch 🎉
+key 🔑: value
+`,
+		},
+		{
+			name: "escaped",
+			opts: Options{EscapeUnicode: true},
+			expected: `accented: crème brûlée
+cjk: 日本語
+control: "\U0001F680\x01"
+emoji: "\U0001F680 launch \U0001F389"
+"key \U0001F511": value
+`,
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var output bytes.Buffer
+			opts := tt.opts
+			opts.VerifyEqual = true
+			if err := Normalize(strings.NewReader(input), &output, opts); err != nil {
+				t.Fatalf("Normalize failed: %v", err)
+			}
+
+			if got := output.String(); got != tt.expected {
+				t.Errorf("Normalize() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestNormalize_LiteralUnicodeWithPrivateUseCharacters(t *testing.T) {
+	t.Parallel()
+
+	// Placeholders for emoji must not collide with characters already in
+	// the document
+	input := "a: \"\uE000 \U0001F680\"\nb: \"\uE001\"\n"
+	expected := "a: \uE000 \U0001F680\nb: \uE001\n"
+
+	var output bytes.Buffer
+	if err := Normalize(strings.NewReader(input), &output, Options{VerifyEqual: true}); err != nil {
+		t.Fatalf("Normalize failed: %v", err)
+	}
+
+	if got := output.String(); got != expected {
+		t.Errorf("Normalize() = %q, want %q", got, expected)
+	}
+}
+
+func TestNormalizeBytes(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		input    string
+		opts     Options
+		expected string
+	}{
+		{
+			name:     "empty input",
+			input:    "",
+			expected: "",
+		},
+		{
+			name: "sorted keys",
+			input: `z: 1
+a: 2
+m: 3
+`,
+			expected: `a: 2
+m: 3
+z: 1
+`,
+		},
+		{
+			name: "multiple documents",
+			input: `b: 1
+a: 2
+---
+d: 3
+c: 4
+`,
+			expected: `a: 2
+b: 1
+---
+c: 4
+d: 3
+`,
+		},
+		{
+			name:     "comments",
+			input:    "b: 1 # one\na: 2\n",
+			opts:     Options{PreserveComments: true},
+			expected: "a: 2\nb: 1 # one\n",
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := NormalizeBytes([]byte(tt.input), tt.opts)
+			if err != nil {
+				t.Fatalf("NormalizeBytes failed: %v", err)
+			}
+			if got == nil {
+				t.Fatal("NormalizeBytes() = nil, want non-nil")
+			}
+			if string(got) != tt.expected {
+				t.Errorf("NormalizeBytes() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+
+	if _, err := NormalizeBytes([]byte("a: [1, 2\n"), Options{}); err == nil {
+		t.Error("expected an error for invalid YAML")
+	}
+}
+
+func TestNormalize_DocumentTag(t *testing.T) {
+	t.Parallel()
+
+	input := `--- !Config
+key: value
+b: 1
+--- !List
+- 1
+--- !!str 123
+`
+
+	testCases := []struct {
+		name     string
+		opts     Options
+		expected string
+	}{
+		{
+			name: "preserved",
+			opts: Options{},
+			expected: `!Config
+b: 1
+key: value
+---
+!List
+- 1
+---
+"123"
+`,
+		},
+		{
+			name: "stripped",
+			opts: Options{StripDocumentTags: true},
+			expected: `b: 1
+key: value
+---
+- 1
+---
+"123"
+`,
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var output bytes.Buffer
+			if err := Normalize(strings.NewReader(input), &output, tt.opts); err != nil {
+				t.Fatalf("Normalize failed: %v", err)
+			}
+
+			if got := output.String(); got != tt.expected {
+				t.Errorf("Normalize() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestNormalizeString(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "single document",
+			input:    "b: 1\na: 2",
+			expected: "a: 2\nb: 1\n",
+		},
+		{
+			name:     "multiple documents",
+			input:    "b: 1\na: 2\n---\n- x\n---\nd: 3\nc: 4\n",
+			expected: "a: 2\nb: 1\n---\n- x\n---\nc: 4\nd: 3\n",
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := NormalizeString(tt.input, Options{})
+			if err != nil {
+				t.Fatalf("NormalizeString failed: %v", err)
+			}
+			if got != tt.expected {
+				t.Errorf("NormalizeString() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+
+	t.Run("invalid YAML", func(t *testing.T) {
+		t.Parallel()
+
+		got, err := NormalizeString("a: [1, 2\n", Options{})
+		if err == nil {
+			t.Errorf("expected an error, got output %q", got)
+		}
+	})
+}
+
+func TestNormalizeNode(t *testing.T) {
+	t.Parallel()
+
+	scalar := func(value string) *yaml.Node {
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value, Style: yaml.DoubleQuotedStyle}
+	}
+	node := &yaml.Node{
+		Kind: yaml.MappingNode,
+		Tag:  "!!map",
+		Content: []*yaml.Node{
+			scalar("zeta"), scalar("1"),
+			scalar("alpha"), {
+				Kind:  yaml.MappingNode,
+				Tag:   "!!map",
+				Style: yaml.FlowStyle,
+				Content: []*yaml.Node{
+					scalar("y"), scalar("2"),
+					scalar("x"), scalar("3"),
+				},
+			},
+		},
+	}
+
+	if err := NormalizeNode(node, Options{}); err != nil {
+		t.Fatalf("NormalizeNode() error = %v", err)
+	}
+
+	keys := func(n *yaml.Node) []string {
+		var keys []string
+		for i := 0; i < len(n.Content); i += 2 {
+			keys = append(keys, n.Content[i].Value)
+		}
+		return keys
+	}
+	if got, want := keys(node), []string{"alpha", "zeta"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("keys = %q, want %q", got, want)
+	}
+	nested := node.Content[1]
+	if got, want := keys(nested), []string{"x", "y"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("nested keys = %q, want %q", got, want)
+	}
+	if nested.Style != 0 {
+		t.Errorf("nested style = %v, want block style", nested.Style)
+	}
+	if node.Content[0].Style != 0 {
+		t.Errorf("key style = %v, want plain", node.Content[0].Style)
+	}
+}
+
+func TestNormalize_StrictDuplicates(t *testing.T) {
+	t.Parallel()
+
+	input := `a: 1
+---
+b: 1
+nested:
+  c: 1
+  c: 2
+`
+
+	var output bytes.Buffer
+	if err := Normalize(strings.NewReader(input), &output, Options{}); err != nil {
+		t.Fatalf("Expected duplicate keys to be allowed by default, got: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		opts Options
+		want []string
+	}{
+		{
+			name: "stream",
+			opts: Options{StrictDuplicates: true},
+			want: []string{"document 2", `duplicate key "c" at line 6, column 3, first defined at line 5, column 3`},
+		},
+		{
+			name: "split documents",
+			opts: Options{StrictDuplicates: true, DocumentWorkers: 2},
+			want: []string{"document starting at line 2", `duplicate key "c" at line 6, column 3, first defined at line 5, column 3`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var output bytes.Buffer
+			err := Normalize(strings.NewReader(input), &output, tt.opts)
+			if err == nil {
+				t.Fatal("Expected error for duplicate key, but got none")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("Expected error containing %q, got: %v", want, err)
+				}
+			}
+		})
+	}
+}
+
+func TestNormalize_StrictDuplicatesMergeKeys(t *testing.T) {
+	t.Parallel()
+
+	input := `base: &base {x: 1}
+extra: &extra {y: 2}
+service:
+  <<: *base
+  <<: *extra
+  name: web
+`
+	expected := `base: &base
+  x: 1
+extra: &extra
+  y: 2
+service:
+  <<: *base
+  <<: *extra
+  name: web
+`
+
+	var output bytes.Buffer
+	if err := Normalize(strings.NewReader(input), &output, Options{StrictDuplicates: true}); err != nil {
+		t.Fatalf("Expected repeated merge keys to be allowed, got: %v", err)
+	}
+	if got := output.String(); got != expected {
+		t.Errorf("Normalize() = %q, want %q", got, expected)
+	}
+}
+
+func TestNormalizeValue(t *testing.T) {
+	t.Parallel()
+
+	value := map[string]any{
+		"zeta":  1,
+		"alpha": []any{"b", "a"},
+		"mid": map[string]any{
+			"y": "multi\nline",
+			"x": true,
+		},
+	}
+	expected := `alpha:
+  - b
+  - a
+mid:
+  x: true
+  y: |-
+    multi
+    line
+zeta: 1
+`
+
+	got, err := NormalizeValue(value, Options{Indent: 2})
+	if err != nil {
+		t.Fatalf("NormalizeValue() error = %v", err)
+	}
+	if string(got) != expected {
+		t.Errorf("NormalizeValue() = %q, want %q", got, expected)
+	}
+}
+
+func TestNormalize_FromJSON(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:  "nested object",
+			input: `{"zeta": {"b": [1, "two", true, null], "a": {"y": 1, "x": 2}}, "alpha": "yes"}`,
+			expected: `alpha: yes
+zeta:
+  a:
+    x: 2
+    y: 1
+  b:
+    - 1
+    - two
+    - true
+    - null
+`,
+		},
+		{
+			name:     "numbers are written as is",
+			input:    `{"big": 123456789012345678901234567890, "exp": 1.50e3, "str": "1"}`,
+			expected: "big: 123456789012345678901234567890\nexp: 1.50e3\nstr: \"1\"\n",
+		},
+		{
+			name:     "escapes",
+			input:    `{"s": "a\/b é 🚀"}`,
+			expected: "s: a/b é 🚀\n",
+		},
+		{
+			name:     "stream of values",
+			input:    "{\"b\": 1, \"a\": 2}\n[3]\n",
+			expected: "a: 2\nb: 1\n---\n- 3\n",
+		},
+		{
+			name:     "empty",
+			input:    "",
+			expected: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var output bytes.Buffer
+			if err := Normalize(strings.NewReader(tt.input), &output, Options{FromJSON: true}); err != nil {
+				t.Fatalf("Normalize() error = %v", err)
+			}
+			if got := output.String(); got != tt.expected {
+				t.Errorf("Normalize() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestNormalize_FromJSONInvalid(t *testing.T) {
+	t.Parallel()
+
+	for _, input := range []string{`{"a": [1, `, `{"a"}`, `{"a": 1} x`} {
+		var output bytes.Buffer
+		err := Normalize(strings.NewReader(input), &output, Options{FromJSON: true})
+		if err == nil || !strings.Contains(err.Error(), "failed to decode JSON input") {
+			t.Errorf("Normalize(%q) error = %v, want JSON decoding error", input, err)
+		}
+	}
+}
+
+func TestNormalize_StrictTrailingContent(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		input   string
+		wantErr string
+	}{
+		{
+			name:    "mapping after quoted scalar",
+			input:   "a: 1\n---\n\"foo\"\nbar: baz\n",
+			wantErr: `document starting at line 2 has unexpected content after its value`,
+		},
+		{
+			name:    "plain scalar continued",
+			input:   "foo\nbar\n",
+			wantErr: `plain scalar at line 1 continues onto line 2`,
+		},
+		{
+			name:    "indented plain scalar continued",
+			input:   "--- foo\n  bar\n",
+			wantErr: `plain scalar at line 1 continues onto line 2`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var output bytes.Buffer
+			err := Normalize(strings.NewReader(tt.input), &output, Options{StrictTrailingContent: true})
+			if err == nil {
+				t.Fatalf("Expected error for trailing content, but got none; output: %q", output.String())
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got: %v", tt.wantErr, err)
+			}
+		})
+	}
+
+	// Comments, document end markers, and multi-line values of other styles
+	// are allowed
+	input := "foo # comment\n# comment\n...\n---\n|\n  a\n  b\n---\n\"x\n  y\"\n"
+	expected := "foo\n---\n|\n  a\n  b\n---\nx y\n"
+	var output bytes.Buffer
+	if err := Normalize(strings.NewReader(input), &output, Options{StrictTrailingContent: true}); err != nil {
+		t.Fatalf("Normalize() error = %v", err)
+	}
+	if got := output.String(); got != expected {
+		t.Errorf("Normalize() = %q, want %q", got, expected)
+	}
+}
+
+func TestNormalize_UnsortedTags(t *testing.T) {
+	t.Parallel()
+
+	input := `steps: !ordered
+  checkout: {ref: main, depth: 1}
+  build: make
+  deploy: true
+env:
+  zeta: 1
+  alpha: 2
+`
+	expected := `env:
+  alpha: 2
+  zeta: 1
+steps: !ordered
+  checkout:
+    depth: 1
+    ref: main
+  build: make
+  deploy: true
+`
+
+	var output bytes.Buffer
+	if err := Normalize(strings.NewReader(input), &output, Options{UnsortedTags: []string{"!ordered"}}); err != nil {
+		t.Fatalf("Normalize() error = %v", err)
+	}
+	if got := output.String(); got != expected {
+		t.Errorf("Normalize() = %q, want %q", got, expected)
+	}
+}
+
+func TestNormalize_TreeWriter(t *testing.T) {
+	t.Parallel()
+
+	input := `a: &x "1"
+b: [*x, 2]
+`
+	expected := `document 1 in test.yaml:
+  DocumentNode line=1 col=1
+    MappingNode tag=!!map line=1 col=1
+      ScalarNode tag=!!str value="a" line=1 col=1
+      ScalarNode tag=!!str style=double-quoted anchor=&x value="1" line=1 col=4
+      ScalarNode tag=!!str value="b" line=2 col=1
+      SequenceNode tag=!!seq style=flow line=2 col=4
+        AliasNode value="x" line=2 col=5
+        ScalarNode tag=!!int value="2" line=2 col=9
+`
+
+	var output, tree bytes.Buffer
+	opts := Options{TreeWriter: &tree}.WithFile("test.yaml")
+	if err := Normalize(strings.NewReader(input), &output, opts); err != nil {
+		t.Fatalf("Normalize() error = %v", err)
+	}
+	if got := tree.String(); got != expected {
+		t.Errorf("tree = %q, want %q", got, expected)
+	}
+}
+
+func TestNormalize_OrderedMapPaths(t *testing.T) {
+	t.Parallel()
+
+	input := `paths:
+  /users:
+    get: {summary: List users, operationId: listUsers}
+  /users/{id}:
+    get: {summary: Get user, operationId: getUser}
+  /admin:
+    post: {summary: Admin, operationId: admin}
+openapi: 3.0.0
+info: {version: 1.0.0, title: API}
+components:
+  schemas:
+    User: {type: object}
+    Error: {type: object}
+`
+	expected := `components:
+  schemas:
+    User:
+      type: object
+    Error:
+      type: object
+info:
+  title: API
+  version: 1.0.0
+openapi: 3.0.0
+paths:
+  /users:
+    get:
+      operationId: listUsers
+      summary: List users
+  /users/{id}:
+    get:
+      operationId: getUser
+      summary: Get user
+  /admin:
+    post:
+      operationId: admin
+      summary: Admin
+`
+
+	var output bytes.Buffer
+	if err := Normalize(strings.NewReader(input), &output, Options{OrderedMapPaths: []string{"paths", "components.schemas"}}); err != nil {
+		t.Fatalf("Normalize() error = %v", err)
+	}
+	if got := output.String(); got != expected {
+		t.Errorf("Normalize() = %q, want %q", got, expected)
+	}
+}
+
+func TestNormalizeFile_BackupSuffix(t *testing.T) {
+	t.Parallel()
+
+	large := "b: 2\na: 1\n" + strings.Repeat("# padding\n", 120*1024)
+	tests := []struct {
+		name     string
+		content  string
+		expected string
+	}{
+		{
+			name:     "small",
+			content:  "b: 2\na: 1\n",
+			expected: "a: 1\nb: 2\n",
+		},
+		{
+			name:     "large",
+			content:  large,
+			expected: "a: 1\nb: 2\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			filename := filepath.Join(t.TempDir(), "test.yaml")
+			if err := os.WriteFile(filename, []byte(tt.content), 0600); err != nil {
+				t.Fatalf("Failed to write test file: %v", err)
+			}
+
+			if err := NormalizeFile(filename, Options{BackupSuffix: ".bak"}); err != nil {
+				t.Fatalf("NormalizeFile failed: %v", err)
+			}
+
+			content, err := os.ReadFile(filename)
+			if err != nil {
+				t.Fatalf("Failed to read file: %v", err)
+			}
+			if string(content) != tt.expected {
+				t.Errorf("file = %q, want %q", content, tt.expected)
+			}
+
+			backup, err := os.ReadFile(filename + ".bak")
+			if err != nil {
+				t.Fatalf("Failed to read backup: %v", err)
+			}
+			if string(backup) != tt.content {
+				t.Errorf("backup has %d bytes, want the original %d bytes", len(backup), len(tt.content))
+			}
+			info, err := os.Stat(filename + ".bak")
+			if err != nil {
+				t.Fatalf("Failed to stat backup: %v", err)
+			}
+			if info.Mode().Perm() != 0600 {
+				t.Errorf("backup mode = %v, want %v", info.Mode().Perm(), os.FileMode(0600))
+			}
+		})
+	}
+}
+
+func TestNormalizeFile_BackupSuffixInvalidInput(t *testing.T) {
+	t.Parallel()
+
+	// The first document is valid, so a streaming write would already have
+	// replaced part of the file by the time the second fails to decode
+	original := "b: 2\na: 1\n---\nkey: [unclosed\n"
+	filename := filepath.Join(t.TempDir(), "test.yaml")
+	if err := os.WriteFile(filename, []byte(original), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	if err := NormalizeFile(filename, Options{BackupSuffix: ".bak"}); err == nil {
+		t.Fatal("Expected error for invalid input, but got none")
+	}
+
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+	if string(content) != original {
+		t.Errorf("file = %q, want it to be untouched", content)
+	}
+	if _, err := os.Stat(filename + ".bak"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected no backup to be written, got: %v", err)
+	}
+}
+
+func TestNormalize_CleanInvisible(t *testing.T) {
+	t.Parallel()
+
+	// Zero-width joiners are kept, since they join emoji into one
+	input := "zeta: 1\nna\u200bme: web\nlabel: \"two\u00a0words\"\nfamily: \"\U0001F468\u200d\U0001F469\"\nboth: \"a\u200bb\u00a0c\"\n"
+	expected := "both: ab c\nfamily: \U0001F468\u200d\U0001F469\nlabel: two words\nname: web\nzeta: 1\n"
+
+	var warnings []Warning
+	opts := Options{
+		CleanInvisible: true,
+		Warn:           func(w Warning) { warnings = append(warnings, w) },
+	}
+
+	var output bytes.Buffer
+	if err := Normalize(strings.NewReader(input), &output, opts); err != nil {
+		t.Fatalf("Normalize failed: %v", err)
+	}
+	if got := output.String(); got != expected {
+		t.Errorf("Normalize() = %q, want %q", got, expected)
+	}
+
+	want := []Warning{
+		{Line: 2, Message: `removed zero-width space (U+200B) in "na\u200bme"`},
+		{Line: 3, Message: `replaced no-break space (U+00A0) with a space in "two\u00a0words"`},
+		{Line: 5, Message: `removed zero-width space (U+200B) and replaced no-break space (U+00A0) with a space in "a\u200bb\u00a0c"`},
+	}
+	if !reflect.DeepEqual(warnings, want) {
+		t.Errorf("warnings = %+v, want %+v", warnings, want)
+	}
+}
+
+// partialWriter writes up to n bytes to w, then fails.
+type partialWriter struct {
+	w io.Writer
+	n int
+}
+
+func (f *partialWriter) Write(p []byte) (int, error) {
+	if len(p) > f.n {
+		written, _ := f.w.Write(p[:f.n])
+		f.n -= written
+		return written, errors.New("disk full")
+	}
+	f.n -= len(p)
+	return f.w.Write(p)
+}
+
+func TestReplaceFile_FailedWrite(t *testing.T) {
+	t.Parallel()
+
+	original := "b: 2\na: 1\n"
+	filename := filepath.Join(t.TempDir(), "test.yaml")
+	if err := os.WriteFile(filename, []byte(original), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	info, err := os.Stat(filename)
+	if err != nil {
+		t.Fatalf("Failed to stat file: %v", err)
+	}
+	err = replaceFile(filename, info, smallBufferSize, func(w io.Writer) error {
+		_, err := (&partialWriter{w: w, n: 4}).Write([]byte("a: 1\nb: 2\n"))
+		return err
+	}, Options{})
+	if err == nil {
+		t.Fatal("Expected error from failed write, but got none")
+	}
+
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+	if string(content) != original {
+		t.Errorf("file = %q, want it to be untouched", content)
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(filename))
+	if err != nil {
+		t.Fatalf("Failed to read directory: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("Expected no temporary files to be left behind, got %d entries", len(entries))
+	}
+}
+
+func TestNormalizeFile_SmallKeepsMode(t *testing.T) {
+	t.Parallel()
+
+	filename := filepath.Join(t.TempDir(), "test.yaml")
+	if err := os.WriteFile(filename, []byte("b: 2\na: 1\n"), 0600); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	// Set the mode explicitly, since it was subject to the umask
+	if err := os.Chmod(filename, 0666); err != nil {
+		t.Fatalf("Failed to set file mode: %v", err)
+	}
+
+	if err := NormalizeFile(filename, Options{}); err != nil {
+		t.Fatalf("NormalizeFile failed: %v", err)
+	}
+
+	info, err := os.Stat(filename)
+	if err != nil {
+		t.Fatalf("Failed to stat file: %v", err)
+	}
+	if info.Mode().Perm() != 0666 {
+		t.Errorf("mode = %v, want %v", info.Mode().Perm(), os.FileMode(0666))
+	}
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+	if string(content) != "a: 1\nb: 2\n" {
+		t.Errorf("file = %q, want %q", content, "a: 1\nb: 2\n")
+	}
+}
+
+func TestNormalize_GroupKeysByPrefix(t *testing.T) {
+	t.Parallel()
+
+	input := `logging: true
+log.level: info
+db.port: 5432
+log-file: /var/log/app
+log: {}
+db_name: app
+log.format: json
+db.host: localhost
+`
+
+	tests := []struct {
+		name     string
+		opts     Options
+		expected string
+	}{
+		{
+			name: "ungrouped",
+			opts: Options{},
+			expected: `db.host: localhost
+db.port: 5432
+db_name: app
+log: {}
+log-file: /var/log/app
+log.format: json
+log.level: info
+logging: true
+`,
+		},
+		{
+			name: "grouped",
+			opts: Options{GroupKeysByPrefix: true},
+			expected: `db.host: localhost
+db.port: 5432
+db_name: app
+log: {}
+log.format: json
+log.level: info
+log-file: /var/log/app
+logging: true
+`,
+		},
+		{
+			name: "grouped descending",
+			opts: Options{GroupKeysByPrefix: true, SortDescending: true},
+			expected: `logging: true
+log-file: /var/log/app
+log.level: info
+log.format: json
+log: {}
+db_name: app
+db.port: 5432
+db.host: localhost
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var output bytes.Buffer
+			if err := Normalize(strings.NewReader(input), &output, tt.opts); err != nil {
+				t.Fatalf("Normalize() error = %v", err)
+			}
+			if got := output.String(); got != tt.expected {
+				t.Errorf("Normalize() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestNormalizeFile_KeepsAttributes(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{
+			name:    "small",
+			content: "b: 2\na: 1\n",
+		},
+		{
+			name:    "large",
+			content: "b: 2\na: 1\n" + strings.Repeat("# padding\n", 120*1024),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			filename := filepath.Join(t.TempDir(), "test.yaml")
+			if err := os.WriteFile(filename, []byte(tt.content), 0600); err != nil {
+				t.Fatalf("Failed to write test file: %v", err)
+			}
+			// Some systems don't allow every bit to be set, so compare against
+			// whatever mode the file ends up with
+			if err := os.Chmod(filename, 0664|os.ModeSetgid|os.ModeSticky); err != nil {
+				t.Fatalf("Failed to set file mode: %v", err)
+			}
+			before, err := os.Stat(filename)
+			if err != nil {
+				t.Fatalf("Failed to stat file: %v", err)
+			}
+
+			var warnings []Warning
+			opts := Options{Warn: func(w Warning) { warnings = append(warnings, w) }}
+			if err := NormalizeFile(filename, opts); err != nil {
+				t.Fatalf("NormalizeFile failed: %v", err)
+			}
+
+			after, err := os.Stat(filename)
+			if err != nil {
+				t.Fatalf("Failed to stat file: %v", err)
+			}
+			if after.Mode() != before.Mode() {
+				t.Errorf("mode = %v, want %v", after.Mode(), before.Mode())
+			}
+			if len(warnings) > 0 {
+				t.Errorf("Expected no warnings for a file owned by the current user, got %v", warnings)
+			}
+		})
+	}
+}
+
+func TestNormalize_RejectEmptyOutput(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		input   string
+		wantErr bool
+	}{
+		{name: "comments only", input: "# just a comment\n", wantErr: true},
+		{name: "whitespace only", input: "\n  \n", wantErr: true},
+		{name: "empty documents", input: "---\n# a\n---\n", wantErr: true},
+		{name: "content", input: "# a\nb: 1\n", wantErr: false},
+		{name: "null document", input: "~\n", wantErr: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var output bytes.Buffer
+			err := Normalize(strings.NewReader(tt.input), &output, Options{RejectEmptyOutput: true})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Normalize() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && output.Len() != 0 {
+				t.Errorf("Expected no output, got %q", output.String())
+			}
+		})
+	}
+}
+
+func TestNormalizeFile_RejectEmptyOutput(t *testing.T) {
+	t.Parallel()
+
+	original := "# generated file, do not edit\n"
+	filename := filepath.Join(t.TempDir(), "test.yaml")
+	if err := os.WriteFile(filename, []byte(original), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	if err := NormalizeFile(filename, Options{RejectEmptyOutput: true}); err == nil {
+		t.Fatal("Expected error for empty output, but got none")
+	}
+
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+	if string(content) != original {
+		t.Errorf("file = %q, want it to be untouched", content)
+	}
+}
+
+func TestNormalize_HoistHeader(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		input    string
+		opts     Options
+		expected string
+	}{
+		{
+			name:     "header before marker",
+			input:    "# Copyright 2024 Example\n# SPDX-License-Identifier: MIT\n---\nb: 1\na: 2\n---\nd: 3\nc: 4\n",
+			opts:     Options{HoistHeader: true, PreserveComments: true},
+			expected: "# Copyright 2024 Example\n# SPDX-License-Identifier: MIT\n\na: 2\nb: 1\n---\nc: 4\nd: 3\n",
+		},
+		{
+			name:     "header before blank line",
+			input:    "# License\n\n# first\nb: 1\na: 2\n---\nc: 3\n",
+			opts:     Options{HoistHeader: true, PreserveComments: true},
+			expected: "# License\n\na: 2\n# first\nb: 1\n---\nc: 3\n",
+		},
+		{
+			name:     "kept without comments",
+			input:    "# License\n---\nb: 1\na: 2 # two\n",
+			opts:     Options{HoistHeader: true},
+			expected: "# License\n\na: 2\nb: 1\n",
+		},
+		{
+			name:     "split documents",
+			input:    "# License\n---\nb: 1\na: 2\n---\nc: 3\n",
+			opts:     Options{HoistHeader: true, PreserveComments: true, DocumentWorkers: 2},
+			expected: "# License\n\na: 2\nb: 1\n---\nc: 3\n",
+		},
+		{
+			name:     "comment of first key",
+			input:    "# about b\nb: 1\na: 2\n",
+			opts:     Options{HoistHeader: true, PreserveComments: true},
+			expected: "a: 2\n# about b\nb: 1\n",
+		},
+		{
+			name:     "only a header",
+			input:    "# License\n",
+			opts:     Options{HoistHeader: true},
+			expected: "# License\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			output, err := NormalizeString(tt.input, tt.opts)
+			if err != nil {
+				t.Fatalf("Normalize() error = %v", err)
+			}
+			if output != tt.expected {
+				t.Errorf("Normalize() = %q, want %q", output, tt.expected)
+			}
+
+			again, err := NormalizeString(output, tt.opts)
+			if err != nil {
+				t.Fatalf("Normalize() error on normalized output = %v", err)
+			}
+			if again != output {
+				t.Errorf("Normalize() on normalized output = %q, want %q", again, output)
+			}
+		})
+	}
+}
+
+func TestNormalize_HoistHeaderErrorLine(t *testing.T) {
+	t.Parallel()
+
+	_, err := NormalizeString("# License\n\na: b: c\n", Options{HoistHeader: true})
+	if err == nil || !strings.Contains(err.Error(), "line 3") {
+		t.Errorf("Expected error on line 3, got: %v", err)
+	}
+}
+
+func TestNormalizeFileChanged(t *testing.T) {
+	t.Parallel()
+
+	padding := strings.Repeat("x", 2*1024*1024)
+	tests := []struct {
+		name    string
+		content string
+		want    bool
+	}{
+		{name: "small changed", content: "b: 2\na: 1\n", want: true},
+		{name: "small unchanged", content: "a: 1\nb: 2\n", want: false},
+		{name: "large changed", content: "b: 2\na: " + padding + "\n", want: true},
+		{name: "large unchanged", content: "a: " + padding + "\nb: 2\n", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			filename := filepath.Join(t.TempDir(), "test.yaml")
+			if err := os.WriteFile(filename, []byte(tt.content), 0644); err != nil {
+				t.Fatalf("Failed to write test file: %v", err)
+			}
+
+			changed, err := NormalizeFileChanged(filename, Options{})
+			if err != nil {
+				t.Fatalf("NormalizeFileChanged failed: %v", err)
+			}
+			if changed != tt.want {
+				t.Errorf("NormalizeFileChanged() = %v, want %v", changed, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalize_VerifyMerges(t *testing.T) {
+	t.Parallel()
+
+	input := `base: &base
+  z: 1
+  a: 2
+  nested: &nested
+    y: base
+    b: base
+override: &override
+  a: 3
+  m: 4
+services:
+  web:
+    <<: [*override, *base]
+    name: web
+    a: 5
+  worker:
+    <<: *base
+    nested:
+      <<: *nested
+      b: worker
+---
+defaults: &defaults
+  timeout: 30
+  retries: 3
+job:
+  <<: *defaults
+  retries: 5
+`
+	expected := `base: &base
+  a: 2
+  nested: &nested
+    b: base
+    y: base
+  z: 1
+override: &override
+  a: 3
+  m: 4
+services:
+  web:
+    <<:
+      - *override
+      - *base
+    a: 5
+    name: web
+  worker:
+    <<: *base
+    nested:
+      <<: *nested
+      b: worker
+---
+defaults: &defaults
+  retries: 3
+  timeout: 30
+job:
+  <<: *defaults
+  retries: 5
+`
+
+	for _, opts := range []Options{{VerifyMerges: true}, {VerifyMerges: true, DocumentWorkers: 2}} {
+		output, err := NormalizeString(input, opts)
+		if err != nil {
+			t.Fatalf("Normalize() error = %v", err)
+		}
+		if output != expected {
+			t.Errorf("Normalize() = %q, want %q", output, expected)
+		}
+	}
+}
+
+func TestNormalize_VerifyMergesChanged(t *testing.T) {
+	t.Parallel()
+
+	// A handler that reverses the order of merge sources changes which
+	// values take precedence
+	opts := Options{
+		VerifyMerges: true,
+		TagHandlers: map[string]func(*yaml.Node) error{
+			"!reversed": func(node *yaml.Node) error {
+				for i, j := 0, len(node.Content)-1; i < j; i, j = i+1, j-1 {
+					node.Content[i], node.Content[j] = node.Content[j], node.Content[i]
+				}
+				node.Tag = "!!seq"
+				return nil
+			},
+		},
+	}
+	input := "a: &a {x: 1}\nb: &b {x: 2}\nc:\n  <<: !reversed [*a, *b]\n"
+
+	_, err := NormalizeString(input, opts)
+	if err == nil || !strings.Contains(err.Error(), "changed the merged value of the mapping at line 4") {
+		t.Errorf("Expected error about the merged value at line 4, got: %v", err)
+	}
+}
+
+func TestNormalize_DropEmptyDocuments(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{name: "leading", input: "---\n---\nb: 2\na: 1\n", expected: "a: 1\nb: 2\n"},
+		{name: "middle", input: "a: 1\n---\n---\nb: 2\n", expected: "a: 1\n---\nb: 2\n"},
+		{name: "trailing", input: "a: 1\n---\n~\n---\n", expected: "a: 1\n"},
+		{name: "comment only", input: "a: 1\n---\n# nothing here\n---\nb: 2\n", expected: "a: 1\n---\nb: 2\n"},
+		{name: "all empty", input: "---\n---\nnull\n---\n", expected: ""},
+		{name: "empty string kept", input: "a: 1\n---\n\"\"\n", expected: "a: 1\n---\n\"\"\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			for _, opts := range []Options{{DropEmptyDocuments: true}, {DropEmptyDocuments: true, DocumentWorkers: 2}} {
+				output, err := NormalizeString(tt.input, opts)
+				if err != nil {
+					t.Fatalf("Normalize() error = %v", err)
+				}
+				if output != tt.expected {
+					t.Errorf("Normalize() = %q, want %q", output, tt.expected)
+				}
+			}
+		})
+	}
+}
+
+func TestNormalize_ExplicitStart(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{name: "single document", input: "b: 1\na: 2\n", expected: "---\na: 2\nb: 1\n"},
+		{name: "already marked", input: "---\nb: 1\na: 2\n", expected: "---\na: 2\nb: 1\n"},
+		{name: "multiple documents", input: "a: 1\n---\nb: 2\n---\nc: 3\n", expected: "---\na: 1\n---\nb: 2\n---\nc: 3\n"},
+		{name: "empty", input: "", expected: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			for _, opts := range []Options{
+				{ExplicitStart: true},
+				{ExplicitStart: true, DocumentWorkers: 2},
+				{ExplicitStart: true, PreserveUnchanged: true},
+			} {
+				output, err := NormalizeString(tt.input, opts)
+				if err != nil {
+					t.Fatalf("Normalize() error = %v", err)
+				}
+				if output != tt.expected {
+					t.Errorf("Normalize() with %+v = %q, want %q", opts, output, tt.expected)
+				}
+			}
+		})
+	}
+}
+
+func TestNormalizeWithHashes(t *testing.T) {
+	t.Parallel()
+
+	input := "b: 1\na: 2\n---\nc: 3\n---\nd: [4]\n"
+	changed := "b: 1\na: 2\n---\nc: 30\n---\nd: [4]\n"
+
+	for _, opts := range []Options{{}, {DocumentWorkers: 2}} {
+		hashes, docs, err := NormalizeWithHashes(strings.NewReader(input), opts)
+		if err != nil {
+			t.Fatalf("NormalizeWithHashes() error = %v", err)
+		}
+		wantDocs := []string{"a: 2\nb: 1\n", "c: 3\n", "d:\n  - 4\n"}
+		if len(docs) != len(wantDocs) {
+			t.Fatalf("NormalizeWithHashes() returned %d documents, want %d", len(docs), len(wantDocs))
+		}
+		for i, want := range wantDocs {
+			if string(docs[i]) != want {
+				t.Errorf("document %d = %q, want %q", i, docs[i], want)
+			}
+			sum := sha256.Sum256([]byte(want))
+			if hashes[i] != hex.EncodeToString(sum[:]) {
+				t.Errorf("hash of document %d = %s, want the SHA-256 of %q", i, hashes[i], want)
+			}
+		}
+
+		again, _, err := NormalizeWithHashes(strings.NewReader(input), opts)
+		if err != nil {
+			t.Fatalf("NormalizeWithHashes() error = %v", err)
+		}
+		if !reflect.DeepEqual(again, hashes) {
+			t.Errorf("hashes changed between runs: %v, then %v", hashes, again)
+		}
+
+		other, _, err := NormalizeWithHashes(strings.NewReader(changed), opts)
+		if err != nil {
+			t.Fatalf("NormalizeWithHashes() error = %v", err)
+		}
+		if other[0] != hashes[0] || other[2] != hashes[2] {
+			t.Errorf("hashes of unchanged documents changed: %v, then %v", hashes, other)
+		}
+		if other[1] == hashes[1] {
+			t.Errorf("hash of changed document did not change: %s", other[1])
+		}
+	}
+}
+
+func TestNormalizeFile_IgnoreReadOnly(t *testing.T) {
+	t.Parallel()
+
+	filename := filepath.Join(t.TempDir(), "test.yaml")
+	if err := os.WriteFile(filename, []byte("b: 2\na: 1\n"), 0444); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	if err := NormalizeFile(filename, Options{IgnoreReadOnly: true}); err != nil {
+		t.Fatalf("NormalizeFile failed: %v", err)
+	}
+
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+	if string(content) != "a: 1\nb: 2\n" {
+		t.Errorf("file = %q, want %q", content, "a: 1\nb: 2\n")
+	}
+	info, err := os.Stat(filename)
+	if err != nil {
+		t.Fatalf("Failed to stat file: %v", err)
+	}
+	if info.Mode().Perm() != 0444 {
+		t.Errorf("mode = %v, want %v", info.Mode().Perm(), os.FileMode(0444))
+	}
+}
+
+func TestNormalizeFile_IgnoreReadOnlyError(t *testing.T) {
+	t.Parallel()
+
+	if os.Getuid() == 0 {
+		t.Skip("root can write to read-only files")
+	}
+
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "test.yaml")
+	if err := os.WriteFile(filename, []byte("b: 2\na: 1\n"), 0444); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	if err := os.Chmod(dir, 0555); err != nil {
+		t.Fatalf("Failed to make directory read-only: %v", err)
+	}
+	t.Cleanup(func() { _ = os.Chmod(dir, 0755) })
+
+	// Without the option, NormalizeFile refuses before trying to write
+	err := NormalizeFile(filename, Options{})
+	if err == nil || errors.Is(err, fs.ErrPermission) {
+		t.Errorf("Expected an error from the writable check, got: %v", err)
+	}
+
+	err = NormalizeFile(filename, Options{IgnoreReadOnly: true})
+	if !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("Expected a permission error from the operating system, got: %v", err)
+	}
+}
+
+func TestNormalize_ExplicitEnd(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		input    string
+		opts     Options
+		expected string
+	}{
+		{
+			name:     "multiple documents",
+			input:    "b: 1\na: 2\n---\nc: 3\n---\n- d\n",
+			expected: "a: 2\nb: 1\n...\n---\nc: 3\n...\n---\n- d\n...\n",
+		},
+		{
+			name:     "already terminated",
+			input:    "a: 1\n...\n---\nb: 2\n...\n",
+			expected: "a: 1\n...\n---\nb: 2\n...\n",
+		},
+		{
+			name:     "with explicit start",
+			input:    "a: 1\n---\nb: 2\n",
+			opts:     Options{ExplicitStart: true},
+			expected: "---\na: 1\n...\n---\nb: 2\n...\n",
+		},
+		{
+			name:     "unchanged documents",
+			input:    "a: 1\n---\nc: 3\nb: 2",
+			opts:     Options{PreserveUnchanged: true},
+			expected: "a: 1\n...\n---\nb: 2\nc: 3\n...\n",
+		},
+		{
+			name:     "empty stream",
+			input:    "",
+			expected: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			for _, workers := range []int{0, 2} {
+				opts := tt.opts
+				opts.ExplicitEnd = true
+				opts.DocumentWorkers = workers
+				output, err := NormalizeString(tt.input, opts)
+				if err != nil {
+					t.Fatalf("Normalize() error = %v", err)
+				}
+				if output != tt.expected {
+					t.Errorf("Normalize() with %d workers = %q, want %q", workers, output, tt.expected)
+				}
+			}
+		})
+	}
+}
+
+func TestNormalize_LabelSelector(t *testing.T) {
+	t.Parallel()
+
+	input := `kind: Deployment
+metadata:
+  name: web
+  labels: {tier: frontend, app: nginx}
+apiVersion: apps/v1
+---
+kind: ConfigMap
+metadata:   {name: "settings"}
+apiVersion: v1
+---
+kind: Service
+metadata:
+  labels:
+    app: redis
+apiVersion: v1
+`
+	expected := `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  labels:
+    app: nginx
+    tier: frontend
+  name: web
+---
+kind: ConfigMap
+metadata:   {name: "settings"}
+apiVersion: v1
+---
+kind: Service
+metadata:
+  labels:
+    app: redis
+apiVersion: v1
+`
+
+	output, err := NormalizeString(input, Options{LabelSelector: map[string]string{"app": "nginx"}})
+	if err != nil {
+		t.Fatalf("Normalize() error = %v", err)
+	}
+	if output != expected {
+		t.Errorf("Normalize() = %q, want %q", output, expected)
+	}
+
+	// Every label must match
+	output, err = NormalizeString(input, Options{LabelSelector: map[string]string{"app": "nginx", "tier": "backend"}})
+	if err != nil {
+		t.Fatalf("Normalize() error = %v", err)
+	}
+	if output != input {
+		t.Errorf("Normalize() = %q, want the input unchanged", output)
+	}
+}
+
+func TestNormalizeFile_KeepsOtherFiles(t *testing.T) {
+	t.Parallel()
+
+	var large strings.Builder
+	for i := range 100000 {
+		fmt.Fprintf(&large, "key%06d: value\n", 100000-i)
+	}
+
+	for name, content := range map[string]string{
+		"small": "b: 2\na: 1\n",
+		"large": large.String(),
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			filename := filepath.Join(dir, "cfg.yaml")
+			if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+				t.Fatalf("Failed to write test file: %v", err)
+			}
+			// A file named like the temporary files of earlier versions
+			other := filepath.Join(dir, ".tmp_cfg.yaml")
+			if err := os.WriteFile(other, []byte("user: data\n"), 0644); err != nil {
+				t.Fatalf("Failed to write other file: %v", err)
+			}
+
+			if err := NormalizeFile(filename, Options{}); err != nil {
+				t.Fatalf("NormalizeFile failed: %v", err)
+			}
+			if err := WriteFileAtomic(filename, func(w io.Writer) error {
+				_, err := io.WriteString(w, "a: 1\n")
+				return err
+			}); err != nil {
+				t.Fatalf("WriteFileAtomic failed: %v", err)
+			}
+
+			got, err := os.ReadFile(other)
+			if err != nil {
+				t.Fatalf("Failed to read other file: %v", err)
+			}
+			if string(got) != "user: data\n" {
+				t.Errorf("other file = %q, want %q", got, "user: data\n")
+			}
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatalf("Failed to read directory: %v", err)
+			}
+			if len(entries) != 2 {
+				t.Errorf("expected only the file and the other file to remain, got %d entries", len(entries))
+			}
+		})
+	}
+}
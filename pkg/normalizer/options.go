@@ -0,0 +1,46 @@
+package normalizer
+
+import "go.yaml.in/yaml/v3"
+
+// Option sets one or more fields of Options, for use with NewOptions.
+type Option func(*Options)
+
+// NewOptions returns options with each option applied in order to the zero
+// value, which is the default.
+func NewOptions(options ...Option) Options {
+	var opts Options
+	for _, option := range options {
+		option(&opts)
+	}
+	return opts
+}
+
+// WithIndent sets the number of spaces to indent nested collections by.
+func WithIndent(n int) Option {
+	return func(o *Options) {
+		o.Indent = n
+	}
+}
+
+// WithSortKeys sets whether mapping keys are sorted. They are by default.
+func WithSortKeys(sort bool) Option {
+	return func(o *Options) {
+		o.KeepKeyOrder = !sort
+	}
+}
+
+// WithComments sets whether comments are preserved. They are stripped by
+// default.
+func WithComments(preserve bool) Option {
+	return func(o *Options) {
+		o.PreserveComments = preserve
+	}
+}
+
+// WithKeyQuoteStyle sets the quote style for keys that need quoting, which
+// should be yaml.DoubleQuotedStyle or yaml.SingleQuotedStyle.
+func WithKeyQuoteStyle(style yaml.Style) Option {
+	return func(o *Options) {
+		o.KeyQuoteStyle = style
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package normalizer
+
+import "os"
+
+// chownLike does nothing on platforms without Unix file ownership.
+func chownLike(filename string, original os.FileInfo) error {
+	return nil
+}
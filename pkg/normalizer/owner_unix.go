@@ -0,0 +1,26 @@
+//go:build unix
+
+package normalizer
+
+import (
+	"os"
+	"syscall"
+)
+
+// chownLike changes the owner and group of filename to those of original, if
+// they differ.
+func chownLike(filename string, original os.FileInfo) error {
+	want, ok := original.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+
+	info, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+	if got, ok := info.Sys().(*syscall.Stat_t); ok && got.Uid == want.Uid && got.Gid == want.Gid {
+		return nil
+	}
+	return os.Chown(filename, int(want.Uid), int(want.Gid))
+}
@@ -0,0 +1,47 @@
+//go:build unix
+
+package normalizer
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestNormalizeFile_KeepsOwner(t *testing.T) {
+	t.Parallel()
+
+	if os.Getuid() != 0 {
+		t.Skip("changing the owner of a file requires root")
+	}
+
+	filename := filepath.Join(t.TempDir(), "test.yaml")
+	if err := os.WriteFile(filename, []byte("b: 2\na: 1\n"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	const uid, gid = 12345, 23456
+	if err := os.Chown(filename, uid, gid); err != nil {
+		t.Fatalf("Failed to change file owner: %v", err)
+	}
+
+	if err := NormalizeFile(filename, Options{}); err != nil {
+		t.Fatalf("NormalizeFile failed: %v", err)
+	}
+
+	info, err := os.Stat(filename)
+	if err != nil {
+		t.Fatalf("Failed to stat file: %v", err)
+	}
+	stat := info.Sys().(*syscall.Stat_t)
+	if stat.Uid != uid || stat.Gid != gid {
+		t.Errorf("owner = %d:%d, want %d:%d", stat.Uid, stat.Gid, uid, gid)
+	}
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+	if string(content) != "a: 1\nb: 2\n" {
+		t.Errorf("file = %q, want %q", content, "a: 1\nb: 2\n")
+	}
+}
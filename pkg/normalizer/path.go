@@ -0,0 +1,79 @@
+package normalizer
+
+import (
+	"strconv"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// childPath returns the path of the i'th child of node, given the path of
+// node itself. Mapping values are named by their key and sequence items by
+// their index; keys and document contents share their parent's path.
+//
+// To avoid allocating a path for every node, the result may share its
+// backing array with path, so it is only valid until the next call with the
+// same path. Clone it to keep it longer.
+func childPath(node *yaml.Node, path []string, i int) []string {
+	switch node.Kind {
+	case yaml.MappingNode:
+		if i%2 == 1 {
+			return append(path, node.Content[i-1].Value)
+		}
+	case yaml.SequenceNode:
+		return append(path, strconv.Itoa(i))
+	}
+	return path
+}
+
+// matchPath reports whether path matches a dotted pattern such as
+// "data.*". A "*" element matches any single path element; other elements
+// must match literally, and may themselves contain dots, so "data.app.yaml"
+// matches the path ["data", "app.yaml"].
+func matchPath(pattern string, path []string) bool {
+	for _, elem := range path {
+		if pattern == "" {
+			return false
+		}
+
+		if pattern == "*" || strings.HasPrefix(pattern, "*.") {
+			pattern = strings.TrimPrefix(pattern[1:], ".")
+			continue
+		}
+
+		rest, ok := strings.CutPrefix(pattern, elem)
+		if !ok || (rest != "" && rest[0] != '.') {
+			return false
+		}
+		pattern = strings.TrimPrefix(rest, ".")
+	}
+	return pattern == ""
+}
+
+// matchAnyPath reports whether path matches any of the patterns.
+func matchAnyPath(patterns []string, path []string) bool {
+	for _, pattern := range patterns {
+		if matchPath(pattern, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchAnyPathPrefix reports whether path or any of its ancestors matches any
+// of the patterns.
+func matchAnyPathPrefix(patterns []string, path []string) bool {
+	for n := range len(path) + 1 {
+		if matchAnyPath(patterns, path[:n]) {
+			return true
+		}
+	}
+	return false
+}
+
+func formatPath(path []string) string {
+	if len(path) == 0 {
+		return "."
+	}
+	return strings.Join(path, ".")
+}
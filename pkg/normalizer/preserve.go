@@ -0,0 +1,265 @@
+package normalizer
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// preservedValue is a value of one of PreserveValueKeys that is written with
+// its source text. While the document is encoded, the node is replaced by a
+// placeholder, which is then replaced by the source text in the output.
+type preservedValue struct {
+	node  *yaml.Node
+	saved yaml.Node
+	token []byte
+	// source is the text of the value in the input, starting at its quote or
+	// block scalar indicator
+	source []byte
+	// indent is the indentation of the value's key in the input
+	indent int
+}
+
+// preserveValues replaces the quoted and block scalar values of
+// PreserveValueKeys in node with placeholders, so that restoreValues can
+// replace them with their source text after encoding. Other values, such as
+// plain scalars, are encoded as usual, keeping their style.
+func preserveValues(doc document, node *yaml.Node, opts Options) []*preservedValue {
+	// The placeholders must not appear anywhere else in the output, which
+	// only contains text from the source
+	prefix := "norml_preserved"
+	for bytes.Contains(doc.source, []byte(prefix)) {
+		prefix += "_"
+	}
+
+	var values []*preservedValue
+	seen := make(map[*yaml.Node]bool)
+	var walk func(node *yaml.Node)
+	walk = func(node *yaml.Node) {
+		if seen[node] {
+			return
+		}
+		seen[node] = true
+
+		for _, child := range node.Content {
+			walk(child)
+		}
+		if node.Kind != yaml.MappingNode {
+			return
+		}
+		for i := 1; i < len(node.Content); i += 2 {
+			key, value := node.Content[i-1], node.Content[i]
+			if value.Kind != yaml.ScalarNode || !slices.Contains(opts.PreserveValueKeys, key.Value) {
+				continue
+			}
+			source, ok := valueSource(doc, key, value, opts.PreserveComments)
+			if !ok {
+				continue
+			}
+
+			p := &preservedValue{
+				node:   value,
+				saved:  *value,
+				token:  fmt.Appendf(nil, "%s_%d_", prefix, len(values)),
+				source: source,
+				indent: key.Column - 1,
+			}
+			*value = yaml.Node{
+				Kind:        yaml.ScalarNode,
+				Tag:         "!!str",
+				Value:       string(p.token),
+				HeadComment: value.HeadComment,
+				LineComment: value.LineComment,
+				FootComment: value.FootComment,
+			}
+			// The comment after a block scalar indicator is part of its source
+			if p.saved.Style&(yaml.LiteralStyle|yaml.FoldedStyle) != 0 {
+				value.LineComment = ""
+			}
+			values = append(values, p)
+		}
+	}
+	walk(node)
+	return values
+}
+
+// restoreValues replaces the placeholders of values in data with the source
+// text of each value, re-indented to match the indentation of its key in
+// data, and restores the original nodes.
+func restoreValues(data []byte, values []*preservedValue) []byte {
+	for _, p := range values {
+		*p.node = p.saved
+		data = replaceToken(data, p.token, func(prefix []byte) []byte {
+			return reindent(p.source, keyIndent(prefix)-p.indent)
+		})
+	}
+	return data
+}
+
+// replaceToken replaces each occurrence of a placeholder token in data with
+// the result of replace, which is given the start of the line before it.
+func replaceToken(data, token []byte, replace func(prefix []byte) []byte) []byte {
+	for offset := 0; ; {
+		i := bytes.Index(data[offset:], token)
+		if i < 0 {
+			return data
+		}
+		i += offset
+
+		lineStart := bytes.LastIndexByte(data[:i], '\n') + 1
+		text := replace(data[lineStart:i])
+		data = slices.Concat(data[:i], text, data[i+len(token):])
+		offset = i + len(text)
+	}
+}
+
+// valueSource returns the source text of a quoted or block scalar value in a
+// block mapping, without the comment after a block scalar indicator unless
+// keepComments is set.
+func valueSource(doc document, key, value *yaml.Node, keepComments bool) ([]byte, bool) {
+	if value.Tag != "!!str" || value.Anchor != "" {
+		return nil, false
+	}
+
+	lines := bytes.SplitAfter(doc.source, []byte("\n"))
+	keyRow, row := key.Line-doc.line, value.Line-doc.line
+	if keyRow < 0 || row < keyRow || row >= len(lines) {
+		return nil, false
+	}
+
+	// The indentation of keys in flow mappings says nothing about the
+	// indentation of their values
+	keyLine := lines[keyRow]
+	keyStart, ok := columnOffset(keyLine, key.Column)
+	if !ok || strings.Trim(string(keyLine[:keyStart]), " -") != "" {
+		return nil, false
+	}
+	indent := key.Column - 1
+
+	start, ok := columnOffset(lines[row], value.Column)
+	if !ok || start >= len(lines[row]) {
+		return nil, false
+	}
+	offset := start
+	for _, line := range lines[:row] {
+		offset += len(line)
+	}
+
+	switch c := doc.source[offset]; {
+	case c == '|' || c == '>':
+		header := bytes.TrimRight(lines[row][start:], "\r\n")
+		if i := bytes.Index(header, []byte(" #")); i >= 0 && !keepComments {
+			header = header[:i]
+		}
+		header = bytes.TrimRight(header, " \t")
+		keep := bytes.IndexByte(bytes.Fields(header)[0], '+') >= 0
+
+		// The block ends before the first line that isn't blank and isn't
+		// indented past the key, and only keeps trailing blank lines with
+		// the keep chomping indicator
+		last := row
+		for r := row + 1; r < len(lines); r++ {
+			if len(bytes.TrimSpace(lines[r])) == 0 {
+				if keep && r < len(lines)-1 {
+					last = r
+				}
+				continue
+			}
+			if leadingSpaces(lines[r]) <= indent {
+				break
+			}
+			last = r
+		}
+		source := slices.Clone(header)
+		for _, line := range lines[row+1 : last+1] {
+			source = append(source, '\n')
+			source = append(source, bytes.TrimRight(line, "\r\n")...)
+		}
+		return source, true
+	case c == '"' || c == '\'':
+		end := closingQuote(doc.source[offset:])
+		if end < 0 {
+			return nil, false
+		}
+		return doc.source[offset : offset+end+1], true
+	default:
+		return nil, false
+	}
+}
+
+// closingQuote returns the index of the quote that ends the quoted scalar at
+// the start of s, or -1 if there is none.
+func closingQuote(s []byte) int {
+	quote := s[0]
+	for i := 1; i < len(s); i++ {
+		switch {
+		case quote == '"' && s[i] == '\\':
+			i++
+		case s[i] == quote && quote == '\'' && i+1 < len(s) && s[i+1] == '\'':
+			i++
+		case s[i] == quote:
+			return i
+		}
+	}
+	return -1
+}
+
+// columnOffset returns the byte offset in line of a 1-based column, as
+// counted in characters by the decoder.
+func columnOffset(line []byte, column int) (int, bool) {
+	offset := 0
+	for range column - 1 {
+		if offset >= len(line) {
+			return 0, false
+		}
+		_, size := utf8.DecodeRune(line[offset:])
+		offset += size
+	}
+	return offset, true
+}
+
+// keyIndent returns the indentation of the key on a line that starts with
+// prefix, counting sequence entry indicators before the key.
+func keyIndent(prefix []byte) int {
+	n := 0
+	for n < len(prefix) {
+		switch {
+		case prefix[n] == ' ':
+			n++
+		case bytes.HasPrefix(prefix[n:], []byte("- ")):
+			n += 2
+		default:
+			return n
+		}
+	}
+	return n
+}
+
+func leadingSpaces(line []byte) int {
+	return len(line) - len(bytes.TrimLeft(line, " "))
+}
+
+// reindent shifts every line of source but the first by delta spaces,
+// leaving empty lines empty.
+func reindent(source []byte, delta int) []byte {
+	if delta == 0 {
+		return source
+	}
+
+	var b bytes.Buffer
+	for i, line := range bytes.SplitAfter(source, []byte("\n")) {
+		if i > 0 && len(bytes.TrimRight(line, "\r\n")) > 0 {
+			if delta > 0 {
+				b.WriteString(strings.Repeat(" ", delta))
+			} else {
+				line = line[min(-delta, leadingSpaces(line)):]
+			}
+		}
+		b.Write(line)
+	}
+	return b.Bytes()
+}
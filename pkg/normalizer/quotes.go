@@ -0,0 +1,118 @@
+package normalizer
+
+import (
+	"fmt"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// quoteStyles counts the ways that string values in a document are written.
+type quoteStyles struct {
+	counts [3]int
+	// first is the first value written in each style
+	first [3]*yaml.Node
+}
+
+// Indexes into quoteStyles, in order of preference on ties.
+const (
+	quotePlain = iota
+	quoteSingle
+	quoteDouble
+)
+
+var quoteStyleNames = [3]string{"plain", "single-quoted", "double-quoted"}
+
+// checkQuoteConsistency warns if the string values in a document are written
+// in a mix of plain, single-quoted and double-quoted styles. Quoted strings
+// that couldn't be written plain are ignored.
+func checkQuoteConsistency(doc *yaml.Node, opts Options) error {
+	var styles quoteStyles
+	if err := styles.collect(doc, false); err != nil {
+		return err
+	}
+
+	used, majority := 0, quotePlain
+	for style, count := range styles.counts {
+		if count > 0 {
+			used++
+		}
+		if count > styles.counts[majority] {
+			majority = style
+		}
+	}
+	if used < 2 {
+		return nil
+	}
+
+	// Report the first value that isn't in the most common style
+	var odd *yaml.Node
+	oddStyle := 0
+	for style, node := range styles.first {
+		if style == majority || node == nil {
+			continue
+		}
+		if odd == nil || node.Line < odd.Line || (node.Line == odd.Line && node.Column < odd.Column) {
+			odd, oddStyle = node, style
+		}
+	}
+
+	return opts.warn(Warning{
+		Line: odd.Line,
+		Message: fmt.Sprintf("string is %s, but most strings in the document are %s (%d plain, %d single-quoted, %d double-quoted)",
+			quoteStyleNames[oddStyle], quoteStyleNames[majority],
+			styles.counts[quotePlain], styles.counts[quoteSingle], styles.counts[quoteDouble]),
+	})
+}
+
+func (s *quoteStyles) collect(node *yaml.Node, isKey bool) error {
+	if node.Kind == yaml.ScalarNode && !isKey && node.Tag == "!!str" {
+		style := -1
+		switch {
+		case node.Style&yaml.SingleQuotedStyle != 0:
+			style = quoteSingle
+		case node.Style&yaml.DoubleQuotedStyle != 0:
+			style = quoteDouble
+		case node.Style&(yaml.LiteralStyle|yaml.FoldedStyle) == 0:
+			style = quotePlain
+		}
+
+		if style == quoteSingle || style == quoteDouble {
+			needsQuotes, err := needsQuotes(node)
+			if err != nil {
+				return err
+			}
+			if needsQuotes {
+				style = -1
+			}
+		}
+
+		if style >= 0 {
+			s.counts[style]++
+			if s.first[style] == nil {
+				s.first[style] = node
+			}
+		}
+	}
+
+	for i, child := range node.Content {
+		if err := s.collect(child, node.Kind == yaml.MappingNode && i%2 == 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// needsQuotes reports whether a string scalar must be quoted to be read back
+// as the same string.
+func needsQuotes(node *yaml.Node) (bool, error) {
+	plain := yaml.Node{Kind: yaml.ScalarNode, Tag: node.Tag, Value: node.Value}
+	out, err := yaml.Marshal(&plain)
+	if err != nil {
+		return false, err
+	}
+	switch out[0] {
+	case '"', '\'', '|', '>':
+		return true, nil
+	}
+	return false, nil
+}
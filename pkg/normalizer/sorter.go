@@ -8,15 +8,34 @@ import (
 	"slices"
 	"sort"
 	"strconv"
+	"strings"
 	"unicode"
 
 	"go.yaml.in/yaml/v3"
 )
 
-func sortMapKeys(content []*yaml.Node) ([]*yaml.Node, error) {
+// MixedKeyOrder controls how keys of different types are ordered relative to
+// each other in maps with non-string keys.
+type MixedKeyOrder int
+
+const (
+	// MixedKeysByKind orders keys by kind: null, bool, int, float, string,
+	// then complex keys. Integers all sort before floats.
+	MixedKeysByKind MixedKeyOrder = iota
+	// MixedKeysNumbersFirst orders null and bool keys, then integer and float
+	// keys interleaved by value, then strings, then complex keys.
+	MixedKeysNumbersFirst
+	// MixedKeysStringsFirst orders strings first, followed by the same
+	// order as MixedKeysNumbersFirst.
+	MixedKeysStringsFirst
+)
+
+// sortMapKeys sorts the key-value pairs of a mapping's content in-place.
+// Options.KeyOrder only applies if root is set.
+func sortMapKeys(content []*yaml.Node, root bool, opts Options) error {
 	entries := len(content) / 2
 	if entries == 0 {
-		return content, nil
+		return nil
 	}
 
 	// Check if all keys are strings (the overwhelmingly common case).
@@ -31,28 +50,116 @@ func sortMapKeys(content []*yaml.Node) ([]*yaml.Node, error) {
 	}
 
 	if allStrings {
-		return sortStringKeys(content, entries)
+		sortStringKeys(content, entries, opts.GroupKeysByPrefix, opts.SortDescending)
+	} else if err := sortMixedKeys(content, entries, opts.MixedKeyOrder, opts.SortDescending); err != nil {
+		return err
+	}
+
+	if opts.AnchorsFirst {
+		sort.Stable(anchorGroupPairs(content))
+	}
+	if len(opts.LastKeys) > 0 {
+		moveKeysLast(content, opts.LastKeys)
+	}
+	if root && len(opts.KeyOrder) > 0 {
+		moveKeysFirst(content, opts.KeyOrder)
+	}
+	return nil
+}
+
+// anchorGroupPairs orders sorted key-value pairs in-place so that merge keys
+// come first, then pairs that define anchors, then all other pairs. Each
+// group stays in sorted order.
+type anchorGroupPairs []*yaml.Node
+
+func (s anchorGroupPairs) Len() int { return len(s) / 2 }
+
+func (s anchorGroupPairs) Swap(i, j int) { stringKeyPairs(s).Swap(i, j) }
+
+func (s anchorGroupPairs) Less(i, j int) bool {
+	return s.group(i) < s.group(j)
+}
+
+func (s anchorGroupPairs) group(i int) int {
+	key, value := s[i*2], s[i*2+1]
+	switch {
+	case key.Kind == yaml.ScalarNode && key.Tag == "!!merge":
+		return 0
+	case key.Anchor != "" || value.Anchor != "":
+		return 1
+	default:
+		return 2
+	}
+}
+
+// moveKeysFirst moves the pairs with the given keys to the start of content,
+// in the order that the keys are listed, keeping the order of the other
+// pairs.
+func moveKeysFirst(content []*yaml.Node, keys []string) {
+	start := 0
+	for _, key := range keys {
+		for i := start; i+1 < len(content); i += 2 {
+			if content[i].Kind != yaml.ScalarNode || content[i].Value != key {
+				continue
+			}
+			k, v := content[i], content[i+1]
+			copy(content[start+2:], content[start:i])
+			content[start], content[start+1] = k, v
+			start += 2
+			break
+		}
+	}
+}
+
+// moveKeysLast moves the pairs with the given keys to the end of content, in
+// the order that the keys are listed, keeping the order of the other pairs.
+func moveKeysLast(content []*yaml.Node, keys []string) {
+	end := len(content)
+	for _, key := range slices.Backward(keys) {
+		for i := 0; i+1 < end; i += 2 {
+			if content[i].Kind != yaml.ScalarNode || content[i].Value != key {
+				continue
+			}
+			k, v := content[i], content[i+1]
+			copy(content[i:], content[i+2:end])
+			content[end-2], content[end-1] = k, v
+			end -= 2
+			break
+		}
 	}
-	return sortMixedKeys(content, entries)
 }
 
 // sortStringKeys sorts string-keyed maps in-place, avoiding allocations.
-func sortStringKeys(content []*yaml.Node, entries int) ([]*yaml.Node, error) {
+// If groupByPrefix is set, keys are grouped by their dotted prefix.
+func sortStringKeys(content []*yaml.Node, entries int, groupByPrefix, descending bool) {
+	keyCmp := stringNaturalCmp
+	var pairs sort.Interface = stringKeyPairs(content)
+	if groupByPrefix {
+		keyCmp = prefixGroupCmp
+		pairs = prefixGroupedPairs(content)
+	}
+
 	// Check if already sorted
 	sorted := true
 	for i := 1; i < entries; i++ {
-		if stringNaturalCmp(content[(i-1)*2].Value, content[i*2].Value) > 0 {
+		c := keyCmp(content[(i-1)*2].Value, content[i*2].Value)
+		if descending {
+			c = -c
+		}
+		if c > 0 {
 			sorted = false
 			break
 		}
 	}
 	if sorted {
-		return content, nil
+		return
 	}
 
 	// Sort in-place using sort.Interface to swap key-value pairs together
-	sort.Stable(stringKeyPairs(content))
-	return content, nil
+	if descending {
+		pairs = sort.Reverse(pairs)
+	}
+	sort.Stable(pairs)
 }
 
 // stringKeyPairs wraps a content slice to sort key-value pairs in-place.
@@ -70,6 +177,37 @@ func (s stringKeyPairs) Less(i, j int) bool {
 	return stringNaturalCmp(s[i*2].Value, s[j*2].Value) < 0
 }
 
+// prefixGroupedPairs is like stringKeyPairs, but orders keys with
+// prefixGroupCmp.
+type prefixGroupedPairs []*yaml.Node
+
+func (s prefixGroupedPairs) Len() int { return len(s) / 2 }
+
+func (s prefixGroupedPairs) Swap(i, j int) { stringKeyPairs(s).Swap(i, j) }
+
+func (s prefixGroupedPairs) Less(i, j int) bool {
+	return prefixGroupCmp(s[i*2].Value, s[j*2].Value) < 0
+}
+
+// prefixGroupCmp compares keys by the part before the first ".", then by the
+// rest, so that keys with the same prefix, such as "log.level" and
+// "log.format", are kept together. A key equal to the prefix itself comes
+// before the rest of its group.
+func prefixGroupCmp(a, b string) int {
+	aPrefix, aRest, aDotted := strings.Cut(a, ".")
+	bPrefix, bRest, bDotted := strings.Cut(b, ".")
+	if c := stringNaturalCmp(aPrefix, bPrefix); c != 0 {
+		return c
+	}
+	if aDotted != bDotted {
+		if aDotted {
+			return 1
+		}
+		return -1
+	}
+	return stringNaturalCmp(aRest, bRest)
+}
+
 // keyKind represents the type of a map key for sorting purposes.
 type keyKind int
 
@@ -84,7 +222,6 @@ const (
 
 // mixedKey includes complexVal for non-scalar keys.
 type mixedKey struct {
-	index      int
 	kind       keyKind
 	intVal     int64
 	floatVal   float64
@@ -93,32 +230,52 @@ type mixedKey struct {
 }
 
 // sortMixedKeys handles maps with non-scalar keys (rare).
-func sortMixedKeys(content []*yaml.Node, entries int) ([]*yaml.Node, error) {
-	keys := make([]mixedKey, entries)
+func sortMixedKeys(content []*yaml.Node, entries int, order MixedKeyOrder, descending bool) error {
+	pairs := make([]mixedKeyPair, entries)
 	for i := range entries {
-		key, err := makeMixedKey(i, content[i*2])
+		key, err := makeMixedKey(content[i*2])
 		if err != nil {
-			return nil, err
+			return err
 		}
-		keys[i] = key
+		pairs[i] = mixedKeyPair{key: key, keyNode: content[i*2], valueNode: content[i*2+1]}
 	}
 
-	if slices.IsSortedFunc(keys, mixedKeyCmp) {
-		return content, nil
+	keyCmp := mixedKeyCmp
+	if order != MixedKeysByKind {
+		keyCmp = func(a, b mixedKey) int {
+			return mixedKeyOrderCmp(a, b, order)
+		}
+	}
+	pairCmp := func(a, b mixedKeyPair) int {
+		if descending {
+			return keyCmp(b.key, a.key)
+		}
+		return keyCmp(a.key, b.key)
 	}
 
-	slices.SortStableFunc(keys, mixedKeyCmp)
+	if slices.IsSortedFunc(pairs, pairCmp) {
+		return nil
+	}
 
-	newContent := make([]*yaml.Node, len(content))
-	for i := range entries {
-		newContent[i*2] = content[keys[i].index*2]
-		newContent[i*2+1] = content[keys[i].index*2+1]
+	slices.SortStableFunc(pairs, pairCmp)
+
+	// Write the sorted pairs back in-place
+	for i, pair := range pairs {
+		content[i*2] = pair.keyNode
+		content[i*2+1] = pair.valueNode
 	}
-	return newContent, nil
+	return nil
+}
+
+// mixedKeyPair is a key-value pair along with its parsed key.
+type mixedKeyPair struct {
+	key       mixedKey
+	keyNode   *yaml.Node
+	valueNode *yaml.Node
 }
 
-func makeMixedKey(index int, n *yaml.Node) (mixedKey, error) {
-	key := mixedKey{index: index}
+func makeMixedKey(n *yaml.Node) (mixedKey, error) {
+	var key mixedKey
 
 	if n.Kind != yaml.ScalarNode {
 		key.kind = keyKindOther
@@ -182,6 +339,45 @@ func mixedKeyCmp(a, b mixedKey) int {
 	return 0
 }
 
+// mixedKeyOrderCmp compares keys with ints and floats treated as a single
+// kind of number, and optionally with strings before all other keys.
+func mixedKeyOrderCmp(a, b mixedKey, order MixedKeyOrder) int {
+	rank := func(k mixedKey) int {
+		switch k.kind {
+		case keyKindString:
+			if order == MixedKeysStringsFirst {
+				return -1
+			}
+			return 3
+		case keyKindInt, keyKindFloat:
+			return 2
+		case keyKindOther:
+			return 4
+		default:
+			return int(k.kind)
+		}
+	}
+
+	if ra, rb := rank(a), rank(b); ra != rb {
+		return cmp.Compare(ra, rb)
+	}
+	if a.kind != b.kind && (a.kind == keyKindInt || a.kind == keyKindFloat) {
+		// An int and a float: compare by value, with ints first on ties
+		if c := cmp.Compare(a.number(), b.number()); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.kind, b.kind)
+	}
+	return mixedKeyCmp(a, b)
+}
+
+func (k mixedKey) number() float64 {
+	if k.kind == keyKindInt {
+		return float64(k.intVal)
+	}
+	return k.floatVal
+}
+
 // complexCmp compares complex keys using reflection (rare case)
 func complexCmp(a, b reflect.Value) int {
 	a, b = deref(a), deref(b)
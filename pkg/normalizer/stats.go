@@ -0,0 +1,79 @@
+package normalizer
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// TypeCounts is the number of nodes of each type seen during normalization.
+// Scalars are counted by their resolved tag.
+type TypeCounts struct {
+	Str       int
+	Int       int
+	Float     int
+	Bool      int
+	Null      int
+	Other     int
+	Mappings  int
+	Sequences int
+}
+
+// TypeStats tallies the types of nodes in normalized documents. It is safe
+// for concurrent use, so it may be shared between files normalized in
+// parallel.
+type TypeStats struct {
+	mu     sync.Mutex
+	counts TypeCounts
+}
+
+// Counts returns a snapshot of the counts collected so far.
+func (s *TypeStats) Counts() TypeCounts {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.counts
+}
+
+func (s *TypeStats) record(node *yaml.Node) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch node.Kind {
+	case yaml.MappingNode:
+		s.counts.Mappings++
+	case yaml.SequenceNode:
+		s.counts.Sequences++
+	case yaml.ScalarNode:
+		switch node.Tag {
+		case "!!str":
+			s.counts.Str++
+		case "!!int":
+			s.counts.Int++
+		case "!!float":
+			s.counts.Float++
+		case "!!bool":
+			s.counts.Bool++
+		case "!!null":
+			s.counts.Null++
+		default:
+			s.counts.Other++
+		}
+	}
+}
+
+// WriteSummary writes a human-readable summary of the counts to w.
+func (c TypeCounts) WriteSummary(w io.Writer) error {
+	_, err := fmt.Fprintf(w, `scalars:
+  str: %d
+  int: %d
+  float: %d
+  bool: %d
+  null: %d
+  other: %d
+mappings: %d
+sequences: %d
+`, c.Str, c.Int, c.Float, c.Bool, c.Null, c.Other, c.Mappings, c.Sequences)
+	return err
+}
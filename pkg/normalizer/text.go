@@ -0,0 +1,63 @@
+package normalizer
+
+import (
+	"fmt"
+	"io"
+	"unicode"
+	"unicode/utf8"
+)
+
+// textReader passes reads through from r while checking the text of the
+// input. With strict set, the input must be valid UTF-8 without control
+// characters other than tabs and line breaks. With requireLF set, lines must
+// end with LF alone.
+type textReader struct {
+	r         io.Reader
+	strict    bool
+	requireLF bool
+	// offset is the byte offset of the start of pending in the input
+	offset int64
+	// line is the number of line breaks read so far
+	line int
+	// pending holds the bytes of a rune split across reads
+	pending []byte
+	err     error
+}
+
+func (t *textReader) Read(p []byte) (int, error) {
+	if t.err != nil {
+		return 0, t.err
+	}
+
+	n, err := t.r.Read(p)
+	data := append(t.pending, p[:n]...)
+	t.pending = t.pending[:0]
+
+	for i := 0; i < len(data); {
+		r, size := utf8.DecodeRune(data[i:])
+		switch {
+		case r == '\n':
+			t.line++
+		case r == '\r' && t.requireLF:
+			t.err = fmt.Errorf("line %d contains a carriage return, but only LF line endings are allowed", t.line+1)
+			return 0, t.err
+		case !t.strict:
+		case r == utf8.RuneError && size <= 1:
+			if err == nil && !utf8.FullRune(data[i:]) {
+				// The rest of the rune is in the next read
+				t.pending = append(t.pending, data[i:]...)
+				t.offset += int64(i)
+				return n, nil
+			}
+			t.err = fmt.Errorf("invalid UTF-8 at byte offset %d", t.offset+int64(i))
+			return 0, t.err
+		case unicode.IsControl(r) && r != '\t' && r != '\n' && r != '\r':
+			t.err = fmt.Errorf("control character %U at byte offset %d", r, t.offset+int64(i))
+			return 0, t.err
+		}
+		i += size
+	}
+	t.offset += int64(len(data))
+
+	return n, err
+}
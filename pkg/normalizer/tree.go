@@ -0,0 +1,61 @@
+package normalizer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// kindNames are the names of node kinds as written by writeTree.
+var kindNames = map[yaml.Kind]string{
+	yaml.DocumentNode: "DocumentNode",
+	yaml.SequenceNode: "SequenceNode",
+	yaml.MappingNode:  "MappingNode",
+	yaml.ScalarNode:   "ScalarNode",
+	yaml.AliasNode:    "AliasNode",
+}
+
+// writeTree writes an indented description of the document node to w, with
+// one line per node, headed by where. The whole document is written with a
+// single call to Write, so that documents written concurrently don't
+// interleave if w is safe for concurrent use.
+func writeTree(w io.Writer, node *yaml.Node, where string, opts Options) error {
+	var buf bytes.Buffer
+	if opts.file != "" {
+		fmt.Fprintf(&buf, "%s in %s:\n", where, opts.file)
+	} else {
+		fmt.Fprintf(&buf, "%s:\n", where)
+	}
+	appendTree(&buf, node, 1)
+
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to write node tree: %w", err)
+	}
+	return nil
+}
+
+func appendTree(buf *bytes.Buffer, node *yaml.Node, depth int) {
+	buf.WriteString(strings.Repeat("  ", depth))
+	buf.WriteString(kindNames[node.Kind])
+	if node.Tag != "" {
+		buf.WriteString(" tag=" + node.Tag)
+	}
+	if style := styleName(node.Style); style != "" {
+		buf.WriteString(" style=" + strings.ReplaceAll(style, " ", ","))
+	}
+	if node.Anchor != "" {
+		buf.WriteString(" anchor=&" + node.Anchor)
+	}
+	if node.Kind == yaml.ScalarNode || node.Kind == yaml.AliasNode {
+		buf.WriteString(" value=" + strconv.Quote(node.Value))
+	}
+	fmt.Fprintf(buf, " line=%d col=%d\n", node.Line, node.Column)
+
+	for _, child := range node.Content {
+		appendTree(buf, child, depth+1)
+	}
+}
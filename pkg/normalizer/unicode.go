@@ -0,0 +1,93 @@
+package normalizer
+
+import (
+	"strings"
+	"unicode"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// Private use characters are printable as far as the encoder is concerned,
+// and have no meaning of their own, so they can stand in for other characters
+// while encoding.
+const (
+	privateUseFirst = '\uE000'
+	privateUseLast  = '\uF8FF'
+)
+
+// literalUnicode works around the encoder escaping every character outside
+// the Basic Multilingual Plane, such as emoji, by replacing each printable
+// one in node's scalars with a private use character that isn't used
+// anywhere in node. The returned replacer turns the encoded placeholders back
+// into the original characters, and restore undoes the changes to node. If
+// there is nothing to replace, or not enough unused placeholders, the
+// replacer is nil.
+func literalUnicode(node *yaml.Node) (replacer *strings.Replacer, restore func()) {
+	wide := make(map[rune]rune)
+	used := make(map[rune]bool)
+	var scalars []*yaml.Node
+
+	var collect func(node *yaml.Node)
+	collect = func(node *yaml.Node) {
+		for _, s := range []string{node.Anchor, node.Tag, node.HeadComment, node.LineComment, node.FootComment} {
+			for _, r := range s {
+				if r >= privateUseFirst && r <= privateUseLast {
+					used[r] = true
+				}
+			}
+		}
+
+		hasWide := false
+		for _, r := range node.Value {
+			switch {
+			case r >= privateUseFirst && r <= privateUseLast:
+				used[r] = true
+			case r > 0xFFFF && unicode.IsPrint(r):
+				wide[r] = 0
+				hasWide = true
+			}
+		}
+		if hasWide && node.Kind == yaml.ScalarNode {
+			scalars = append(scalars, node)
+		}
+
+		for _, child := range node.Content {
+			collect(child)
+		}
+	}
+	collect(node)
+	if len(scalars) == 0 {
+		return nil, nil
+	}
+
+	next := privateUseFirst
+	oldnew := make([]string, 0, 2*len(wide))
+	for r := range wide {
+		for used[next] {
+			next++
+		}
+		if next > privateUseLast {
+			return nil, nil
+		}
+		wide[r] = next
+		oldnew = append(oldnew, string(next), string(r))
+		next++
+	}
+
+	originals := make([]string, len(scalars))
+	for i, scalar := range scalars {
+		originals[i] = scalar.Value
+		scalar.Value = strings.Map(func(r rune) rune {
+			if placeholder, ok := wide[r]; ok {
+				return placeholder
+			}
+			return r
+		}, scalar.Value)
+	}
+
+	return strings.NewReplacer(oldnew...), func() {
+		for i, scalar := range scalars {
+			scalar.Value = originals[i]
+		}
+	}
+}
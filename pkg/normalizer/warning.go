@@ -0,0 +1,183 @@
+package normalizer
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// Warning is a problem found while normalizing that doesn't prevent the
+// input from being normalized. With Options.Strict, warnings are returned as
+// errors instead.
+type Warning struct {
+	// File is the name of the file the warning was found in, if known.
+	File string
+	// Line is the line that the warning refers to: of the input for checks on
+	// values, or of the output for checks on formatting. It is 0 for warnings
+	// about the file as a whole.
+	Line    int
+	Message string
+}
+
+func (w Warning) Error() string {
+	switch {
+	case w.Line == 0 && w.File != "":
+		return fmt.Sprintf("%s: %s", w.File, w.Message)
+	case w.Line == 0:
+		return w.Message
+	case w.File != "":
+		return fmt.Sprintf("%s:%d: %s", w.File, w.Line, w.Message)
+	default:
+		return fmt.Sprintf("line %d: %s", w.Line, w.Message)
+	}
+}
+
+// warn reports w through o.Warn, or returns it as an error if o.Strict is
+// set.
+func (o Options) warn(w Warning) error {
+	if o.Strict {
+		return w
+	}
+	if o.Warn != nil {
+		o.Warn(w)
+	}
+	return nil
+}
+
+// WithFile returns a copy of the options that attributes warnings passed to
+// Warn, and node trees written to TreeWriter, to filename, for use when
+// normalizing a file with Normalize.
+func (o Options) WithFile(filename string) Options {
+	o.file = filename
+	if warn := o.Warn; warn != nil {
+		o.Warn = func(w Warning) {
+			w.File = filename
+			warn(w)
+		}
+	}
+	return o
+}
+
+// lineLengthWriter passes writes through to w while checking that no line is
+// longer than max characters.
+type lineLengthWriter struct {
+	w      io.Writer
+	opts   Options
+	max    int
+	line   int
+	column int
+	err    error
+}
+
+func (l *lineLengthWriter) Write(p []byte) (int, error) {
+	for _, b := range p {
+		switch {
+		case b == '\n':
+			l.endLine()
+			l.line++
+			l.column = 0
+		case b&0xC0 != 0x80:
+			// Count runes rather than bytes, ignoring UTF-8 continuation bytes
+			l.column++
+		}
+	}
+	return l.w.Write(p)
+}
+
+// endLine checks the length of the current line.
+func (l *lineLengthWriter) endLine() {
+	if l.column <= l.max || l.err != nil {
+		return
+	}
+	l.err = l.opts.warn(Warning{
+		Line:    l.line + 1,
+		Message: fmt.Sprintf("line is %d characters long, exceeding the maximum of %d", l.column, l.max),
+	})
+}
+
+var versionLike = regexp.MustCompile(`^[0-9]+\.[0-9]+$`)
+
+// checkVersionFloat warns about a plain float scalar written like a version
+// number, such as 1.10, that doesn't survive being read as a float.
+func checkVersionFloat(node *yaml.Node, opts Options) error {
+	if node.Kind != yaml.ScalarNode || node.Tag != "!!float" || node.Style != 0 || !versionLike.MatchString(node.Value) {
+		return nil
+	}
+
+	f, err := strconv.ParseFloat(node.Value, 64)
+	if err != nil {
+		return nil
+	}
+	if read := strconv.FormatFloat(f, 'f', -1, 64); read != node.Value {
+		return opts.warn(Warning{
+			Line:    node.Line,
+			Message: fmt.Sprintf("%s is a float and will be read as %s; quote it if it is a version", node.Value, read),
+		})
+	}
+	return nil
+}
+
+// checkCaseCollisions warns about string keys of a mapping at path that are
+// equal when compared case-insensitively, such as Name and name.
+func checkCaseCollisions(node *yaml.Node, path []string, opts Options) error {
+	seen := make(map[string]string, len(node.Content)/2)
+	for i := 0; i < len(node.Content); i += 2 {
+		key := node.Content[i]
+		if key.Kind != yaml.ScalarNode || key.Tag != "!!str" {
+			continue
+		}
+
+		folded := strings.ToLower(key.Value)
+		first, ok := seen[folded]
+		if !ok {
+			seen[folded] = key.Value
+			continue
+		}
+		if first == key.Value {
+			continue
+		}
+		err := opts.warn(Warning{
+			Line:    key.Line,
+			Message: fmt.Sprintf("keys %q and %q in %s differ only by case", first, key.Value, formatPath(path)),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkSingleKeyChains warns about chains of more than limit nested mappings
+// that each have a single key, such as a: {b: {c: value}}, which could be
+// written as one dotted key instead.
+func checkSingleKeyChains(node *yaml.Node, path []string, limit int, opts Options) error {
+	if node.Kind == yaml.MappingNode && len(node.Content) == 2 {
+		start, end := len(path), node
+		for end.Kind == yaml.MappingNode && len(end.Content) == 2 {
+			path = append(path, end.Content[0].Value)
+			end = end.Content[1]
+		}
+		if length := len(path) - start; length > limit {
+			err := opts.warn(Warning{
+				Line: node.Line,
+				Message: fmt.Sprintf("%d nested mappings in %s each have a single key; consider flattening them to %s",
+					length, formatPath(path[:start]), strings.Join(path[start:], ".")),
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return checkSingleKeyChains(end, path, limit, opts)
+	}
+
+	for i, child := range node.Content {
+		if err := checkSingleKeyChains(child, childPath(node, path, i), limit, opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}